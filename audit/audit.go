@@ -0,0 +1,189 @@
+// Package audit keeps the audit trail of security-relevant events: who did
+// what to which account, from where. Events are chained by hash so that
+// tampering with stored events can be detected with Verify.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Account actions.
+const (
+	ActionLoginSucceeded    = "auth.login_succeeded"
+	ActionLoginFailed       = "auth.login_failed"
+	ActionPasswordChanged   = "password.changed"
+	ActionPasswordReset     = "password.reset"
+	ActionEmailChanged      = "email.changed"
+	ActionTokenIssued       = "token.issued"
+	ActionAPIKeyCreated     = "api_key.created"
+	ActionAPIKeyRevoked     = "api_key.revoked"
+	ActionSessionsRevoked   = "sessions.revoked"
+	ActionDeviceRegistered  = "device.registered"
+	ActionDeviceRemoved     = "device.removed"
+	ActionPasskeyAdded      = "passkey.added"
+	ActionPasskeyRemoved    = "passkey.removed"
+	ActionTwoFactorEnabled  = "two_factor.enabled"
+	ActionTwoFactorDisabled = "two_factor.disabled"
+)
+
+// Admin actions.
+const (
+	ActionUsersSearched  = "admin.users_searched"
+	ActionUserViewed     = "admin.user_viewed"
+	ActionRoleChanged    = "admin.role_changed"
+	ActionUserDisconnect = "admin.user_disconnected"
+	ActionUserLocked     = "admin.user_locked"
+	ActionUserUnlocked   = "admin.user_unlocked"
+	ActionTwoFactorReset = "admin.two_factor_reset"
+	ActionAuditExported  = "admin.audit_exported"
+)
+
+// chainLock is the Postgres advisory lock key that serializes appends, so
+// every event links to the one before it.
+const chainLock = 0x61756469 // "audi"
+
+const verifyBatchSize = 1000
+
+// Client is where a request came from.
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// FromRequest returns the client that sent r.
+func FromRequest(r *http.Request) Client {
+	return Client{IP: utils.ClientIP(r), UserAgent: r.UserAgent()}
+}
+
+// Record adds an event from the client that sent r to the audit trail; see
+// Add.
+func Record(r *http.Request, actor *models.User, userID *uuid.UUID, action string, details map[string]interface{}) {
+	Add(FromRequest(r), actor, userID, action, details)
+}
+
+// Add appends an event to the audit trail. actor is who acted and userID the
+// account acted on; either may be nil. Failures are logged, not returned, so
+// they never undo the action itself.
+func Add(client Client, actor *models.User, userID *uuid.UUID, action string, details map[string]interface{}) {
+	event := models.AuditEvent{
+		ID:        uuid.New(), // set here since the hash covers it
+		UserID:    userID,
+		Action:    action,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Details:   "{}",
+		// Postgres keeps microseconds; the hash must match what is stored.
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if actor != nil {
+		event.ActorID = &actor.ID
+	}
+	if len(event.UserAgent) > 255 {
+		event.UserAgent = event.UserAgent[:255]
+	}
+	if details != nil {
+		data, err := json.Marshal(details)
+		if err != nil {
+			log.Printf("Failed to encode audit details for %s: %v", action, err)
+		} else {
+			event.Details = string(data)
+		}
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", chainLock).Error; err != nil {
+			return err
+		}
+		var last models.AuditEvent
+		if err := tx.Select("seq", "hash").Order("seq DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		event.Seq = last.Seq + 1
+		event.PrevHash = last.Hash
+		event.Hash = Hash(&event)
+		return tx.Create(&event).Error
+	})
+	if err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
+
+// Hash returns the chain hash of an event: the hex SHA-256 of its PrevHash
+// and its fields, one per line, in the order below. Times are RFC 3339 in
+// UTC with nanoseconds; absent ids are empty.
+func Hash(e *models.AuditEvent) string {
+	id := func(u *uuid.UUID) string {
+		if u == nil {
+			return ""
+		}
+		return u.String()
+	}
+	h := sha256.New()
+	for _, field := range []string{
+		e.PrevHash,
+		e.ID.String(),
+		id(e.ActorID),
+		id(e.UserID),
+		e.Action,
+		e.IP,
+		e.UserAgent,
+		e.Details,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	} {
+		h.Write([]byte(field))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifyResult is the outcome of Verify.
+type VerifyResult struct {
+	Valid bool `json:"valid"`
+	// Checked is how many events were checked; LastSeq and LastHash are the
+	// last valid ones. Keeping LastHash elsewhere lets a later check detect
+	// events dropped from the end of the chain.
+	Checked  int    `json:"checked"`
+	LastSeq  int64  `json:"last_seq"`
+	LastHash string `json:"last_hash"`
+	// BrokenSeq is the first event that was changed, or follows a removed
+	// one.
+	BrokenSeq int64 `json:"broken_seq,omitempty"`
+}
+
+// Verify walks the whole chain and reports the first event that does not
+// match its hash or does not follow the previous event.
+func Verify() (*VerifyResult, error) {
+	result := &VerifyResult{Valid: true}
+	for {
+		var batch []models.AuditEvent
+		err := db.DB.Where("seq > ?", result.LastSeq).Order("seq").Limit(verifyBatchSize).Find(&batch).Error
+		if err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			e := &batch[i]
+			if e.Seq != result.LastSeq+1 || e.PrevHash != result.LastHash || Hash(e) != e.Hash {
+				result.Valid = false
+				result.BrokenSeq = e.Seq
+				return result, nil
+			}
+			result.Checked++
+			result.LastSeq = e.Seq
+			result.LastHash = e.Hash
+		}
+		if len(batch) < verifyBatchSize {
+			return result, nil
+		}
+	}
+}
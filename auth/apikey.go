@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/utils"
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix marks bearer tokens that are API keys rather than JWTs.
+const APIKeyPrefix = "csk_"
+
+// apiKeyTouchInterval limits how often last_used_at is written.
+const apiKeyTouchInterval = time.Minute
+
+var ErrAPIKeyExpired = errors.New("API key has expired")
+
+// GenerateAPIKey returns a new random API key.
+func GenerateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Authenticate resolves a bearer token, either a session JWT (full or
+// scoped) or an API key.
+func Authenticate(tokenStr string) (*Grant, error) {
+	if !strings.HasPrefix(tokenStr, APIKeyPrefix) {
+		user, claims, err := sessionFromToken(tokenStr)
+		if err != nil {
+			return nil, err
+		}
+		scopes := claims.Scopes
+		if scopes == nil {
+			scopes = AllScopes
+		}
+		return &Grant{User: user, Scopes: scopes}, nil
+	}
+
+	var key models.APIKey
+	if err := db.DB.Where("key_hash = ?", utils.HashSecret(tokenStr)).First(&key).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	var user models.User
+	if err := db.DB.Where("id = ?", key.UserID).First(&user).Error; err != nil {
+		return nil, err
+	}
+	if user.LockedAt != nil {
+		return nil, ErrAccountLocked
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval {
+		db.DB.Model(&key).Update("last_used_at", now)
+	}
+
+	return &Grant{
+		User:   &user,
+		Scopes: strings.Fields(key.Scopes),
+		APIKey: &key,
+	}, nil
+}
+
+// RevokeAPIKeys deletes every API key of the user and returns them, so that
+// resetting a password or revoking sessions also locks out whoever held a
+// key. Live connections must be closed separately (see ws.RevokeAPIKey).
+func RevokeAPIKeys(tx *gorm.DB, user *models.User) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	if err := tx.Where("user_id = ?", user.ID).Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	if err := tx.Delete(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"clipsync.com/m/config"
+	"clipsync.com/m/models"
+)
+
+// Roles give staff access to the admin API. Regular users have none.
+const (
+	RoleAdmin   = "admin"   // every permission
+	RoleSupport = "support" // helps users with their accounts
+)
+
+// Permissions are checked by RequirePermission.
+const (
+	PermUsersRead   = "users:read"   // search users, see devices, connections and the audit trail
+	PermUsersManage = "users:manage" // disconnect, lock and unlock accounts, reset 2FA
+	PermRolesManage = "roles:manage" // grant and remove roles
+	PermServer      = "server"       // server-wide settings: global webhooks, organizations
+)
+
+var rolePermissions = map[string][]string{
+	RoleAdmin:   {PermUsersRead, PermUsersManage, PermRolesManage, PermServer},
+	RoleSupport: {PermUsersRead, PermUsersManage},
+}
+
+// ValidRole reports whether role can be granted; "" removes a user's role.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok || role == ""
+}
+
+// RoleOf returns the user's effective role. Verified emails listed in
+// config.AdminEmails are always admins, so a fresh server has someone to
+// grant the first roles.
+func RoleOf(user *models.User) string {
+	if user.EmailVerified {
+		for _, email := range config.AdminEmails {
+			if strings.EqualFold(strings.TrimSpace(email), user.Email) {
+				return RoleAdmin
+			}
+		}
+	}
+	return user.Role
+}
+
+// Can reports whether the user's role grants perm.
+func Can(user *models.User, perm string) bool {
+	for _, p := range rolePermissions[RoleOf(user)] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether user is an admin.
+func IsAdmin(user *models.User) bool {
+	return RoleOf(user) == RoleAdmin
+}
+
+// RequirePermission is Required for endpoints only staff whose role grants
+// perm may call.
+func RequirePermission(perm string, next http.HandlerFunc) http.HandlerFunc {
+	return Required(func(w http.ResponseWriter, r *http.Request) {
+		if !Can(UserFromContext(r.Context()), perm) {
+			http.Error(w, "Missing the "+perm+" permission", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// RequireAdmin is Required for server-wide admin endpoints.
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return RequirePermission(PermServer, next)
+}
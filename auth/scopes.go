@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"clipsync.com/m/models"
+)
+
+// Scopes limit what a credential may do. Sessions from signing in carry all
+// of them; scoped tokens and API keys carry a subset of DelegableScopes.
+const (
+	// ScopeAccount manages the account itself: profile, password, 2FA,
+	// passkeys, API keys, pairing and minting scoped tokens.
+	ScopeAccount     = "account"
+	ScopeClipsRead   = "clips:read"  // receive clips and read history
+	ScopeClipsWrite  = "clips:write" // send clips
+	ScopeDevicesRead = "devices:read"
+)
+
+var (
+	AllScopes       = []string{ScopeAccount, ScopeClipsRead, ScopeClipsWrite, ScopeDevicesRead}
+	DelegableScopes = []string{ScopeClipsRead, ScopeClipsWrite, ScopeDevicesRead}
+)
+
+// Grant describes an authenticated request: the user and what it may do.
+type Grant struct {
+	User   *models.User
+	Scopes []string
+	// APIKey is set when the request used an API key.
+	APIKey *models.APIKey
+}
+
+// Allows reports whether the grant includes scope.
+func (g *Grant) Allows(scope string) bool {
+	for _, s := range g.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidScope reports whether scope can be granted to a scoped token or an
+// API key.
+func ValidScope(scope string) bool {
+	for _, s := range DelegableScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
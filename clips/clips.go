@@ -0,0 +1,90 @@
+// Package clips stores clipboard history. Payloads up to
+// config.ClipInlineLimit are kept in Postgres; larger ones are written to
+// files under config.BlobDir, one directory per user.
+package clips
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"github.com/google/uuid"
+)
+
+var ErrTooLarge = errors.New("clip exceeds the maximum size")
+
+func userBlobDir(userID uuid.UUID) string {
+	return filepath.Join(config.BlobDir, userID.String())
+}
+
+func blobPath(userID uuid.UUID, blobKey string) string {
+	return filepath.Join(userBlobDir(userID), blobKey)
+}
+
+// Save stores clip, whose metadata the caller has filled in, with payload as
+// its content. A new id is assigned if clip has none.
+func Save(clip *models.Clip, payload []byte) error {
+	if len(payload) > config.MaxClipSize {
+		return ErrTooLarge
+	}
+	if clip.ID == uuid.Nil {
+		clip.ID = uuid.New()
+	}
+	clip.Size = len(payload)
+
+	if len(payload) <= config.ClipInlineLimit {
+		clip.Content = payload
+	} else {
+		clip.BlobKey = clip.ID.String()
+		if err := os.MkdirAll(userBlobDir(clip.UserID), 0o700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(blobPath(clip.UserID, clip.BlobKey), payload, 0o600); err != nil {
+			return err
+		}
+	}
+
+	if err := db.DB.Create(clip).Error; err != nil {
+		if clip.BlobKey != "" {
+			os.Remove(blobPath(clip.UserID, clip.BlobKey))
+		}
+		return err
+	}
+	if Searchable(clip) {
+		// The clip is kept either way; StartIndexer retries on restart.
+		if err := index(clip, payload); err != nil {
+			log.Printf("Failed to index clip %s: %v", clip.ID, err)
+		}
+	}
+	return nil
+}
+
+// Content returns the clip's payload, reading it from the blob store if needed.
+func Content(clip *models.Clip) ([]byte, error) {
+	if clip.BlobKey == "" {
+		return clip.Content, nil
+	}
+	return os.ReadFile(blobPath(clip.UserID, clip.BlobKey))
+}
+
+// DeleteBlob removes a clip's blob file, if it has one.
+func DeleteBlob(clip *models.Clip) error {
+	if clip.BlobKey == "" {
+		return nil
+	}
+	err := os.Remove(blobPath(clip.UserID, clip.BlobKey))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// DeleteUserBlobs removes every blob belonging to the user. Callers delete
+// the clip rows themselves, usually inside a transaction.
+func DeleteUserBlobs(userID uuid.UUID) error {
+	return os.RemoveAll(userBlobDir(userID))
+}
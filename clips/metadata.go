@@ -0,0 +1,104 @@
+package clips
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrTooManyTags = fmt.Errorf("a clip can have at most %d tags", config.ClipMaxTags)
+
+// Metadata is what a user can set on a clip in their history or inbox. It is
+// returned by the API and sent to devices when it changes.
+type Metadata struct {
+	ID       uuid.UUID `json:"id"`
+	Pinned   bool      `json:"pinned"`
+	Favorite bool      `json:"favorite"`
+	Tags     []string  `json:"tags"`
+}
+
+// NormalizeTag returns tag trimmed and lowercased, or an error if it is
+// empty, too long or contains whitespace.
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" || len(tag) > config.ClipTagMaxLength {
+		return "", fmt.Errorf("tags must be 1 to %d characters", config.ClipTagMaxLength)
+	}
+	if strings.IndexFunc(tag, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return "", errors.New("tags cannot contain spaces")
+	}
+	return tag, nil
+}
+
+// Tags returns the tags of the given clips, sorted, by clip id.
+func Tags(clipIDs []uuid.UUID) (map[uuid.UUID][]string, error) {
+	byClip := make(map[uuid.UUID][]string, len(clipIDs))
+	if len(clipIDs) == 0 {
+		return byClip, nil
+	}
+	var tags []models.ClipTag
+	if err := db.DB.Where("clip_id IN ?", clipIDs).Order("tag").Find(&tags).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range tags {
+		byClip[t.ClipID] = append(byClip[t.ClipID], t.Tag)
+	}
+	return byClip, nil
+}
+
+// SetTags replaces the tags of clip with tags, which must be normalized.
+func SetTags(tx *gorm.DB, clip *models.Clip, tags []string) error {
+	if err := tx.Where("clip_id = ?", clip.ID).Delete(&models.ClipTag{}).Error; err != nil {
+		return err
+	}
+	return AddTags(tx, clip, tags)
+}
+
+// AddTags adds tags, which must be normalized, to clip. Tags it already has
+// are ignored.
+func AddTags(tx *gorm.DB, clip *models.Clip, tags []string) error {
+	for _, tag := range tags {
+		err := tx.Exec("INSERT INTO clip_tags (clip_id, tag, user_id) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+			clip.ID, tag, clip.UserID).Error
+		if err != nil {
+			return err
+		}
+	}
+	var count int64
+	if err := tx.Model(&models.ClipTag{}).Where("clip_id = ?", clip.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > int64(config.ClipMaxTags) {
+		return ErrTooManyTags
+	}
+	return nil
+}
+
+// WithTags narrows a query on clips to those that have every tag in tags,
+// which must be normalized.
+func WithTags(query *gorm.DB, tags []string) *gorm.DB {
+	for _, tag := range tags {
+		query = query.Where("EXISTS (SELECT 1 FROM clip_tags WHERE clip_tags.clip_id = clips.id AND clip_tags.tag = ?)", tag)
+	}
+	return query
+}
+
+// LoadMetadata returns clip's metadata.
+func LoadMetadata(clip *models.Clip) (*Metadata, error) {
+	tags, err := Tags([]uuid.UUID{clip.ID})
+	if err != nil {
+		return nil, err
+	}
+	meta := &Metadata{ID: clip.ID, Pinned: clip.Pinned, Favorite: clip.Favorite, Tags: tags[clip.ID]}
+	if meta.Tags == nil {
+		meta.Tags = []string{}
+	}
+	return meta, nil
+}
@@ -0,0 +1,173 @@
+package clips
+
+import (
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"github.com/google/uuid"
+)
+
+// headlineOptions configures ts_headline: up to three fragments around the
+// matches, which are wrapped in <mark>.
+const headlineOptions = `StartSel=<mark>, StopSel=</mark>, MaxFragments=3, MaxWords=20, MinWords=5, FragmentDelimiter=" … "`
+
+// indexBatchSize is how many clips StartIndexer indexes per query.
+const indexBatchSize = 200
+
+// Searchable reports whether clip is indexed for full-text search: a text
+// clip in its owner's history that the server can read.
+func Searchable(clip *models.Clip) bool {
+	return clip.SpaceID == nil && clip.SenderID == nil && !clip.Encrypted &&
+		strings.HasPrefix(strings.ToLower(clip.ContentType), "text/")
+}
+
+// searchText is the part of payload that is indexed: at most
+// config.SearchIndexLimit bytes of valid UTF-8 without NUL bytes, which
+// Postgres text cannot hold.
+func searchText(payload []byte) string {
+	if len(payload) > config.SearchIndexLimit {
+		payload = payload[:config.SearchIndexLimit]
+	}
+	return strings.ReplaceAll(strings.ToValidUTF8(string(payload), ""), "\x00", "")
+}
+
+// index stores the search vector of a searchable clip.
+func index(clip *models.Clip, payload []byte) error {
+	return db.DB.Exec(`INSERT INTO clip_searches (clip_id, vector) VALUES (?, to_tsvector(?::regconfig, ?))
+		ON CONFLICT (clip_id) DO NOTHING`, clip.ID, config.SearchLanguage, searchText(payload)).Error
+}
+
+// StartIndexer indexes, in the background, searchable clips stored before
+// search was enabled or whose indexing failed.
+func StartIndexer() {
+	go func() {
+		var lastID uuid.UUID
+		total := 0
+		for {
+			var batch []models.Clip
+			err := db.DB.Where("id > ? AND space_id IS NULL AND sender_id IS NULL AND NOT encrypted AND LOWER(content_type) LIKE 'text/%'", lastID).
+				Where("NOT EXISTS (SELECT 1 FROM clip_searches WHERE clip_searches.clip_id = clips.id)").
+				Order("id").Limit(indexBatchSize).Find(&batch).Error
+			if err != nil {
+				log.Printf("Failed to load clips to index: %v", err)
+				return
+			}
+			for i := range batch {
+				lastID = batch[i].ID
+				content, err := Content(&batch[i])
+				if err == nil {
+					err = index(&batch[i], content)
+				}
+				if err != nil {
+					log.Printf("Failed to index clip %s: %v", batch[i].ID, err)
+					continue
+				}
+				total++
+			}
+			if len(batch) < indexBatchSize {
+				if total > 0 {
+					log.Printf("Indexed %d clips for search", total)
+				}
+				return
+			}
+		}
+	}()
+}
+
+// SearchQuery selects clips in a user's history. Text is a web search style
+// query: words, "quoted phrases", OR and -excluded words. The other fields
+// are optional filters.
+type SearchQuery struct {
+	UserID      uuid.UUID
+	Text        string
+	DeviceID    string
+	ContentType string   // media type, parameters ignored
+	Tags        []string // normalized; clips must have all of them
+	From, To    *time.Time
+	Limit       int
+	Offset      int
+}
+
+// SearchResult is a matching clip, without its content. Highlight holds
+// HTML-escaped excerpts of the content with the matches in <mark>.
+type SearchResult struct {
+	models.Clip
+	Rank      float64
+	Highlight string
+}
+
+// Search returns the clips matching q, best matches first.
+func Search(q SearchQuery) ([]SearchResult, error) {
+	query := db.DB.Model(&models.Clip{}).
+		Select("clips.*, ts_rank_cd(clip_searches.vector, q) AS rank").
+		Joins("JOIN clip_searches ON clip_searches.clip_id = clips.id").
+		Joins("CROSS JOIN websearch_to_tsquery(?::regconfig, ?) AS q", config.SearchLanguage, q.Text).
+		Where("clip_searches.vector @@ q").
+		Where("clips.user_id = ? AND clips.space_id IS NULL AND clips.sender_id IS NULL", q.UserID)
+	if q.DeviceID != "" {
+		query = query.Where("clips.device_id = ?", q.DeviceID)
+	}
+	if q.ContentType != "" {
+		query = query.Where("LOWER(TRIM(SPLIT_PART(clips.content_type, ';', 1))) = ?", strings.ToLower(q.ContentType))
+	}
+	query = WithTags(query, q.Tags)
+	if q.From != nil {
+		query = query.Where("clips.created_at >= ?", *q.From)
+	}
+	if q.To != nil {
+		query = query.Where("clips.created_at < ?", *q.To)
+	}
+
+	var results []SearchResult
+	err := query.Order("rank DESC, clips.created_at DESC").Limit(q.Limit).Offset(q.Offset).
+		Scan(&results).Error
+	if err != nil || len(results) == 0 {
+		return results, err
+	}
+	if err := highlight(results, q.Text); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// highlight fills in the Highlight of results, in one query, and drops
+// their content.
+func highlight(results []SearchResult, text string) error {
+	values := make([]string, 0, len(results))
+	args := []interface{}{config.SearchLanguage, config.SearchLanguage, text, headlineOptions}
+	for i := range results {
+		content, err := Content(&results[i].Clip)
+		if err != nil {
+			log.Printf("Failed to read clip %s for search: %v", results[i].ID, err)
+		}
+		// Escaped first, so the excerpts are safe to show as HTML; the
+		// search parser skips the entities.
+		values = append(values, "(?, ?)")
+		args = append(args, results[i].ID.String(), html.EscapeString(searchText(content)))
+		results[i].Content = nil
+	}
+
+	var rows []struct {
+		ID        string
+		Highlight string
+	}
+	err := db.DB.Raw(`SELECT d.id, ts_headline(?::regconfig, d.doc, websearch_to_tsquery(?::regconfig, ?), ?) AS highlight
+		FROM (VALUES `+strings.Join(values, ", ")+`) AS d(id, doc)`, args...).
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]string, len(rows))
+	for _, row := range rows {
+		byID[row.ID] = row.Highlight
+	}
+	for i := range results {
+		results[i].Highlight = byID[results[i].ID.String()]
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+// Command mockoidc is a minimal OpenID Connect provider for trying out and
+// testing ClipSync's single sign-on locally. It signs in whoever types an
+// email address, so never expose it outside a development machine.
+//
+//	go run ./cmd/mockoidc -addr :9000
+//
+// Then set config.OIDCEnabled = true; the other OIDC defaults match it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"clipsync.com/m/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL (must match how ClipSync reaches this server)")
+	clientID := flag.String("client-id", "clipsync", "accepted client ID")
+	clientSecret := flag.String("client-secret", "clipsync-secret", "accepted client secret")
+	flag.Parse()
+
+	p, err := oidctest.NewProvider(*issuer, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatal("Failed to generate signing key: ", err)
+	}
+
+	fmt.Printf("Mock OIDC provider running on %s (issuer %s)\n", *addr, p.Issuer())
+	log.Fatal(http.ListenAndServe(*addr, p))
+}
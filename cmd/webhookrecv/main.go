@@ -0,0 +1,69 @@
+// Command webhookrecv is a local webhook receiver for trying out and testing
+// ClipSync webhooks. It verifies each request's signature and prints the
+// event. With -fail N it answers the first N requests with 500, to exercise
+// retries and the dead-letter list.
+//
+//	go run ./cmd/webhookrecv -addr :9100 -secret whsec_...
+//
+// Register http://localhost:9100/ as the webhook URL. User webhooks need
+// config.WebhookAllowPrivateNetworks = true to reach localhost.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"clipsync.com/m/webhooks"
+)
+
+func main() {
+	addr := flag.String("addr", ":9100", "listen address")
+	secret := flag.String("secret", "", "webhook signing secret; signatures are not checked if empty")
+	fail := flag.Int64("fail", 0, "answer this many requests with 500 before succeeding")
+	flag.Parse()
+
+	var received atomic.Int64
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "Error reading body", http.StatusBadRequest)
+			return
+		}
+
+		n := received.Add(1)
+		event := r.Header.Get("X-ClipSync-Event")
+		delivery := r.Header.Get("X-ClipSync-Delivery")
+		if *secret != "" {
+			if err := webhooks.Verify(*secret, r.Header.Get("X-ClipSync-Signature"), body, 5*time.Minute); err != nil {
+				log.Printf("#%d %s (delivery %s): rejected: %v", n, event, delivery, err)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		if n <= *fail {
+			log.Printf("#%d %s (delivery %s): failing on purpose", n, event, delivery)
+			http.Error(w, "Failing on purpose", http.StatusInternalServerError)
+			return
+		}
+
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, body, "", "  ") != nil {
+			pretty.Write(body)
+		}
+		log.Printf("#%d %s (delivery %s):\n%s", n, event, delivery, pretty.String())
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	log.Printf("Webhook receiver listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
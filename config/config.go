@@ -1,6 +1,9 @@
 package config
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 var (
 	DBUser     = "clipboard"
@@ -10,6 +13,199 @@ var (
 	DBPort     = 5432
 )
 
+// WebAuthn relying party settings. RPID must match the host the client pages
+// are served from, and every origin passkeys are used on must be listed.
+var (
+	WebAuthnRPID      = "localhost"
+	WebAuthnRPName    = "ClipSync"
+	WebAuthnRPOrigins = []string{"http://localhost:8080"}
+)
+
+// PublicURL is where users reach the server, without a trailing slash. Links
+// in emails, share URLs and pairing QR codes are built from it, never from the
+// request's Host header. Set it to the https:// address when running behind a
+// TLS-terminating proxy.
+var PublicURL = "http://localhost:8080"
+
+// Outgoing mail. MailDriver is "log" (print to stdout) or "smtp".
+var (
+	MailDriver   = "log"
+	MailFrom     = "ClipSync <no-reply@clipsync.local>"
+	SMTPHost     = "localhost"
+	SMTPPort     = 1025
+	SMTPUsername = ""
+	SMTPPassword = ""
+)
+
+// Email verification. Unverified accounts can sign in and sync, but the
+// actions below are refused until the address is confirmed.
+var (
+	EmailVerificationTTL            = 24 * time.Hour
+	EmailVerificationResendInterval = time.Minute
+	EmailVerificationMaxResends     = 10 // per day
+	RequireVerifiedEmailForPairing  = true
+	RequireVerifiedEmailForSharing  = true
+)
+
+// Password reset limits. Attempts are counted per email and per client IP
+// over PasswordResetWindow; exceeding either locks further attempts until the
+// window expires.
+var (
+	PasswordResetCodeTTL        = 10 * time.Minute
+	PasswordResetWindow         = 15 * time.Minute
+	PasswordResetMaxPerEmail    = 5
+	PasswordResetMaxPerIP       = 20
+	PasswordResetRequestSpacing = time.Minute
+)
+
+// Rate limiting. RateLimitBackend is "redis" (shared across instances, with
+// an in-memory fallback if Redis is unreachable) or "memory" for single-node
+// deployments. TrustedProxies is how many reverse proxies in front of the
+// server append to X-Forwarded-For; client IPs are read that many entries
+// from its right. Leave it at 0 without a proxy, since clients can forge the
+// header.
+var (
+	RateLimitBackend = "redis"
+	TrustedProxies   = 0
+)
+
+// OpenID Connect single sign-on. Provider accounts are linked to existing
+// users by verified email; with OIDCAutoCreate, unknown emails get a new
+// account on first sign-in.
+var (
+	OIDCEnabled      = false
+	OIDCProviderName = "Company SSO"
+	OIDCIssuerURL    = "http://localhost:9000"
+	OIDCClientID     = "clipsync"
+	OIDCClientSecret = "clipsync-secret"
+	OIDCRedirectURL  = "http://localhost:8080/oidc/callback"
+	OIDCAutoCreate   = true
+	OIDCLoginCodeTTL = time.Minute     // one-time code exchanged for the session token
+	OIDCReauthWindow = 5 * time.Minute // how long an SSO sign-in confirms sensitive changes for password-less accounts
+)
+
+// Client callbacks. /login-client and /oidc/login only send users back to a
+// redirect_uri whose scheme, host and path exactly match one of
+// ClientRedirectURIs. Anything else is refused. Without a redirect_uri they
+// use DefaultClientRedirectURI, the local tray app's callback.
+var (
+	DefaultClientRedirectURI = "http://localhost:8000/callback"
+	ClientRedirectURIs       = []string{DefaultClientRedirectURI}
+)
+
+// Scoped tokens minted via /tokens, e.g. send-only tokens for CI jobs.
+var (
+	ScopedTokenDefaultTTL = 24 * time.Hour
+	ScopedTokenMaxTTL     = 30 * 24 * time.Hour
+)
+
+// Clip history. Payloads up to ClipInlineLimit are stored in Postgres; larger
+// ones are written to BlobDir.
+var (
+	MaxClipSize     = 2 * 1024 * 1024
+	ClipInlineLimit = 64 * 1024
+	BlobDir         = "data/blobs"
+)
+
+// Clip metadata. Users can pin, favorite and tag the clips in their history
+// and inbox, with up to ClipMaxTags tags of at most ClipTagMaxLength bytes.
+var (
+	ClipMaxTags      = 20
+	ClipTagMaxLength = 32
+)
+
+// Full-text search over clip history. Text clips are indexed with the
+// SearchLanguage text search configuration; "simple" doesn't drop stop words
+// or stem, which suits code and commands. Only the first SearchIndexLimit
+// bytes of a clip are indexed.
+var (
+	SearchLanguage   = "simple"
+	SearchIndexLimit = 256 * 1024
+)
+
+// History retention. Every RetentionInterval, clips past their user's or
+// organization's retention policy are deleted RetentionBatchSize at a time,
+// pausing RetentionBatchPause between batches so purges don't hold up
+// clients.
+var (
+	RetentionInterval   = time.Hour
+	RetentionBatchSize  = 500
+	RetentionBatchPause = 100 * time.Millisecond
+)
+
+// gRPC API for native clients. Set both TLS files to serve over TLS;
+// otherwise terminate TLS in front of GRPCAddr.
+var (
+	GRPCEnabled     = true
+	GRPCAddr        = ":9090"
+	GRPCTLSCertFile = ""
+	GRPCTLSKeyFile  = ""
+)
+
+// Shared spaces. Creating spaces and inviting members also needs a verified
+// email when RequireVerifiedEmailForSharing is set.
+var (
+	SpacesMaxPerUser = 20 // memberships, including spaces the user owns
+	SpaceMaxMembers  = 50
+)
+
+// Clips sent directly to other users. Users can only send to accepted
+// contacts; ContactMaxPendingRequests caps the unanswered requests a user can
+// have out at once, to limit spam. Beyond InboxMaxClips, the oldest received
+// clips are deleted.
+var (
+	ContactsMaxPerUser        = 500
+	ContactMaxPendingRequests = 20
+	InboxMaxClips             = 500
+)
+
+// Public share links. A link lasts ShareLinkDefaultTTL unless its creator
+// asks for another lifetime, up to ShareLinkMaxTTL. End-to-end encrypted
+// clips cannot be shared.
+var (
+	ShareLinkDefaultTTL  = 24 * time.Hour
+	ShareLinkMaxTTL      = 30 * 24 * time.Hour
+	ShareLinksMaxPerUser = 100 // active links
+)
+
+// Organizations. Invitations expire after OrgInvitationTTL. Only server
+// admins set an organization's auto-join domain, and never to one of
+// PublicEmailDomains, which anyone can sign up at.
+var (
+	OrgInvitationTTL   = 7 * 24 * time.Hour
+	PublicEmailDomains = []string{
+		"gmail.com", "googlemail.com", "outlook.com", "hotmail.com", "live.com", "msn.com",
+		"yahoo.com", "ymail.com", "icloud.com", "me.com", "mac.com", "aol.com",
+		"proton.me", "protonmail.com", "gmx.com", "gmx.de", "gmx.net", "web.de",
+		"mail.com", "yandex.com", "yandex.ru", "mail.ru", "zoho.com", "qq.com", "163.com",
+	}
+)
+
+// Outgoing webhooks. Failed deliveries are retried after
+// WebhookRetryBaseDelay, doubling each time, and moved to the dead-letter list
+// after WebhookMaxAttempts. Webhooks registered by users may not target
+// loopback or private addresses unless WebhookAllowPrivateNetworks is set.
+var (
+	WebhookTimeout              = 10 * time.Second
+	WebhookMaxAttempts          = 8
+	WebhookRetryBaseDelay       = 30 * time.Second
+	WebhookWorkers              = 4
+	WebhookMaxPerUser           = 20
+	WebhookDeliveryRetention    = 30 * 24 * time.Hour
+	WebhookAllowPrivateNetworks = false
+)
+
+// Admins, by email. These verified addresses always have the admin role,
+// whatever their stored role, so a new server has someone to grant roles.
+var AdminEmails = []string{}
+
+// Account deletion. A deletion request can be cancelled during the grace
+// period; after it the purge worker removes all of the account's data.
+var (
+	AccountDeletionGracePeriod = 7 * 24 * time.Hour
+	AccountPurgeInterval       = time.Hour
+)
+
 func GetDBConnectionString() string {
 	return fmt.Sprintf("host=%s port=%d user=%s dbname=%s password=%s sslmode=disable",
 		DBHost, DBPort, DBUser, DBName, DBPassword)
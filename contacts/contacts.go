@@ -0,0 +1,56 @@
+// Package contacts controls who may send clips directly to whom. Users ask
+// each other by email; once a request is accepted, either user can send clips
+// to the other's inbox. Blocking a user stops their requests and clips.
+package contacts
+
+import (
+	"errors"
+
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusAccepted = "accepted"
+	StatusBlocked  = "blocked"
+)
+
+var ErrNotContact = errors.New("clips can only be sent to your contacts")
+
+// Between returns the entry linking two users, whichever of them made the
+// request, or gorm.ErrRecordNotFound.
+func Between(a, b uuid.UUID) (*models.Contact, error) {
+	var contact models.Contact
+	err := db.DB.Where("(user_id = ? AND contact_id = ?) OR (user_id = ? AND contact_id = ?)", a, b, b, a).
+		First(&contact).Error
+	if err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// CheckSend returns nil if sender may send clips to recipient right now. It
+// is called for every clip, so removing or blocking a contact applies
+// immediately.
+func CheckSend(senderID, recipientID uuid.UUID) error {
+	contact, err := Between(senderID, recipientID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotContact
+	}
+	if err != nil {
+		return err
+	}
+	if contact.Status != StatusAccepted {
+		return ErrNotContact
+	}
+	return nil
+}
+
+// DeleteAll removes every entry involving the user, including blocks in
+// either direction.
+func DeleteAll(tx *gorm.DB, userID uuid.UUID) error {
+	return tx.Where("user_id = ? OR contact_id = ?", userID, userID).Delete(&models.Contact{}).Error
+}
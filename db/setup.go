@@ -21,7 +21,7 @@ func ConnectDB() {
 	DB = database
 
 	// Auto-migrate the models
-	database.AutoMigrate(&models.User{})
+	database.AutoMigrate(&models.User{}, &models.Device{}, &models.RecoveryCode{}, &models.WebAuthnCredential{}, &models.Identity{}, &models.APIKey{}, &models.Clip{}, &models.ClipSearch{}, &models.ClipTag{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.Space{}, &models.SpaceMember{}, &models.Contact{}, &models.ShareLink{}, &models.ShareLinkAccess{}, &models.Organization{}, &models.OrgInvitation{}, &models.AuditEvent{})
 }
 
 var RedisClient *redis.Client
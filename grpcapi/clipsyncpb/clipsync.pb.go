@@ -0,0 +1,887 @@
+// ClipSync gRPC API for native clients.
+//
+// Regenerate the Go code in grpcapi/clipsyncpb after editing:
+//
+//	protoc -I proto \
+//	       --go_out=. --go_opt=module=clipsync.com/m \
+//	       --go-grpc_out=. --go-grpc_opt=module=clipsync.com/m \
+//	       clipsync/v1/clipsync.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v5.29.3
+// source: clipsync/v1/clipsync.proto
+
+package clipsyncpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Clip is a clip as gRPC clients see it. Other transports use their own
+// JSON form.
+type Clip struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Server-assigned id; empty on clips a device sends.
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Device the clip came from.
+	DeviceId    string `protobuf:"bytes,2,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	ContentType string `protobuf:"bytes,3,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	// True when payload is end-to-end encrypted ciphertext.
+	Encrypted bool `protobuf:"varint,4,opt,name=encrypted,proto3" json:"encrypted,omitempty"`
+	// Omitted by ListClips for clips stored as blobs; fetch those with GetClip.
+	Payload   []byte                 `protobuf:"bytes,5,opt,name=payload,proto3" json:"payload,omitempty"`
+	CreatedAt *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	// Payload size in bytes, set even when payload is omitted.
+	Size int64 `protobuf:"varint,7,opt,name=size,proto3" json:"size,omitempty"`
+	// Space the clip was shared in; empty for the user's own clips.
+	SpaceId       string `protobuf:"bytes,8,opt,name=space_id,json=spaceId,proto3" json:"space_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Clip) Reset() {
+	*x = Clip{}
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Clip) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Clip) ProtoMessage() {}
+
+func (x *Clip) ProtoReflect() protoreflect.Message {
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Clip.ProtoReflect.Descriptor instead.
+func (*Clip) Descriptor() ([]byte, []int) {
+	return file_clipsync_v1_clipsync_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Clip) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Clip) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *Clip) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *Clip) GetEncrypted() bool {
+	if x != nil {
+		return x.Encrypted
+	}
+	return false
+}
+
+func (x *Clip) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *Clip) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Clip) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *Clip) GetSpaceId() string {
+	if x != nil {
+		return x.SpaceId
+	}
+	return ""
+}
+
+type Device struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId   string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	Name       string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	PairedFrom string                 `protobuf:"bytes,3,opt,name=paired_from,json=pairedFrom,proto3" json:"paired_from,omitempty"`
+	CreatedAt  *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	// Connected to any server instance and able to receive clips.
+	Online        bool `protobuf:"varint,5,opt,name=online,proto3" json:"online,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Device) Reset() {
+	*x = Device{}
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Device) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Device) ProtoMessage() {}
+
+func (x *Device) ProtoReflect() protoreflect.Message {
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Device.ProtoReflect.Descriptor instead.
+func (*Device) Descriptor() ([]byte, []int) {
+	return file_clipsync_v1_clipsync_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Device) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *Device) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Device) GetPairedFrom() string {
+	if x != nil {
+		return x.PairedFrom
+	}
+	return ""
+}
+
+func (x *Device) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Device) GetOnline() bool {
+	if x != nil {
+		return x.Online
+	}
+	return false
+}
+
+type LoginRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Email         string                 `protobuf:"bytes,1,opt,name=email,proto3" json:"email,omitempty"`
+	Password      string                 `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	TotpCode      string                 `protobuf:"bytes,3,opt,name=totp_code,json=totpCode,proto3" json:"totp_code,omitempty"`
+	RecoveryCode  string                 `protobuf:"bytes,4,opt,name=recovery_code,json=recoveryCode,proto3" json:"recovery_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LoginRequest) Reset() {
+	*x = LoginRequest{}
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LoginRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LoginRequest) ProtoMessage() {}
+
+func (x *LoginRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LoginRequest.ProtoReflect.Descriptor instead.
+func (*LoginRequest) Descriptor() ([]byte, []int) {
+	return file_clipsync_v1_clipsync_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *LoginRequest) GetEmail() string {
+	if x != nil {
+		return x.Email
+	}
+	return ""
+}
+
+func (x *LoginRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+func (x *LoginRequest) GetTotpCode() string {
+	if x != nil {
+		return x.TotpCode
+	}
+	return ""
+}
+
+func (x *LoginRequest) GetRecoveryCode() string {
+	if x != nil {
+		return x.RecoveryCode
+	}
+	return ""
+}
+
+type LoginResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Token         string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LoginResponse) Reset() {
+	*x = LoginResponse{}
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LoginResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LoginResponse) ProtoMessage() {}
+
+func (x *LoginResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LoginResponse.ProtoReflect.Descriptor instead.
+func (*LoginResponse) Descriptor() ([]byte, []int) {
+	return file_clipsync_v1_clipsync_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *LoginResponse) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+type ListDevicesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDevicesRequest) Reset() {
+	*x = ListDevicesRequest{}
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDevicesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesRequest) ProtoMessage() {}
+
+func (x *ListDevicesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesRequest.ProtoReflect.Descriptor instead.
+func (*ListDevicesRequest) Descriptor() ([]byte, []int) {
+	return file_clipsync_v1_clipsync_proto_rawDescGZIP(), []int{4}
+}
+
+type ListDevicesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Devices       []*Device              `protobuf:"bytes,1,rep,name=devices,proto3" json:"devices,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDevicesResponse) Reset() {
+	*x = ListDevicesResponse{}
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDevicesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesResponse) ProtoMessage() {}
+
+func (x *ListDevicesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesResponse.ProtoReflect.Descriptor instead.
+func (*ListDevicesResponse) Descriptor() ([]byte, []int) {
+	return file_clipsync_v1_clipsync_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListDevicesResponse) GetDevices() []*Device {
+	if x != nil {
+		return x.Devices
+	}
+	return nil
+}
+
+type ListClipsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// At most 100; defaults to 50.
+	PageSize int32 `protobuf:"varint,1,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	// next_page_token from the previous response.
+	PageToken string `protobuf:"bytes,2,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	// Only clips sent from this device.
+	DeviceId string `protobuf:"bytes,3,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	// List the clips shared in this space instead of the user's own.
+	SpaceId       string `protobuf:"bytes,4,opt,name=space_id,json=spaceId,proto3" json:"space_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListClipsRequest) Reset() {
+	*x = ListClipsRequest{}
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListClipsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListClipsRequest) ProtoMessage() {}
+
+func (x *ListClipsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListClipsRequest.ProtoReflect.Descriptor instead.
+func (*ListClipsRequest) Descriptor() ([]byte, []int) {
+	return file_clipsync_v1_clipsync_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListClipsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListClipsRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+func (x *ListClipsRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *ListClipsRequest) GetSpaceId() string {
+	if x != nil {
+		return x.SpaceId
+	}
+	return ""
+}
+
+type ListClipsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Newest first.
+	Clips         []*Clip `protobuf:"bytes,1,rep,name=clips,proto3" json:"clips,omitempty"`
+	NextPageToken string  `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListClipsResponse) Reset() {
+	*x = ListClipsResponse{}
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListClipsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListClipsResponse) ProtoMessage() {}
+
+func (x *ListClipsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListClipsResponse.ProtoReflect.Descriptor instead.
+func (*ListClipsResponse) Descriptor() ([]byte, []int) {
+	return file_clipsync_v1_clipsync_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListClipsResponse) GetClips() []*Clip {
+	if x != nil {
+		return x.Clips
+	}
+	return nil
+}
+
+func (x *ListClipsResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+type GetClipRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetClipRequest) Reset() {
+	*x = GetClipRequest{}
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetClipRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetClipRequest) ProtoMessage() {}
+
+func (x *GetClipRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetClipRequest.ProtoReflect.Descriptor instead.
+func (*GetClipRequest) Descriptor() ([]byte, []int) {
+	return file_clipsync_v1_clipsync_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *GetClipRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type SyncRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Message:
+	//
+	//	*SyncRequest_Clip
+	//	*SyncRequest_Control
+	Message       isSyncRequest_Message `protobuf_oneof:"message"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SyncRequest) Reset() {
+	*x = SyncRequest{}
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncRequest) ProtoMessage() {}
+
+func (x *SyncRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncRequest.ProtoReflect.Descriptor instead.
+func (*SyncRequest) Descriptor() ([]byte, []int) {
+	return file_clipsync_v1_clipsync_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *SyncRequest) GetMessage() isSyncRequest_Message {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *SyncRequest) GetClip() *Clip {
+	if x != nil {
+		if x, ok := x.Message.(*SyncRequest_Clip); ok {
+			return x.Clip
+		}
+	}
+	return nil
+}
+
+func (x *SyncRequest) GetControl() []byte {
+	if x != nil {
+		if x, ok := x.Message.(*SyncRequest_Control); ok {
+			return x.Control
+		}
+	}
+	return nil
+}
+
+type isSyncRequest_Message interface {
+	isSyncRequest_Message()
+}
+
+type SyncRequest_Clip struct {
+	// A clip to publish to the user's other devices.
+	Clip *Clip `protobuf:"bytes,1,opt,name=clip,proto3,oneof"`
+}
+
+type SyncRequest_Control struct {
+	// A JSON control message, e.g. pair_confirm.
+	Control []byte `protobuf:"bytes,2,opt,name=control,proto3,oneof"`
+}
+
+func (*SyncRequest_Clip) isSyncRequest_Message() {}
+
+func (*SyncRequest_Control) isSyncRequest_Message() {}
+
+type SyncResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Message:
+	//
+	//	*SyncResponse_Clip
+	//	*SyncResponse_Control
+	Message       isSyncResponse_Message `protobuf_oneof:"message"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SyncResponse) Reset() {
+	*x = SyncResponse{}
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncResponse) ProtoMessage() {}
+
+func (x *SyncResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_clipsync_v1_clipsync_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncResponse.ProtoReflect.Descriptor instead.
+func (*SyncResponse) Descriptor() ([]byte, []int) {
+	return file_clipsync_v1_clipsync_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *SyncResponse) GetMessage() isSyncResponse_Message {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *SyncResponse) GetClip() *Clip {
+	if x != nil {
+		if x, ok := x.Message.(*SyncResponse_Clip); ok {
+			return x.Clip
+		}
+	}
+	return nil
+}
+
+func (x *SyncResponse) GetControl() []byte {
+	if x != nil {
+		if x, ok := x.Message.(*SyncResponse_Control); ok {
+			return x.Control
+		}
+	}
+	return nil
+}
+
+type isSyncResponse_Message interface {
+	isSyncResponse_Message()
+}
+
+type SyncResponse_Clip struct {
+	Clip *Clip `protobuf:"bytes,1,opt,name=clip,proto3,oneof"`
+}
+
+type SyncResponse_Control struct {
+	// A JSON control message, as received over the WebSocket (e.g. pair_request).
+	Control []byte `protobuf:"bytes,2,opt,name=control,proto3,oneof"`
+}
+
+func (*SyncResponse_Clip) isSyncResponse_Message() {}
+
+func (*SyncResponse_Control) isSyncResponse_Message() {}
+
+var File_clipsync_v1_clipsync_proto protoreflect.FileDescriptor
+
+const file_clipsync_v1_clipsync_proto_rawDesc = "" +
+	"\n" +
+	"\x1aclipsync/v1/clipsync.proto\x12\vclipsync.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xf8\x01\n" +
+	"\x04Clip\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
+	"\tdevice_id\x18\x02 \x01(\tR\bdeviceId\x12!\n" +
+	"\fcontent_type\x18\x03 \x01(\tR\vcontentType\x12\x1c\n" +
+	"\tencrypted\x18\x04 \x01(\bR\tencrypted\x12\x18\n" +
+	"\apayload\x18\x05 \x01(\fR\apayload\x129\n" +
+	"\n" +
+	"created_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x12\x12\n" +
+	"\x04size\x18\a \x01(\x03R\x04size\x12\x19\n" +
+	"\bspace_id\x18\b \x01(\tR\aspaceId\"\xad\x01\n" +
+	"\x06Device\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x1f\n" +
+	"\vpaired_from\x18\x03 \x01(\tR\n" +
+	"pairedFrom\x129\n" +
+	"\n" +
+	"created_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x12\x16\n" +
+	"\x06online\x18\x05 \x01(\bR\x06online\"\x82\x01\n" +
+	"\fLoginRequest\x12\x14\n" +
+	"\x05email\x18\x01 \x01(\tR\x05email\x12\x1a\n" +
+	"\bpassword\x18\x02 \x01(\tR\bpassword\x12\x1b\n" +
+	"\ttotp_code\x18\x03 \x01(\tR\btotpCode\x12#\n" +
+	"\rrecovery_code\x18\x04 \x01(\tR\frecoveryCode\"%\n" +
+	"\rLoginResponse\x12\x14\n" +
+	"\x05token\x18\x01 \x01(\tR\x05token\"\x14\n" +
+	"\x12ListDevicesRequest\"D\n" +
+	"\x13ListDevicesResponse\x12-\n" +
+	"\adevices\x18\x01 \x03(\v2\x13.clipsync.v1.DeviceR\adevices\"\x86\x01\n" +
+	"\x10ListClipsRequest\x12\x1b\n" +
+	"\tpage_size\x18\x01 \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x02 \x01(\tR\tpageToken\x12\x1b\n" +
+	"\tdevice_id\x18\x03 \x01(\tR\bdeviceId\x12\x19\n" +
+	"\bspace_id\x18\x04 \x01(\tR\aspaceId\"d\n" +
+	"\x11ListClipsResponse\x12'\n" +
+	"\x05clips\x18\x01 \x03(\v2\x11.clipsync.v1.ClipR\x05clips\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\" \n" +
+	"\x0eGetClipRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"]\n" +
+	"\vSyncRequest\x12'\n" +
+	"\x04clip\x18\x01 \x01(\v2\x11.clipsync.v1.ClipH\x00R\x04clip\x12\x1a\n" +
+	"\acontrol\x18\x02 \x01(\fH\x00R\acontrolB\t\n" +
+	"\amessage\"^\n" +
+	"\fSyncResponse\x12'\n" +
+	"\x04clip\x18\x01 \x01(\v2\x11.clipsync.v1.ClipH\x00R\x04clip\x12\x1a\n" +
+	"\acontrol\x18\x02 \x01(\fH\x00R\acontrolB\t\n" +
+	"\amessage2\xe4\x02\n" +
+	"\bClipSync\x12>\n" +
+	"\x05Login\x12\x19.clipsync.v1.LoginRequest\x1a\x1a.clipsync.v1.LoginResponse\x12P\n" +
+	"\vListDevices\x12\x1f.clipsync.v1.ListDevicesRequest\x1a .clipsync.v1.ListDevicesResponse\x12J\n" +
+	"\tListClips\x12\x1d.clipsync.v1.ListClipsRequest\x1a\x1e.clipsync.v1.ListClipsResponse\x129\n" +
+	"\aGetClip\x12\x1b.clipsync.v1.GetClipRequest\x1a\x11.clipsync.v1.Clip\x12?\n" +
+	"\x04Sync\x12\x18.clipsync.v1.SyncRequest\x1a\x19.clipsync.v1.SyncResponse(\x010\x01B#Z!clipsync.com/m/grpcapi/clipsyncpbb\x06proto3"
+
+var (
+	file_clipsync_v1_clipsync_proto_rawDescOnce sync.Once
+	file_clipsync_v1_clipsync_proto_rawDescData []byte
+)
+
+func file_clipsync_v1_clipsync_proto_rawDescGZIP() []byte {
+	file_clipsync_v1_clipsync_proto_rawDescOnce.Do(func() {
+		file_clipsync_v1_clipsync_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_clipsync_v1_clipsync_proto_rawDesc), len(file_clipsync_v1_clipsync_proto_rawDesc)))
+	})
+	return file_clipsync_v1_clipsync_proto_rawDescData
+}
+
+var file_clipsync_v1_clipsync_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_clipsync_v1_clipsync_proto_goTypes = []any{
+	(*Clip)(nil),                  // 0: clipsync.v1.Clip
+	(*Device)(nil),                // 1: clipsync.v1.Device
+	(*LoginRequest)(nil),          // 2: clipsync.v1.LoginRequest
+	(*LoginResponse)(nil),         // 3: clipsync.v1.LoginResponse
+	(*ListDevicesRequest)(nil),    // 4: clipsync.v1.ListDevicesRequest
+	(*ListDevicesResponse)(nil),   // 5: clipsync.v1.ListDevicesResponse
+	(*ListClipsRequest)(nil),      // 6: clipsync.v1.ListClipsRequest
+	(*ListClipsResponse)(nil),     // 7: clipsync.v1.ListClipsResponse
+	(*GetClipRequest)(nil),        // 8: clipsync.v1.GetClipRequest
+	(*SyncRequest)(nil),           // 9: clipsync.v1.SyncRequest
+	(*SyncResponse)(nil),          // 10: clipsync.v1.SyncResponse
+	(*timestamppb.Timestamp)(nil), // 11: google.protobuf.Timestamp
+}
+var file_clipsync_v1_clipsync_proto_depIdxs = []int32{
+	11, // 0: clipsync.v1.Clip.created_at:type_name -> google.protobuf.Timestamp
+	11, // 1: clipsync.v1.Device.created_at:type_name -> google.protobuf.Timestamp
+	1,  // 2: clipsync.v1.ListDevicesResponse.devices:type_name -> clipsync.v1.Device
+	0,  // 3: clipsync.v1.ListClipsResponse.clips:type_name -> clipsync.v1.Clip
+	0,  // 4: clipsync.v1.SyncRequest.clip:type_name -> clipsync.v1.Clip
+	0,  // 5: clipsync.v1.SyncResponse.clip:type_name -> clipsync.v1.Clip
+	2,  // 6: clipsync.v1.ClipSync.Login:input_type -> clipsync.v1.LoginRequest
+	4,  // 7: clipsync.v1.ClipSync.ListDevices:input_type -> clipsync.v1.ListDevicesRequest
+	6,  // 8: clipsync.v1.ClipSync.ListClips:input_type -> clipsync.v1.ListClipsRequest
+	8,  // 9: clipsync.v1.ClipSync.GetClip:input_type -> clipsync.v1.GetClipRequest
+	9,  // 10: clipsync.v1.ClipSync.Sync:input_type -> clipsync.v1.SyncRequest
+	3,  // 11: clipsync.v1.ClipSync.Login:output_type -> clipsync.v1.LoginResponse
+	5,  // 12: clipsync.v1.ClipSync.ListDevices:output_type -> clipsync.v1.ListDevicesResponse
+	7,  // 13: clipsync.v1.ClipSync.ListClips:output_type -> clipsync.v1.ListClipsResponse
+	0,  // 14: clipsync.v1.ClipSync.GetClip:output_type -> clipsync.v1.Clip
+	10, // 15: clipsync.v1.ClipSync.Sync:output_type -> clipsync.v1.SyncResponse
+	11, // [11:16] is the sub-list for method output_type
+	6,  // [6:11] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_clipsync_v1_clipsync_proto_init() }
+func file_clipsync_v1_clipsync_proto_init() {
+	if File_clipsync_v1_clipsync_proto != nil {
+		return
+	}
+	file_clipsync_v1_clipsync_proto_msgTypes[9].OneofWrappers = []any{
+		(*SyncRequest_Clip)(nil),
+		(*SyncRequest_Control)(nil),
+	}
+	file_clipsync_v1_clipsync_proto_msgTypes[10].OneofWrappers = []any{
+		(*SyncResponse_Clip)(nil),
+		(*SyncResponse_Control)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_clipsync_v1_clipsync_proto_rawDesc), len(file_clipsync_v1_clipsync_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_clipsync_v1_clipsync_proto_goTypes,
+		DependencyIndexes: file_clipsync_v1_clipsync_proto_depIdxs,
+		MessageInfos:      file_clipsync_v1_clipsync_proto_msgTypes,
+	}.Build()
+	File_clipsync_v1_clipsync_proto = out.File
+	file_clipsync_v1_clipsync_proto_goTypes = nil
+	file_clipsync_v1_clipsync_proto_depIdxs = nil
+}
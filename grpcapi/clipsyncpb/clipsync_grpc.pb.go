@@ -0,0 +1,307 @@
+// ClipSync gRPC API for native clients.
+//
+// Regenerate the Go code in grpcapi/clipsyncpb after editing:
+//
+//	protoc -I proto \
+//	       --go_out=. --go_opt=module=clipsync.com/m \
+//	       --go-grpc_out=. --go-grpc_opt=module=clipsync.com/m \
+//	       clipsync/v1/clipsync.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: clipsync/v1/clipsync.proto
+
+package clipsyncpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ClipSync_Login_FullMethodName       = "/clipsync.v1.ClipSync/Login"
+	ClipSync_ListDevices_FullMethodName = "/clipsync.v1.ClipSync/ListDevices"
+	ClipSync_ListClips_FullMethodName   = "/clipsync.v1.ClipSync/ListClips"
+	ClipSync_GetClip_FullMethodName     = "/clipsync.v1.ClipSync/GetClip"
+	ClipSync_Sync_FullMethodName        = "/clipsync.v1.ClipSync/Sync"
+)
+
+// ClipSyncClient is the client API for ClipSync service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ClipSync exposes account sign-in, devices and clip history as unary RPCs,
+// and live sync as a bidirectional stream.
+//
+// Every RPC except Login needs an "authorization: Bearer <token>" metadata
+// entry holding a session JWT, scoped token or API key.
+type ClipSyncClient interface {
+	// Login exchanges email and password (plus a second factor when 2FA is
+	// enabled) for a session token. When the second factor is missing or
+	// wrong, it fails with UNAUTHENTICATED and a "two-factor-required: true"
+	// response header.
+	Login(ctx context.Context, in *LoginRequest, opts ...grpc.CallOption) (*LoginResponse, error)
+	// ListDevices needs the devices:read scope.
+	ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error)
+	// ListClips and GetClip need the clips:read scope.
+	ListClips(ctx context.Context, in *ListClipsRequest, opts ...grpc.CallOption) (*ListClipsResponse, error)
+	GetClip(ctx context.Context, in *GetClipRequest, opts ...grpc.CallOption) (*Clip, error)
+	// Sync connects a device to the sync hub like a WebSocket does. The
+	// device id is passed as "device-id" metadata. Clips sent need
+	// clips:write; clips are received with clips:read.
+	Sync(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[SyncRequest, SyncResponse], error)
+}
+
+type clipSyncClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewClipSyncClient(cc grpc.ClientConnInterface) ClipSyncClient {
+	return &clipSyncClient{cc}
+}
+
+func (c *clipSyncClient) Login(ctx context.Context, in *LoginRequest, opts ...grpc.CallOption) (*LoginResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LoginResponse)
+	err := c.cc.Invoke(ctx, ClipSync_Login_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clipSyncClient) ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDevicesResponse)
+	err := c.cc.Invoke(ctx, ClipSync_ListDevices_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clipSyncClient) ListClips(ctx context.Context, in *ListClipsRequest, opts ...grpc.CallOption) (*ListClipsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListClipsResponse)
+	err := c.cc.Invoke(ctx, ClipSync_ListClips_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clipSyncClient) GetClip(ctx context.Context, in *GetClipRequest, opts ...grpc.CallOption) (*Clip, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Clip)
+	err := c.cc.Invoke(ctx, ClipSync_GetClip_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clipSyncClient) Sync(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[SyncRequest, SyncResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ClipSync_ServiceDesc.Streams[0], ClipSync_Sync_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SyncRequest, SyncResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClipSync_SyncClient = grpc.BidiStreamingClient[SyncRequest, SyncResponse]
+
+// ClipSyncServer is the server API for ClipSync service.
+// All implementations must embed UnimplementedClipSyncServer
+// for forward compatibility.
+//
+// ClipSync exposes account sign-in, devices and clip history as unary RPCs,
+// and live sync as a bidirectional stream.
+//
+// Every RPC except Login needs an "authorization: Bearer <token>" metadata
+// entry holding a session JWT, scoped token or API key.
+type ClipSyncServer interface {
+	// Login exchanges email and password (plus a second factor when 2FA is
+	// enabled) for a session token. When the second factor is missing or
+	// wrong, it fails with UNAUTHENTICATED and a "two-factor-required: true"
+	// response header.
+	Login(context.Context, *LoginRequest) (*LoginResponse, error)
+	// ListDevices needs the devices:read scope.
+	ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error)
+	// ListClips and GetClip need the clips:read scope.
+	ListClips(context.Context, *ListClipsRequest) (*ListClipsResponse, error)
+	GetClip(context.Context, *GetClipRequest) (*Clip, error)
+	// Sync connects a device to the sync hub like a WebSocket does. The
+	// device id is passed as "device-id" metadata. Clips sent need
+	// clips:write; clips are received with clips:read.
+	Sync(grpc.BidiStreamingServer[SyncRequest, SyncResponse]) error
+	mustEmbedUnimplementedClipSyncServer()
+}
+
+// UnimplementedClipSyncServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedClipSyncServer struct{}
+
+func (UnimplementedClipSyncServer) Login(context.Context, *LoginRequest) (*LoginResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Login not implemented")
+}
+func (UnimplementedClipSyncServer) ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDevices not implemented")
+}
+func (UnimplementedClipSyncServer) ListClips(context.Context, *ListClipsRequest) (*ListClipsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListClips not implemented")
+}
+func (UnimplementedClipSyncServer) GetClip(context.Context, *GetClipRequest) (*Clip, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetClip not implemented")
+}
+func (UnimplementedClipSyncServer) Sync(grpc.BidiStreamingServer[SyncRequest, SyncResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Sync not implemented")
+}
+func (UnimplementedClipSyncServer) mustEmbedUnimplementedClipSyncServer() {}
+func (UnimplementedClipSyncServer) testEmbeddedByValue()                  {}
+
+// UnsafeClipSyncServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ClipSyncServer will
+// result in compilation errors.
+type UnsafeClipSyncServer interface {
+	mustEmbedUnimplementedClipSyncServer()
+}
+
+func RegisterClipSyncServer(s grpc.ServiceRegistrar, srv ClipSyncServer) {
+	// If the following call pancis, it indicates UnimplementedClipSyncServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ClipSync_ServiceDesc, srv)
+}
+
+func _ClipSync_Login_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoginRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClipSyncServer).Login(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClipSync_Login_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClipSyncServer).Login(ctx, req.(*LoginRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClipSync_ListDevices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDevicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClipSyncServer).ListDevices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClipSync_ListDevices_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClipSyncServer).ListDevices(ctx, req.(*ListDevicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClipSync_ListClips_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListClipsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClipSyncServer).ListClips(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClipSync_ListClips_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClipSyncServer).ListClips(ctx, req.(*ListClipsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClipSync_GetClip_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetClipRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClipSyncServer).GetClip(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClipSync_GetClip_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClipSyncServer).GetClip(ctx, req.(*GetClipRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClipSync_Sync_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ClipSyncServer).Sync(&grpc.GenericServerStream[SyncRequest, SyncResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClipSync_SyncServer = grpc.BidiStreamingServer[SyncRequest, SyncResponse]
+
+// ClipSync_ServiceDesc is the grpc.ServiceDesc for ClipSync service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ClipSync_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "clipsync.v1.ClipSync",
+	HandlerType: (*ClipSyncServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Login",
+			Handler:    _ClipSync_Login_Handler,
+		},
+		{
+			MethodName: "ListDevices",
+			Handler:    _ClipSync_ListDevices_Handler,
+		},
+		{
+			MethodName: "ListClips",
+			Handler:    _ClipSync_ListClips_Handler,
+		},
+		{
+			MethodName: "GetClip",
+			Handler:    _ClipSync_GetClip_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Sync",
+			Handler:       _ClipSync_Sync_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "clipsync/v1/clipsync.proto",
+}
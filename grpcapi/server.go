@@ -0,0 +1,203 @@
+// Package grpcapi serves the ClipSync gRPC API (proto/clipsync/v1) for native
+// clients. It shares credentials, scopes and rate limits with the HTTP API,
+// and Sync streams join ws.Server like any other transport.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
+	"clipsync.com/m/grpcapi/clipsyncpb"
+	"clipsync.com/m/ratelimit"
+	"clipsync.com/m/ws"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// methodScopes is the scope each RPC needs. Login needs none, and Sync
+// checks clips:read and clips:write itself, like the WebSocket handler.
+var methodScopes = map[string]string{
+	clipsyncpb.ClipSync_ListDevices_FullMethodName: auth.ScopeDevicesRead,
+	clipsyncpb.ClipSync_ListClips_FullMethodName:   auth.ScopeClipsRead,
+	clipsyncpb.ClipSync_GetClip_FullMethodName:     auth.ScopeClipsRead,
+}
+
+type service struct {
+	clipsyncpb.UnimplementedClipSyncServer
+	hub *ws.Server
+}
+
+// Serve listens on config.GRPCAddr and serves the API. It only returns if the
+// listener fails.
+func Serve(hub *ws.Server) error {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptor),
+		grpc.ChainStreamInterceptor(streamInterceptor),
+		grpc.MaxRecvMsgSize(config.MaxClipSize + 64*1024),
+		// Ping idle clients so dead Sync streams are noticed and unregistered.
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    ws.HeartbeatInterval,
+			Timeout: 20 * time.Second,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             15 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	}
+	if config.GRPCTLSCertFile != "" && config.GRPCTLSKeyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(config.GRPCTLSCertFile, config.GRPCTLSKeyFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	lis, err := net.Listen("tcp", config.GRPCAddr)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer(opts...)
+	clipsyncpb.RegisterClipSyncServer(srv, &service{hub: hub})
+	log.Printf("gRPC API listening on %s", config.GRPCAddr)
+	return srv.Serve(lis)
+}
+
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// peerClient describes the caller for the audit trail.
+func peerClient(ctx context.Context) audit.Client {
+	client := audit.Client{IP: peerIP(ctx)}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ua := md.Get("user-agent"); len(ua) > 0 {
+		client.UserAgent = ua[0]
+	}
+	return client
+}
+
+// limitCall applies the HTTP rate limit rules to a call: the per-IP and
+// server-wide limits, authentication failures per IP, and for Login
+// failures per account.
+func limitCall(ctx context.Context, email string) (func(failed bool), error) {
+	ip := peerIP(ctx)
+	rules := []ratelimit.Rule{ratelimit.PerIP, ratelimit.PerServer, ratelimit.AuthFailuresPerIP}
+	keys := []string{ip, "all", ip}
+	if email != "" {
+		account := ratelimit.EmailKey(email)
+		rules = append(rules, ratelimit.AuthFailuresPerAccount, ratelimit.AuthFailuresPerIPAccount)
+		keys = append(keys, account, ratelimit.IPAccountKey(ip, account))
+	}
+
+	done, retryAfter := ratelimit.Attempt(rules, keys)
+	if retryAfter > 0 {
+		return nil, status.Errorf(codes.ResourceExhausted, "Too many requests, try again in %s", retryAfter.Round(time.Second))
+	}
+	return done, nil
+}
+
+// isFailure mirrors the HTTP statuses the FailuresOnly rules count.
+func isFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.PermissionDenied, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// authenticate resolves the "authorization: Bearer <token>" metadata entry
+// and checks the method's scope, if it has one.
+func authenticate(ctx context.Context, method string) (*auth.Grant, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "Missing token")
+	}
+	tokenStr, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok || tokenStr == "" {
+		return nil, status.Error(codes.Unauthenticated, "Missing token")
+	}
+
+	grant, err := auth.Authenticate(tokenStr)
+	if errors.Is(err, auth.ErrAccountLocked) {
+		return nil, status.Error(codes.PermissionDenied, "Account is locked")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid or expired token")
+	}
+	if scope, ok := methodScopes[method]; ok && !grant.Allows(scope) {
+		return nil, status.Error(codes.PermissionDenied, "Token is missing the "+scope+" scope")
+	}
+	return grant, nil
+}
+
+func unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var email string
+	if login, ok := req.(*clipsyncpb.LoginRequest); ok {
+		email = login.GetEmail()
+	}
+	done, err := limitCall(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.FullMethod != clipsyncpb.ClipSync_Login_FullMethodName {
+		grant, err := authenticate(ctx, info.FullMethod)
+		if err != nil {
+			done(true)
+			return nil, err
+		}
+		ctx = auth.WithGrant(ctx, grant)
+	}
+
+	resp, err := handler(ctx, req)
+	done(isFailure(err))
+	return resp, err
+}
+
+// grantStream hands the authenticated context to a stream handler.
+type grantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grantStream) Context() context.Context {
+	return s.ctx
+}
+
+func streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	done, err := limitCall(ss.Context(), "")
+	if err != nil {
+		return err
+	}
+
+	grant, err := authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		done(true)
+		return err
+	}
+
+	err = handler(srv, &grantStream{ServerStream: ss, ctx: auth.WithGrant(ss.Context(), grant)})
+	done(isFailure(err))
+	return err
+}
@@ -0,0 +1,202 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/clips"
+	"clipsync.com/m/db"
+	"clipsync.com/m/grpcapi/clipsyncpb"
+	"clipsync.com/m/handlers"
+	"clipsync.com/m/models"
+	"clipsync.com/m/spaces"
+	"clipsync.com/m/ws"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultClipPageSize = 50
+	maxClipPageSize     = 100
+)
+
+func (s *service) Login(ctx context.Context, req *clipsyncpb.LoginRequest) (*clipsyncpb.LoginResponse, error) {
+	token, err := handlers.PasswordLogin(handlers.LoginRequest{
+		Email:        req.GetEmail(),
+		Password:     req.GetPassword(),
+		TOTPCode:     req.GetTotpCode(),
+		RecoveryCode: req.GetRecoveryCode(),
+		Client:       peerClient(ctx),
+	})
+	if errors.Is(err, handlers.ErrInvalidCredentials) {
+		return nil, status.Error(codes.Unauthenticated, "Invalid credentials")
+	}
+	if errors.Is(err, auth.ErrAccountLocked) {
+		return nil, status.Error(codes.PermissionDenied, "Account is locked")
+	}
+	if errors.Is(err, handlers.ErrTwoFactorRequired) {
+		grpc.SetHeader(ctx, metadata.Pairs("two-factor-required", "true"))
+		return nil, status.Error(codes.Unauthenticated, "Two-factor code required")
+	}
+	if err != nil {
+		log.Println("gRPC login failed:", err)
+		return nil, status.Error(codes.Internal, "Error generating token")
+	}
+	return &clipsyncpb.LoginResponse{Token: token}, nil
+}
+
+func (s *service) ListDevices(ctx context.Context, req *clipsyncpb.ListDevicesRequest) (*clipsyncpb.ListDevicesResponse, error) {
+	user := auth.UserFromContext(ctx)
+
+	var devices []models.Device
+	if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&devices).Error; err != nil {
+		return nil, status.Error(codes.Internal, "Error loading devices")
+	}
+
+	online, err := ws.OnlineDevices(user.ID.String())
+	if err != nil {
+		log.Printf("Failed to load presence for user %s: %v", user.ID, err)
+	}
+	isOnline := make(map[string]bool, len(online))
+	for _, deviceID := range online {
+		isOnline[deviceID] = true
+	}
+
+	resp := &clipsyncpb.ListDevicesResponse{}
+	for _, d := range devices {
+		resp.Devices = append(resp.Devices, &clipsyncpb.Device{
+			DeviceId:   d.DeviceID,
+			Name:       d.Name,
+			PairedFrom: d.PairedFrom,
+			CreatedAt:  timestamppb.New(d.CreatedAt),
+			Online:     isOnline[d.DeviceID],
+		})
+	}
+	return resp, nil
+}
+
+// encodePageToken and decodePageToken turn the last clip of a page into an
+// opaque cursor, so pages stay stable while new clips arrive.
+func encodePageToken(c *models.Clip) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.Format(time.RFC3339Nano) + " " + c.ID.String()))
+}
+
+func decodePageToken(token string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	createdAt, id, ok := strings.Cut(string(raw), " ")
+	if !ok {
+		return time.Time{}, uuid.Nil, errors.New("malformed page token")
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	clipID, err := uuid.Parse(id)
+	return t, clipID, err
+}
+
+func clipProto(c *models.Clip, payload []byte) *clipsyncpb.Clip {
+	clip := &clipsyncpb.Clip{
+		Id:          c.ID.String(),
+		DeviceId:    c.DeviceID,
+		ContentType: c.ContentType,
+		Encrypted:   c.Encrypted,
+		Payload:     payload,
+		CreatedAt:   timestamppb.New(c.CreatedAt),
+		Size:        int64(c.Size),
+	}
+	if c.SpaceID != nil {
+		clip.SpaceId = c.SpaceID.String()
+	}
+	return clip
+}
+
+func (s *service) ListClips(ctx context.Context, req *clipsyncpb.ListClipsRequest) (*clipsyncpb.ListClipsResponse, error) {
+	user := auth.UserFromContext(ctx)
+
+	pageSize := int(req.GetPageSize())
+	if pageSize <= 0 {
+		pageSize = defaultClipPageSize
+	}
+	if pageSize > maxClipPageSize {
+		pageSize = maxClipPageSize
+	}
+
+	query := db.DB.Where("user_id = ? AND space_id IS NULL AND sender_id IS NULL", user.ID)
+	if req.GetSpaceId() != "" {
+		member, err := spaces.Membership(req.GetSpaceId(), user.ID.String())
+		if errors.Is(err, spaces.ErrNotMember) {
+			return nil, status.Error(codes.PermissionDenied, "Not a member of this space")
+		}
+		if err != nil {
+			return nil, status.Error(codes.Internal, "Error loading clips")
+		}
+		query = db.DB.Where("space_id = ?", member.SpaceID)
+	}
+	if req.GetDeviceId() != "" {
+		query = query.Where("device_id = ?", req.GetDeviceId())
+	}
+	if req.GetPageToken() != "" {
+		createdAt, id, err := decodePageToken(req.GetPageToken())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "Invalid page token")
+		}
+		query = query.Where("(created_at, id) < (?, ?)", createdAt, id)
+	}
+
+	var page []models.Clip
+	if err := query.Order("created_at DESC, id DESC").Limit(pageSize + 1).Find(&page).Error; err != nil {
+		return nil, status.Error(codes.Internal, "Error loading clips")
+	}
+
+	resp := &clipsyncpb.ListClipsResponse{}
+	if len(page) > pageSize {
+		page = page[:pageSize]
+		resp.NextPageToken = encodePageToken(&page[pageSize-1])
+	}
+	for i := range page {
+		// Blob payloads can be large; clients fetch them with GetClip.
+		resp.Clips = append(resp.Clips, clipProto(&page[i], page[i].Content))
+	}
+	return resp, nil
+}
+
+func (s *service) GetClip(ctx context.Context, req *clipsyncpb.GetClipRequest) (*clipsyncpb.Clip, error) {
+	user := auth.UserFromContext(ctx)
+
+	id, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid clip id")
+	}
+
+	var clip models.Clip
+	// Clips shared in a space are visible to all of its members.
+	err = db.DB.Where("id = ? AND ((user_id = ? AND space_id IS NULL) OR space_id IN (?))", id, user.ID,
+		db.DB.Model(&models.SpaceMember{}).Select("space_id").Where("user_id = ?", user.ID)).First(&clip).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, status.Error(codes.NotFound, "Clip not found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Error loading clip")
+	}
+
+	payload, err := clips.Content(&clip)
+	if err != nil {
+		log.Printf("Failed to read clip %s for user %s: %v", clip.ID, user.ID, err)
+		return nil, status.Error(codes.Internal, "Error loading clip")
+	}
+	return clipProto(&clip, payload), nil
+}
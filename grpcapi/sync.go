@@ -0,0 +1,136 @@
+package grpcapi
+
+import (
+	"io"
+	"log"
+	"time"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
+	"clipsync.com/m/grpcapi/clipsyncpb"
+	"clipsync.com/m/spaces"
+	"clipsync.com/m/ws"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TransportGRPC identifies Sync streams among ws.Server clients.
+const TransportGRPC = "grpc"
+
+// messageProto converts a hub message into the response sent on the stream.
+func messageProto(msg ws.Message) *clipsyncpb.SyncResponse {
+	if msg.Type == ws.MessageControl {
+		return &clipsyncpb.SyncResponse{
+			Message: &clipsyncpb.SyncResponse_Control{Control: msg.Payload},
+		}
+	}
+
+	clip := &clipsyncpb.Clip{
+		Id:          msg.ID,
+		DeviceId:    msg.FromDevice,
+		ContentType: msg.ContentType,
+		Encrypted:   msg.Encrypted,
+		Payload:     msg.Payload,
+		Size:        int64(len(msg.Payload)),
+		SpaceId:     msg.SpaceID,
+	}
+	if !msg.CreatedAt.IsZero() {
+		clip.CreatedAt = timestamppb.New(msg.CreatedAt)
+	}
+	return &clipsyncpb.SyncResponse{
+		Message: &clipsyncpb.SyncResponse_Clip{Clip: clip},
+	}
+}
+
+// Sync registers the stream with the hub as a device connection. Requests are
+// handled like WebSocket messages; clips and control messages for the device
+// are written to the stream until either side closes it or the session is
+// revoked.
+func (s *service) Sync(stream clipsyncpb.ClipSync_SyncServer) error {
+	ctx := stream.Context()
+	grant := auth.GrantFromContext(ctx)
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	deviceIDs := md.Get("device-id")
+	if len(deviceIDs) == 0 || deviceIDs[0] == "" {
+		return status.Error(codes.InvalidArgument, "Missing device-id")
+	}
+
+	var spaceID string
+	if spaceIDs := md.Get("space-id"); len(spaceIDs) > 0 && spaceIDs[0] != "" {
+		spaceID = spaceIDs[0]
+		if _, err := spaces.Membership(spaceID, grant.User.ID.String()); err != nil {
+			return status.Error(codes.PermissionDenied, "Not a member of this space")
+		}
+	}
+
+	client := ws.NewClient(s.hub, grant, deviceIDs[0], spaceID, TransportGRPC)
+	if !client.CanSend && !client.CanReceive {
+		return status.Error(codes.PermissionDenied, "Token has neither the clips:read nor the clips:write scope")
+	}
+
+	s.hub.Register(client)
+	defer s.hub.Unregister(client)
+
+	recvErr := make(chan error, 1)
+	go func() {
+		recvErr <- s.receive(stream, client)
+	}()
+
+	ticker := time.NewTicker(ws.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-client.SendChan:
+			if !ok {
+				// Closed by the server, e.g. the session was revoked.
+				return status.Error(codes.Unauthenticated, "Connection closed by the server")
+			}
+			if err := stream.Send(messageProto(msg)); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			client.Heartbeat()
+		case err := <-recvErr:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// receive reads requests until the client closes its side of the stream,
+// which ends the Sync call like closing a WebSocket does.
+func (s *service) receive(stream clipsyncpb.ClipSync_SyncServer, client *ws.Client) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch m := req.GetMessage().(type) {
+		case *clipsyncpb.SyncRequest_Clip:
+			if !client.CanSend {
+				return status.Error(codes.PermissionDenied, "Token is missing the clips:write scope")
+			}
+			payload := m.Clip.GetPayload()
+			if len(payload) == 0 {
+				continue
+			}
+			if len(payload) > config.MaxClipSize {
+				return status.Error(codes.InvalidArgument, "Clip is too large")
+			}
+			client.SendClip(m.Clip.GetContentType(), payload)
+		case *clipsyncpb.SyncRequest_Control:
+			if !client.HandleControl(m.Control) {
+				log.Printf("Ignoring unknown control message from user %s (%s)", client.UserID, client.DeviceID)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/orgs"
+	"clipsync.com/m/retention"
+	"clipsync.com/m/webhooks"
+)
+
+// UpdateProfileRequest uses pointers so absent fields are left unchanged.
+type UpdateProfileRequest struct {
+	Name              *string `json:"name"`
+	EncryptionEnabled *bool   `json:"encryption_enabled"`
+	RetentionDays     *int    `json:"retention_days"`  // 0 = keep history forever
+	KeepLastClips     *int    `json:"keep_last_clips"` // 0 = no limit
+	RelayOnly         *bool   `json:"relay_only"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail     string `json:"new_email"`
+	Password     string `json:"password"`
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func profileResponse(user *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                 user.ID,
+		"name":               user.Name,
+		"email":              user.Email,
+		"email_verified":     user.EmailVerified,
+		"pending_email":      user.PendingEmail,
+		"encryption_enabled": user.EncryptionEnabled,
+		"two_factor_enabled": user.TOTPEnabled,
+		"org_id":             user.OrgID,
+		"org_role":           user.OrgRole,
+		"retention_days":     user.RetentionDays,
+		"keep_last_clips":    user.KeepLastClips,
+		"relay_only":         user.RelayOnly,
+		"delete_after":       user.DeleteAfter,
+		"created_at":         user.CreatedAt,
+	}
+}
+
+// MeHandler returns (GET) or updates (PATCH) the authenticated user's
+// profile, including their retention settings. effective_retention combines
+// them with their organization's, the stricter of each applying.
+func MeHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var req UpdateProfileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		updates := map[string]interface{}{}
+		if req.Name != nil {
+			name := strings.TrimSpace(*req.Name)
+			if name == "" {
+				http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+				return
+			}
+			updates["name"] = name
+		}
+		if req.EncryptionEnabled != nil {
+			if !*req.EncryptionEnabled {
+				org, err := orgs.ForUser(user.ID.String())
+				if err != nil {
+					http.Error(w, "Error updating profile", http.StatusInternalServerError)
+					return
+				}
+				if org != nil && org.RequireEncryption {
+					http.Error(w, orgs.ErrEncryptionRequired.Error(), http.StatusForbidden)
+					return
+				}
+			}
+			updates["encryption_enabled"] = *req.EncryptionEnabled
+		}
+		if req.RetentionDays != nil {
+			if *req.RetentionDays < 0 {
+				http.Error(w, "retention_days cannot be negative", http.StatusBadRequest)
+				return
+			}
+			updates["retention_days"] = *req.RetentionDays
+		}
+		if req.KeepLastClips != nil {
+			if *req.KeepLastClips < 0 {
+				http.Error(w, "keep_last_clips cannot be negative", http.StatusBadRequest)
+				return
+			}
+			updates["keep_last_clips"] = *req.KeepLastClips
+		}
+		if req.RelayOnly != nil {
+			updates["relay_only"] = *req.RelayOnly
+		}
+		if len(updates) > 0 {
+			if err := db.DB.Model(user).Updates(updates).Error; err != nil {
+				http.Error(w, "Error updating profile", http.StatusInternalServerError)
+				return
+			}
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := profileResponse(user)
+	org, err := orgs.ForUser(user.ID.String())
+	if err != nil {
+		http.Error(w, "Error loading profile", http.StatusInternalServerError)
+		return
+	}
+	resp["effective_retention"] = retention.For(user, org)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ChangeEmailHandler starts an email change. The new address is held as
+// pending and only replaces the current one once it has been verified.
+func ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	var req ChangeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	newEmail := normalizeEmail(req.NewEmail)
+	if !strings.Contains(newEmail, "@") {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+	if strings.EqualFold(newEmail, user.Email) {
+		http.Error(w, "That is already your email address", http.StatusBadRequest)
+		return
+	}
+
+	if !confirmPassword(w, r, user, req.Password) {
+		return
+	}
+	if err := verifySecondFactor(user, req.TOTPCode, req.RecoveryCode, time.Now()); err != nil {
+		writeTwoFactorRequired(w)
+		return
+	}
+
+	var count int64
+	if err := db.DB.Model(&models.User{}).Where("LOWER(email) = ?", newEmail).Count(&count).Error; err != nil {
+		http.Error(w, "Error changing email", http.StatusInternalServerError)
+		return
+	}
+	if count > 0 {
+		http.Error(w, "Email address is already in use", http.StatusConflict)
+		return
+	}
+
+	if err := db.DB.Model(user).Update("pending_email", newEmail).Error; err != nil {
+		http.Error(w, "Error changing email", http.StatusInternalServerError)
+		return
+	}
+	if err := sendVerificationEmail(r, user, newEmail); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", newEmail, err)
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+	sendSecurityAlert(user, webhooks.EventEmailChangeRequested, "A change of your account email to "+newEmail+" was requested")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Check your new email address to confirm the change",
+	})
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/clips"
+	"clipsync.com/m/config"
+	"clipsync.com/m/contacts"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/spaces"
+	"clipsync.com/m/webhooks"
+	"clipsync.com/m/ws"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type DeleteAccountRequest struct {
+	Password     string `json:"password"`
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// DeleteAccountHandler schedules (POST) or cancels (DELETE) deletion of the
+// authenticated user's account. The account keeps working during the grace
+// period so the request can be cancelled.
+func DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodPost:
+		var req DeleteAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !confirmPassword(w, r, user, req.Password) {
+			return
+		}
+		if err := verifySecondFactor(user, req.TOTPCode, req.RecoveryCode, time.Now()); err != nil {
+			writeTwoFactorRequired(w)
+			return
+		}
+
+		deleteAfter := time.Now().Add(config.AccountDeletionGracePeriod)
+		if err := db.DB.Model(user).Update("delete_after", deleteAfter).Error; err != nil {
+			http.Error(w, "Error scheduling deletion", http.StatusInternalServerError)
+			return
+		}
+		sendSecurityAlert(user, webhooks.EventAccountDeletionScheduled, fmt.Sprintf("Your account is scheduled for deletion on %s", deleteAfter.Format(time.RFC1123)))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":      "Account scheduled for deletion",
+			"delete_after": deleteAfter,
+		})
+
+	case http.MethodDelete:
+		if user.DeleteAfter == nil {
+			http.Error(w, "No deletion is scheduled", http.StatusConflict)
+			return
+		}
+		if err := db.DB.Model(user).Update("delete_after", nil).Error; err != nil {
+			http.Error(w, "Error cancelling deletion", http.StatusInternalServerError)
+			return
+		}
+		sendSecurityAlert(user, webhooks.EventAccountDeletionCancelled, "The scheduled deletion of your account was cancelled")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Account deletion cancelled"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// StartAccountPurger periodically purges accounts whose grace period is over.
+func StartAccountPurger() {
+	go func() {
+		ticker := time.NewTicker(config.AccountPurgeInterval)
+		defer ticker.Stop()
+		for {
+			purgeDueAccounts()
+			<-ticker.C
+		}
+	}()
+}
+
+func purgeDueAccounts() {
+	ctx := context.Background()
+	// Only one instance purges per interval.
+	ok, err := db.RedisClient.SetNX(ctx, "account_purge_lock", 1, config.AccountPurgeInterval/2).Result()
+	if err != nil || !ok {
+		return
+	}
+
+	var users []models.User
+	if err := db.DB.Where("delete_after IS NOT NULL AND delete_after <= ?", time.Now()).Find(&users).Error; err != nil {
+		log.Println("Failed to list accounts due for deletion:", err)
+		return
+	}
+	for i := range users {
+		if err := purgeAccount(ctx, &users[i]); err != nil {
+			log.Printf("Failed to purge account %s: %v", users[i].ID, err)
+			continue
+		}
+		log.Printf("Purged account %s", users[i].ID)
+	}
+}
+
+// purgeAccount removes every row, blob and Redis key belonging to user and
+// disconnects its live sockets.
+func purgeAccount(ctx context.Context, user *models.User) error {
+	var sessionVersion int
+	var deletedSpaces []uuid.UUID
+	var spaceBlobs []models.Clip
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if sessionVersion, err = auth.RevokeSessions(tx, user); err != nil {
+			return err
+		}
+		// Spaces the user was the last member of go too.
+		if deletedSpaces, spaceBlobs, err = spaces.LeaveAll(tx, user.ID); err != nil {
+			return err
+		}
+		if err := contacts.DeleteAll(tx, user.ID); err != nil {
+			return err
+		}
+		// Clip rows go with the account; blobs are removed once it commits.
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Clip{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("webhook_id IN (?)", tx.Model(&models.Webhook{}).Select("id").Where("user_id = ?", user.ID)).
+			Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("share_link_id IN (?)", tx.Model(&models.ShareLink{}).Select("id").Where("user_id = ?", user.ID)).
+			Delete(&models.ShareLinkAccess{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&models.ShareLink{}, &models.Webhook{}, &models.Device{}, &models.RecoveryCode{}, &models.WebAuthnCredential{}, &models.Identity{}, &models.APIKey{}} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(user).Error
+	})
+	if err != nil {
+		return err
+	}
+	if err := clips.DeleteUserBlobs(user.ID); err != nil {
+		log.Printf("Failed to delete clip blobs for %s: %v", user.ID, err)
+	}
+	deleteClipBlobs(spaceBlobs)
+	for _, spaceID := range deletedSpaces {
+		ws.DeleteSpace(spaceID.String())
+	}
+
+	ws.RevokeSessions(user.ID.String(), sessionVersion)
+	ws.ClearPresence(user.ID.String())
+	sendSecurityAlert(user, webhooks.EventAccountDeleted, "Your ClipSync account and all of its data were deleted")
+
+	email := normalizeEmail(user.Email)
+	db.RedisClient.Del(ctx,
+		fmt.Sprintf("email_verify_code:%s", user.ID),
+		fmt.Sprintf("email_verify_attempts:%s", user.ID),
+		fmt.Sprintf("email_verify_resend:%s", user.ID),
+		fmt.Sprintf("email_verify_resend_count:%s", user.ID),
+		fmt.Sprintf("reset_otp:%s", email),
+		fmt.Sprintf("reset_requested:%s", email),
+		fmt.Sprintf("reset_attempts:email:%s", email))
+	for _, pattern := range []string{
+		fmt.Sprintf("totp_used:%s:*", user.ID),
+		fmt.Sprintf("ratelimit:*:user:%s", user.ID),
+		fmt.Sprintf("ratelimit_lock:*:user:%s", user.ID),
+		fmt.Sprintf("ratelimit:*:email:%s", email),
+		fmt.Sprintf("ratelimit_lock:*:email:%s", email),
+	} {
+		if err := deleteRedisKeys(ctx, pattern); err != nil {
+			log.Printf("Failed to delete Redis keys %s: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// deleteRedisKeys deletes every key matching pattern, scanning in batches.
+func deleteRedisKeys(ctx context.Context, pattern string) error {
+	iter := db.RedisClient.Scan(ctx, 0, pattern, 100).Iterator()
+	var batch []string
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == 100 {
+			if err := db.RedisClient.Del(ctx, batch...).Err(); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return db.RedisClient.Del(ctx, batch...).Err()
+	}
+	return nil
+}
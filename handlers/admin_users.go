@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/auth"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/webhooks"
+	"clipsync.com/m/ws"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAdminListLimit = 50
+	maxAdminListLimit     = 200
+)
+
+type AdminRoleRequest struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"` // "" removes the role
+}
+
+type AdminDisconnectRequest struct {
+	UserID         string `json:"user_id"`
+	DeviceID       string `json:"device_id"`       // optional: only this device
+	RevokeSessions bool   `json:"revoke_sessions"` // also sign out every session
+}
+
+// AdminUserActionRequest names the account to lock or whose 2FA to reset.
+type AdminUserActionRequest struct {
+	UserID string `json:"user_id"`
+	Reason string `json:"reason"` // optional, kept in the audit trail
+}
+
+func adminUserResponse(user *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                 user.ID,
+		"name":               user.Name,
+		"email":              user.Email,
+		"email_verified":     user.EmailVerified,
+		"role":               auth.RoleOf(user),
+		"org_id":             user.OrgID,
+		"org_role":           user.OrgRole,
+		"encryption_enabled": user.EncryptionEnabled,
+		"two_factor_enabled": user.TOTPEnabled,
+		"locked_at":          user.LockedAt,
+		"lock_reason":        user.LockReason,
+		"delete_after":       user.DeleteAfter,
+		"created_at":         user.CreatedAt,
+	}
+}
+
+// listLimit parses the optional ?limit= and ?offset= query parameters.
+func listLimit(r *http.Request) (int, int, bool) {
+	limit, offset := defaultAdminListLimit, 0
+	q := r.URL.Query()
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		limit = min(n, maxAdminListLimit)
+	}
+	if s := q.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+// findManagedUser loads the account id for staff member actor to act on,
+// writing an error if there is none or actor may not manage it. Staff never
+// act on their own account here, and only those who manage roles can act on
+// other staff.
+func findManagedUser(w http.ResponseWriter, actor *models.User, id string) (*models.User, bool) {
+	var user models.User
+	if _, err := uuid.Parse(id); err != nil || db.DB.Where("id = ?", id).First(&user).Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	if user.ID == actor.ID {
+		http.Error(w, "You cannot do this to your own account", http.StatusForbidden)
+		return nil, false
+	}
+	if auth.RoleOf(&user) != "" && !auth.Can(actor, auth.PermRolesManage) {
+		http.Error(w, "Only admins can manage staff accounts", http.StatusForbidden)
+		return nil, false
+	}
+	return &user, true
+}
+
+// AdminUsersHandler searches accounts (GET with optional ?q=, ?role=,
+// ?locked=true, ?limit= and ?offset=), returns one (GET ?id=), and changes
+// an account's role (PATCH, roles:manage only). q matches an id exactly or
+// part of an email or name.
+func AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	actor := auth.UserFromContext(r.Context())
+	q := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && q.Get("id") != "":
+		var user models.User
+		if _, err := uuid.Parse(q.Get("id")); err != nil || db.DB.Where("id = ?", q.Get("id")).First(&user).Error != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		audit.Record(r, actor, &user.ID, audit.ActionUserViewed, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(adminUserResponse(&user))
+
+	case r.Method == http.MethodGet:
+		limit, offset, ok := listLimit(r)
+		if !ok {
+			http.Error(w, "Invalid limit or offset", http.StatusBadRequest)
+			return
+		}
+		query := db.DB.Model(&models.User{})
+		if term := strings.TrimSpace(q.Get("q")); term != "" {
+			if _, err := uuid.Parse(term); err == nil {
+				query = query.Where("id = ?", term)
+			} else {
+				like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(term)) + "%"
+				query = query.Where("LOWER(email) LIKE ? OR LOWER(name) LIKE ?", like, like)
+			}
+		}
+		if role := q.Get("role"); role != "" {
+			query = query.Where("role = ?", role)
+		}
+		if q.Get("locked") == "true" {
+			query = query.Where("locked_at IS NOT NULL")
+		}
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			http.Error(w, "Error searching users", http.StatusInternalServerError)
+			return
+		}
+		var users []models.User
+		if err := query.Order("created_at").Limit(limit).Offset(offset).Find(&users).Error; err != nil {
+			http.Error(w, "Error searching users", http.StatusInternalServerError)
+			return
+		}
+		audit.Record(r, actor, nil, audit.ActionUsersSearched, map[string]interface{}{
+			"q":      q.Get("q"),
+			"role":   q.Get("role"),
+			"locked": q.Get("locked"),
+		})
+
+		list := make([]map[string]interface{}, 0, len(users))
+		for i := range users {
+			list = append(list, adminUserResponse(&users[i]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"users": list,
+			"total": total,
+		})
+
+	case r.Method == http.MethodPatch:
+		if !auth.Can(actor, auth.PermRolesManage) {
+			http.Error(w, "Missing the "+auth.PermRolesManage+" permission", http.StatusForbidden)
+			return
+		}
+		var req AdminRoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !auth.ValidRole(req.Role) {
+			http.Error(w, `Role must be "admin", "support" or empty`, http.StatusBadRequest)
+			return
+		}
+		user, ok := findManagedUser(w, actor, req.UserID)
+		if !ok {
+			return
+		}
+		previous := user.Role
+		if err := db.DB.Model(user).Update("role", req.Role).Error; err != nil {
+			http.Error(w, "Error changing role", http.StatusInternalServerError)
+			return
+		}
+		user.Role = req.Role
+		audit.Record(r, actor, &user.ID, audit.ActionRoleChanged, map[string]interface{}{
+			"from": previous,
+			"to":   req.Role,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(adminUserResponse(user))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AdminUserDevicesHandler returns the devices of the account ?id=, which of
+// them are online anywhere in the cluster, and the account's live
+// connections to this server instance.
+func AdminUserDevicesHandler(server *ws.Server, w http.ResponseWriter, r *http.Request) {
+	actor := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var user models.User
+	id := r.URL.Query().Get("id")
+	if _, err := uuid.Parse(id); err != nil || db.DB.Where("id = ?", id).First(&user).Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var devices []models.Device
+	if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&devices).Error; err != nil {
+		http.Error(w, "Error loading devices", http.StatusInternalServerError)
+		return
+	}
+	online, err := ws.OnlineDevices(user.ID.String())
+	if err != nil {
+		log.Printf("Failed to load online devices of user %s: %v", user.ID, err)
+	}
+	if online == nil {
+		online = []string{}
+	}
+	audit.Record(r, actor, &user.ID, audit.ActionUserViewed, map[string]interface{}{"view": "devices"})
+
+	list := make([]map[string]interface{}, 0, len(devices))
+	for i := range devices {
+		list = append(list, deviceResponse(&devices[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"devices":        list,
+		"online_devices": online,
+		"connections":    server.Connections(user.ID.String()),
+	})
+}
+
+// AdminDisconnectHandler closes an account's live connections on every
+// server instance, or only one device's. With revoke_sessions every session
+// token is revoked as well, so the devices have to sign in again.
+func AdminDisconnectHandler(w http.ResponseWriter, r *http.Request) {
+	actor := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req AdminDisconnectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	user, ok := findManagedUser(w, actor, req.UserID)
+	if !ok {
+		return
+	}
+
+	if req.RevokeSessions {
+		sessionVersion, err := auth.RevokeSessions(db.DB, user)
+		if err != nil {
+			http.Error(w, "Error revoking sessions", http.StatusInternalServerError)
+			return
+		}
+		ws.RevokeSessions(user.ID.String(), sessionVersion)
+	}
+	ws.Disconnect(user.ID.String(), req.DeviceID)
+	audit.Record(r, actor, &user.ID, audit.ActionUserDisconnect, map[string]interface{}{
+		"device_id":       req.DeviceID,
+		"revoke_sessions": req.RevokeSessions,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Disconnected"})
+}
+
+// AdminLockHandler locks an account (POST) or unlocks it (DELETE
+// ?user_id=). Locking signs out every session and closes every connection;
+// a locked account cannot sign in or use its API keys until it is unlocked.
+func AdminLockHandler(w http.ResponseWriter, r *http.Request) {
+	actor := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodPost:
+		var req AdminUserActionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		user, ok := findManagedUser(w, actor, req.UserID)
+		if !ok {
+			return
+		}
+		if user.LockedAt != nil {
+			http.Error(w, "Account is already locked", http.StatusConflict)
+			return
+		}
+
+		now := time.Now()
+		reason := strings.TrimSpace(req.Reason)
+		var sessionVersion int
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(user).Updates(map[string]interface{}{
+				"locked_at":   now,
+				"lock_reason": reason,
+			}).Error; err != nil {
+				return err
+			}
+			var err error
+			sessionVersion, err = auth.RevokeSessions(tx, user)
+			return err
+		})
+		if err != nil {
+			http.Error(w, "Error locking account", http.StatusInternalServerError)
+			return
+		}
+		user.LockedAt, user.LockReason = &now, reason
+		ws.RevokeSessions(user.ID.String(), sessionVersion)
+		audit.Record(r, actor, &user.ID, audit.ActionUserLocked, map[string]interface{}{"reason": reason})
+		sendSecurityAlert(user, webhooks.EventAccountLocked, "Your account was locked by an administrator")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(adminUserResponse(user))
+
+	case http.MethodDelete:
+		user, ok := findManagedUser(w, actor, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+		if user.LockedAt == nil {
+			http.Error(w, "Account is not locked", http.StatusConflict)
+			return
+		}
+		if err := db.DB.Model(user).Updates(map[string]interface{}{
+			"locked_at":   nil,
+			"lock_reason": "",
+		}).Error; err != nil {
+			http.Error(w, "Error unlocking account", http.StatusInternalServerError)
+			return
+		}
+		user.LockedAt, user.LockReason = nil, ""
+		audit.Record(r, actor, &user.ID, audit.ActionUserUnlocked, nil)
+		sendSecurityAlert(user, webhooks.EventAccountUnlocked, "Your account was unlocked by an administrator")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(adminUserResponse(user))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AdminTwoFactorResetHandler turns off two-factor authentication for an
+// account that lost its authenticator and recovery codes. The user can sign
+// in with their password alone and set it up again.
+func AdminTwoFactorResetHandler(w http.ResponseWriter, r *http.Request) {
+	actor := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req AdminUserActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	user, ok := findManagedUser(w, actor, req.UserID)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(user).Updates(map[string]interface{}{
+			"totp_enabled": false,
+			"totp_secret":  "",
+		}).Error
+	})
+	if err != nil {
+		http.Error(w, "Error resetting two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	audit.Record(r, actor, &user.ID, audit.ActionTwoFactorReset, map[string]interface{}{"reason": strings.TrimSpace(req.Reason)})
+	sendSecurityAlert(user, webhooks.EventTwoFactorDisabled, "Two-factor authentication was reset by an administrator")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication reset"})
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/auth"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/utils"
+	"clipsync.com/m/webhooks"
+	"clipsync.com/m/ws"
+)
+
+const maxAPIKeysPerUser = 50
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 = never
+}
+
+func apiKeyResponse(key *models.APIKey) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           key.ID,
+		"name":         key.Name,
+		"prefix":       key.Prefix,
+		"scopes":       strings.Fields(key.Scopes),
+		"expires_at":   key.ExpiresAt,
+		"last_used_at": key.LastUsedAt,
+		"created_at":   key.CreatedAt,
+	}
+}
+
+// APIKeysHandler lists (GET), creates (POST) and revokes (DELETE ?id=) the
+// authenticated user's API keys. The key itself is only returned on creation.
+func APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		var keys []models.APIKey
+		if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&keys).Error; err != nil {
+			http.Error(w, "Error loading API keys", http.StatusInternalServerError)
+			return
+		}
+		list := make([]map[string]interface{}, 0, len(keys))
+		for i := range keys {
+			list = append(list, apiKeyResponse(&keys[i]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		var req CreateAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		if len(req.Scopes) == 0 {
+			http.Error(w, "At least one scope is required", http.StatusBadRequest)
+			return
+		}
+		for _, scope := range req.Scopes {
+			if !auth.ValidScope(scope) {
+				http.Error(w, fmt.Sprintf("Unknown scope %q", scope), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.ExpiresInDays < 0 {
+			http.Error(w, "expires_in_days cannot be negative", http.StatusBadRequest)
+			return
+		}
+
+		var count int64
+		if err := db.DB.Model(&models.APIKey{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+			http.Error(w, "Error creating API key", http.StatusInternalServerError)
+			return
+		}
+		if count >= maxAPIKeysPerUser {
+			http.Error(w, "Too many API keys, revoke some first", http.StatusConflict)
+			return
+		}
+
+		secret, err := auth.GenerateAPIKey()
+		if err != nil {
+			http.Error(w, "Error creating API key", http.StatusInternalServerError)
+			return
+		}
+		key := models.APIKey{
+			UserID:  user.ID,
+			Name:    req.Name,
+			Prefix:  secret[:len(auth.APIKeyPrefix)+8],
+			KeyHash: utils.HashSecret(secret),
+			Scopes:  strings.Join(req.Scopes, " "),
+		}
+		if req.ExpiresInDays > 0 {
+			expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+			key.ExpiresAt = &expiresAt
+		}
+		if err := db.DB.Create(&key).Error; err != nil {
+			http.Error(w, "Error creating API key", http.StatusInternalServerError)
+			return
+		}
+		audit.Record(r, user, &user.ID, audit.ActionAPIKeyCreated, map[string]interface{}{
+			"id":         key.ID,
+			"name":       key.Name,
+			"scopes":     req.Scopes,
+			"expires_at": key.ExpiresAt,
+		})
+		sendSecurityAlert(user, webhooks.EventAPIKeyCreated, fmt.Sprintf("An API key named %q was created", key.Name))
+
+		resp := apiKeyResponse(&key)
+		resp["key"] = secret
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodDelete:
+		var key models.APIKey
+		if err := db.DB.Where("id = ? AND user_id = ?", r.URL.Query().Get("id"), user.ID).First(&key).Error; err != nil {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		if err := db.DB.Delete(&key).Error; err != nil {
+			http.Error(w, "Error revoking API key", http.StatusInternalServerError)
+			return
+		}
+		ws.RevokeAPIKey(user.ID.String(), key.ID.String())
+		audit.Record(r, user, &user.ID, audit.ActionAPIKeyRevoked, map[string]interface{}{"id": key.ID, "name": key.Name})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "API key revoked"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// closeAPIKeyConnections closes, on every server instance, the connections
+// opened with keys.
+func closeAPIKeyConnections(user *models.User, keys []models.APIKey) {
+	for _, key := range keys {
+		ws.RevokeAPIKey(user.ID.String(), key.ID.String())
+	}
+}
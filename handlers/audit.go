@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/auth"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const auditExportBatchSize = 500
+
+func auditEventResponse(e *models.AuditEvent) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         e.ID,
+		"seq":        e.Seq,
+		"actor_id":   e.ActorID,
+		"user_id":    e.UserID,
+		"action":     e.Action,
+		"ip":         e.IP,
+		"user_agent": e.UserAgent,
+		"details":    json.RawMessage(e.Details),
+		"prev_hash":  e.PrevHash,
+		"hash":       e.Hash,
+		"created_at": e.CreatedAt,
+	}
+}
+
+// activityResponse is an audit event as its account sees it. Where and by
+// whom staff acted is left out.
+func activityResponse(e *models.AuditEvent, user *models.User) map[string]interface{} {
+	resp := map[string]interface{}{
+		"id":         e.ID,
+		"action":     e.Action,
+		"details":    json.RawMessage(e.Details),
+		"by_staff":   false,
+		"created_at": e.CreatedAt,
+	}
+	if e.ActorID != nil && *e.ActorID != user.ID {
+		resp["by_staff"] = true
+	} else {
+		resp["ip"] = e.IP
+		resp["user_agent"] = e.UserAgent
+	}
+	return resp
+}
+
+// auditQuery scopes a query to the audit events selected by the ?action=
+// (an action or a "prefix.*" pattern), ?from= and ?to= (RFC 3339) query
+// parameters, and for staff also ?user_id= and ?actor_id=, writing a 400 if
+// they are invalid. The query can be run more than once.
+func auditQuery(w http.ResponseWriter, r *http.Request, query *gorm.DB, staff bool) (*gorm.DB, bool) {
+	q := r.URL.Query()
+	if staff {
+		for _, param := range []string{"user_id", "actor_id"} {
+			if id := q.Get(param); id != "" {
+				if _, err := uuid.Parse(id); err != nil {
+					http.Error(w, "Invalid "+param, http.StatusBadRequest)
+					return nil, false
+				}
+				query = query.Where(param+" = ?", id)
+			}
+		}
+	}
+	if action := q.Get("action"); action != "" {
+		if prefix, ok := strings.CutSuffix(action, ".*"); ok {
+			query = query.Where("action LIKE ?", prefix+".%")
+		} else {
+			query = query.Where("action = ?", action)
+		}
+	}
+	for _, param := range []string{"from", "to"} {
+		s := q.Get(param)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "Invalid "+param+", expected an RFC 3339 time", http.StatusBadRequest)
+			return nil, false
+		}
+		if param == "from" {
+			query = query.Where("created_at >= ?", t)
+		} else {
+			query = query.Where("created_at < ?", t)
+		}
+	}
+	return query.Session(&gorm.Session{}), true
+}
+
+// writeAuditExport streams the events matched by query as JSON lines,
+// oldest first, in batches.
+func writeAuditExport(w http.ResponseWriter, query *gorm.DB, filename string, encode func(*models.AuditEvent) map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	enc := json.NewEncoder(w)
+	var lastSeq int64
+	for {
+		var batch []models.AuditEvent
+		if err := query.Where("seq > ?", lastSeq).Order("seq").Limit(auditExportBatchSize).Find(&batch).Error; err != nil {
+			// Headers are sent; all we can do is cut the stream short.
+			log.Printf("Audit export failed after seq %d: %v", lastSeq, err)
+			return
+		}
+		for i := range batch {
+			if err := enc.Encode(encode(&batch[i])); err != nil {
+				return
+			}
+			lastSeq = batch[i].Seq
+		}
+		if len(batch) < auditExportBatchSize {
+			return
+		}
+	}
+}
+
+// ActivityHandler returns the recent security activity on the user's
+// account, newest first: sign-ins and failed attempts, password, token,
+// device and 2FA changes, and actions taken by staff. It takes the
+// ?action=, ?from=, ?to=, ?limit= and ?offset= filters.
+func ActivityHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit, offset, ok := listLimit(r)
+	if !ok {
+		http.Error(w, "Invalid limit or offset", http.StatusBadRequest)
+		return
+	}
+	query, ok := auditQuery(w, r, ownActivity(user), false)
+	if !ok {
+		return
+	}
+
+	var events []models.AuditEvent
+	if err := query.Order("seq DESC").Limit(limit).Offset(offset).Find(&events).Error; err != nil {
+		http.Error(w, "Error loading activity", http.StatusInternalServerError)
+		return
+	}
+	list := make([]map[string]interface{}, 0, len(events))
+	for i := range events {
+		list = append(list, activityResponse(&events[i], user))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// ActivityExportHandler downloads the user's whole activity as JSON lines,
+// oldest first. It takes the same filters as ActivityHandler.
+func ActivityExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query, ok := auditQuery(w, r, ownActivity(user), false)
+	if !ok {
+		return
+	}
+	writeAuditExport(w, query, "activity.jsonl", func(e *models.AuditEvent) map[string]interface{} {
+		return activityResponse(e, user)
+	})
+}
+
+// ownActivity scopes a query to the events on the user's account. Staff
+// looking the account up are not listed.
+func ownActivity(user *models.User) *gorm.DB {
+	return db.DB.Model(&models.AuditEvent{}).Where("user_id = ? AND action <> ?", user.ID, audit.ActionUserViewed)
+}
+
+// AdminAuditHandler returns the audit trail, newest first. It can be
+// filtered by ?user_id= (the account acted on), ?actor_id=, ?action=,
+// ?from= and ?to=, and takes ?limit= and ?offset=.
+func AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit, offset, ok := listLimit(r)
+	if !ok {
+		http.Error(w, "Invalid limit or offset", http.StatusBadRequest)
+		return
+	}
+	query, ok := auditQuery(w, r, db.DB.Model(&models.AuditEvent{}), true)
+	if !ok {
+		return
+	}
+
+	var events []models.AuditEvent
+	if err := query.Order("seq DESC").Limit(limit).Offset(offset).Find(&events).Error; err != nil {
+		http.Error(w, "Error loading audit trail", http.StatusInternalServerError)
+		return
+	}
+	list := make([]map[string]interface{}, 0, len(events))
+	for i := range events {
+		list = append(list, auditEventResponse(&events[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// AdminAuditExportHandler downloads the audit trail as JSON lines, oldest
+// first, with the chain hashes so it can be verified offline. It takes the
+// same filters as AdminAuditHandler.
+func AdminAuditExportHandler(w http.ResponseWriter, r *http.Request) {
+	actor := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query, ok := auditQuery(w, r, db.DB.Model(&models.AuditEvent{}), true)
+	if !ok {
+		return
+	}
+	audit.Record(r, actor, nil, audit.ActionAuditExported, map[string]interface{}{"query": r.URL.RawQuery})
+	writeAuditExport(w, query, "audit.jsonl", auditEventResponse)
+}
+
+// AdminAuditVerifyHandler checks the whole hash chain and reports the first
+// event that was changed or follows a removed one.
+func AdminAuditVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	result, err := audit.Verify()
+	if err != nil {
+		http.Error(w, "Error verifying audit trail", http.StatusInternalServerError)
+		return
+	}
+	if !result.Valid {
+		log.Printf("Audit trail hash chain is broken at seq %d", result.BrokenSeq)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -1,18 +1,24 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
-	"fmt"
-	"math/rand"
+	"errors"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"clipsync.com/m/audit"
+	"clipsync.com/m/auth"
 	"clipsync.com/m/db"
+	"clipsync.com/m/mailer"
 	"clipsync.com/m/models"
 	"clipsync.com/m/utils"
-	"github.com/go-redis/redis/v8"
+	"clipsync.com/m/webhooks"
+	"clipsync.com/m/ws"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type RegisterRequest struct {
@@ -22,14 +28,19 @@ type RegisterRequest struct {
 }
 
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
+
+	Client audit.Client `json:"-"` // for the audit trail
 }
 
 type UpdatePasswordRequest struct {
-	Email       string `json:"email"`
-	OldPassword string `json:"old_password"`
-	NewPassword string `json:"new_password"`
+	OldPassword  string `json:"old_password"`
+	NewPassword  string `json:"new_password"`
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
 }
 
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
@@ -40,6 +51,21 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	email := normalizeEmail(req.Email)
+	if !strings.Contains(email, "@") {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+	var count int64
+	if err := db.DB.Model(&models.User{}).Where("LOWER(email) = ?", email).Count(&count).Error; err != nil {
+		http.Error(w, "Error saving user", http.StatusInternalServerError)
+		return
+	}
+	if count > 0 {
+		http.Error(w, "Email address is already in use", http.StatusConflict)
+		return
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		http.Error(w, "Error hashing password", http.StatusInternalServerError)
@@ -47,7 +73,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	user := models.User{
 		Name:         req.Name,
-		Email:        req.Email,
+		Email:        email,
 		PasswordHash: string(hashedPassword),
 	}
 
@@ -56,14 +82,19 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := utils.GenerateJWT(user.ID, user.Email)
+	if err := sendVerificationEmail(r, &user, user.Email); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+	}
+
+	token, err := utils.GenerateJWT(user.ID, user.Email, user.SessionVersion)
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"token": token,
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":          token,
+		"email_verified": user.EmailVerified,
 	})
 
 }
@@ -75,18 +106,20 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user models.User
-	if err := db.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
+	req.Client = audit.FromRequest(r)
+	token, err := PasswordLogin(req)
+	if errors.Is(err, ErrInvalidCredentials) {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
-
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	if errors.Is(err, ErrTwoFactorRequired) {
+		writeTwoFactorRequired(w)
+		return
+	}
+	if errors.Is(err, auth.ErrAccountLocked) {
+		http.Error(w, "Account is locked", http.StatusForbidden)
 		return
 	}
-
-	token, err := utils.GenerateJWT(user.ID, user.Email)
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
@@ -96,118 +129,167 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"token": token})
 }
 
-func UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
-	var req UpdatePasswordRequest
-
-	if err := json.NewDecoder((r.Body)).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// PasswordLogin checks an email and password, and the second factor if the
+// account has 2FA enabled, and returns a new session token. It is shared by
+// /login and the gRPC Login RPC.
+func PasswordLogin(req LoginRequest) (string, error) {
+	failed := func(userID *uuid.UUID, reason string, err error) (string, error) {
+		audit.Add(req.Client, nil, userID, audit.ActionLoginFailed, map[string]interface{}{
+			"method": "password",
+			"email":  req.Email,
+			"reason": reason,
+		})
+		return "", err
 	}
 
 	var user models.User
-	if err := db.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
-		http.Error(w, "Invalid email", http.StatusUnauthorized)
-		return
+	if err := db.DB.Where("LOWER(email) = ?", normalizeEmail(req.Email)).First(&user).Error; err != nil {
+		return failed(nil, "unknown_email", ErrInvalidCredentials)
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.OldPassword)); err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-		return
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		return failed(&user.ID, "wrong_password", ErrInvalidCredentials)
 	}
-	newHashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
-	if err != nil {
-		http.Error(w, "Error hashing password", http.StatusInternalServerError)
-		return
+
+	if user.LockedAt != nil {
+		return failed(&user.ID, "locked", auth.ErrAccountLocked)
 	}
-	user.PasswordHash = string(newHashedPassword)
-	if err := db.DB.Save(&user).Error; err != nil {
-		http.Error(w, "Error updating password", http.StatusInternalServerError)
-		return
+
+	if err := verifySecondFactor(&user, req.TOTPCode, req.RecoveryCode, time.Now()); err != nil {
+		if req.TOTPCode == "" && req.RecoveryCode == "" {
+			// Just asking for the code, not a failed attempt.
+			return "", ErrTwoFactorRequired
+		}
+		return failed(&user.ID, "wrong_second_factor", ErrTwoFactorRequired)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Password updated successfully"})
 
+	token, err := utils.GenerateJWT(user.ID, user.Email, user.SessionVersion)
+	if err != nil {
+		return "", err
+	}
+	audit.Add(req.Client, &user, &user.ID, audit.ActionLoginSucceeded, map[string]interface{}{"method": "password"})
+	return token, nil
 }
 
-type ForgotPasswordRequest struct {
-	Email string `json:"email"`
-}
+// UpdatePasswordHandler changes the authenticated user's password, signs out
+// every other session and returns a fresh token for the caller.
+func UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
 
-func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
-	var req ForgotPasswordRequest
+	var req UpdatePasswordRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	var user models.User
-	if err := db.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
-		http.Error(w, "Email not found", http.StatusNotFound)
+	if !confirmPassword(w, r, user, req.OldPassword) {
+		return
+	}
+	if err := verifySecondFactor(user, req.TOTPCode, req.RecoveryCode, time.Now()); err != nil {
+		writeTwoFactorRequired(w)
+		return
+	}
+	if req.NewPassword == "" {
+		http.Error(w, "New password is required", http.StatusBadRequest)
+		return
+	}
+	newHashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
 		return
 	}
 
-	otp := fmt.Sprintf("%06d", rand.Intn(100000)) // 6-digit OTP
-
-	// Store OTP in Redis for 5 minutes
-
-	ctx := context.Background()
-	redisKey := fmt.Sprintf("reset_otp:%s", req.Email)
-	if err := db.RedisClient.Set(ctx, redisKey, otp, 5*time.Minute).Err(); err != nil {
-		http.Error(w, "Failed to store OTP", http.StatusInternalServerError)
+	var sessionVersion int
+	var keys []models.APIKey
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password_hash", string(newHashedPassword)).Error; err != nil {
+			return err
+		}
+		if sessionVersion, err = auth.RevokeSessions(tx, user); err != nil {
+			return err
+		}
+		keys, err = auth.RevokeAPIKeys(tx, user)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Error updating password", http.StatusInternalServerError)
 		return
 	}
+	ws.RevokeSessions(user.ID.String(), sessionVersion)
+	closeAPIKeyConnections(user, keys)
+	audit.Record(r, user, &user.ID, audit.ActionPasswordChanged, map[string]interface{}{"api_keys_revoked": len(keys)})
+	sendSecurityAlert(user, webhooks.EventPasswordChanged, "Your password was changed, other devices were signed out and API keys were revoked")
 
-	// TODO: Replace with actual email logic
-	fmt.Printf("Mock OTP for %s is: %s\n", req.Email, otp)
+	token, err := utils.GenerateJWT(user.ID, user.Email, sessionVersion)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
 
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": "OTP sent to your email",
+		"message": "Password updated successfully",
+		"token":   token,
 	})
 }
 
-type ResetPasswordRequest struct {
-	Email       string `json:"email"`
-	OTP         string `json:"otp"`
-	NewPassword string `json:"new_password"`
-}
+// RevokeSessionsHandler signs out every session and scoped token of the
+// user and revokes their API keys, closing their connections, and returns a
+// fresh token for the caller.
+func RevokeSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
 
-func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
-	var req ResetPasswordRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
-
-	ctx := context.Background()
-	redisKey := fmt.Sprintf("reset_otp:%s", req.Email)
-	storedOTP, err := db.RedisClient.Get(ctx, redisKey).Result()
-	if err == redis.Nil || storedOTP != req.OTP {
-		http.Error(w, "Invalid or expired OTP", http.StatusUnauthorized)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-
-	var user models.User
-	if err := db.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	var sessionVersion int
+	var keys []models.APIKey
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if sessionVersion, err = auth.RevokeSessions(tx, user); err != nil {
+			return err
+		}
+		keys, err = auth.RevokeAPIKeys(tx, user)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Error revoking sessions", http.StatusInternalServerError)
 		return
 	}
+	ws.RevokeSessions(user.ID.String(), sessionVersion)
+	closeAPIKeyConnections(user, keys)
+	audit.Record(r, user, &user.ID, audit.ActionSessionsRevoked, map[string]interface{}{"api_keys_revoked": len(keys)})
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	token, err := utils.GenerateJWT(user.ID, user.Email, sessionVersion)
 	if err != nil {
-		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
 
-	user.PasswordHash = string(hashedPassword)
-	if err := db.DB.Save(&user).Error; err != nil {
-		http.Error(w, "Error updating password", http.StatusInternalServerError)
-		return
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Signed out everywhere else",
+		"token":   token,
+	})
+}
 
-	// Cleanup OTP from Redis
-	db.RedisClient.Del(ctx, redisKey)
+// sendSecurityAlert emails the user about a security-relevant account change
+// and sends it to their webhooks as event.
+func sendSecurityAlert(user *models.User, event, message string) {
+	webhooks.Emit(user.ID.String(), event, map[string]interface{}{
+		"email":   user.Email,
+		"message": message,
+	})
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Password reset successfully",
+	err := mailer.Send(user.Email, "security_alert", map[string]interface{}{
+		"Name":  user.Name,
+		"Event": message,
+		"Time":  time.Now(),
 	})
+	if err != nil {
+		log.Printf("Failed to queue security alert for %s: %v", user.Email, err)
+	}
 }
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/utils"
+)
+
+func TestRevokeSessionsRevokesAPIKeys(t *testing.T) {
+	useTestDB(t)
+	useTestRedis(t)
+	user := models.User{Name: "Ann", Email: "ann@example.com"}
+	db.DB.Create(&user)
+	secret, _ := auth.GenerateAPIKey()
+	db.DB.Create(&models.APIKey{UserID: user.ID, Name: "CI", KeyHash: utils.HashSecret(secret), Scopes: "clips:write"})
+	if _, err := auth.Authenticate(secret); err != nil {
+		t.Fatalf("API key before revoking: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/me/sessions/revoke", nil)
+	req = req.WithContext(auth.WithGrant(req.Context(), &auth.Grant{User: &user, Scopes: auth.AllScopes}))
+	RevokeSessionsHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", rec.Code, rec.Body)
+	}
+
+	if _, err := auth.Authenticate(secret); err == nil {
+		t.Error("API key still works after revoking sessions")
+	}
+	var n int64
+	db.DB.Model(&models.APIKey{}).Where("user_id = ?", user.ID).Count(&n)
+	if n != 0 {
+		t.Errorf("%d API keys left", n)
+	}
+}
+
+func TestEmailIsCaseInsensitive(t *testing.T) {
+	useTestDB(t)
+	useTestRedis(t)
+	register := func(email string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(RegisterRequest{Name: "Alice", Email: email, Password: "correct horse"})
+		rec := httptest.NewRecorder()
+		RegisterHandler(rec, httptest.NewRequest("POST", "/register", strings.NewReader(string(body))))
+		return rec
+	}
+
+	if rec := register(" Alice@Example.com "); rec.Code != http.StatusOK {
+		t.Fatalf("register: %d %s", rec.Code, rec.Body)
+	}
+	var user models.User
+	if err := db.DB.First(&user).Error; err != nil || user.Email != "alice@example.com" {
+		t.Fatalf("stored email %q: %v", user.Email, err)
+	}
+	if rec := register("alice@EXAMPLE.com"); rec.Code != http.StatusConflict {
+		t.Errorf("same address in other case: %d", rec.Code)
+	}
+
+	for _, email := range []string{"alice@example.com", "ALICE@example.COM"} {
+		if _, err := PasswordLogin(LoginRequest{Email: email, Password: "correct horse"}); err != nil {
+			t.Errorf("login as %s: %v", email, err)
+		}
+	}
+
+	// The index holds even for rows written around the handlers.
+	if err := db.DB.Create(&models.User{Name: "Eve", Email: "ALICE@example.com"}).Error; err == nil {
+		t.Error("unique index allowed the same address in other case")
+	}
+}
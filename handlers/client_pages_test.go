@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"clipsync.com/m/config"
+)
+
+func TestLoginClientPageRedirectURI(t *testing.T) {
+	tests := []struct {
+		name        string
+		redirectURI string
+		status      int
+		callback    string
+	}{
+		{"default", "", http.StatusOK, config.DefaultClientRedirectURI},
+		{"allowed", config.DefaultClientRedirectURI, http.StatusOK, config.DefaultClientRedirectURI},
+		{"other host", "https://evil.example/callback", http.StatusBadRequest, ""},
+		{"other path", "http://localhost:8000/steal", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/login-client"
+			if tt.redirectURI != "" {
+				target += "?redirect_uri=" + url.QueryEscape(tt.redirectURI)
+			}
+			rec := httptest.NewRecorder()
+			LoginClientPage(rec, httptest.NewRequest("GET", target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.callback != "" && !strings.Contains(rec.Body.String(), `const redirectURI = "`+tt.callback+`"`) {
+				t.Errorf("page does not send the browser to %s", tt.callback)
+			}
+		})
+	}
+}
+
+func TestPairClientPageRedirectURI(t *testing.T) {
+	rec := httptest.NewRecorder()
+	PairClientPage(rec, httptest.NewRequest("GET", "/pair-client?code=abc&redirect_uri="+url.QueryEscape("https://evil.example/callback"), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("foreign redirect_uri: %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	PairClientPage(rec, httptest.NewRequest("GET", "/pair-client?code=abc", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("default redirect_uri: %d %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), `"?token="`) || !strings.Contains(rec.Body.String(), `"#token="`) {
+		t.Error("token is not passed in the URL fragment")
+	}
+}
+
+func TestOutwardLinksUsePublicURL(t *testing.T) {
+	defer func(publicURL string) { config.PublicURL = publicURL }(config.PublicURL)
+	config.PublicURL = "https://clipsync.example.com/"
+
+	// The request's Host plays no part, so a forged header can't redirect links.
+	for _, link := range []string{pairingURL("abc"), shareURL("/share", "abc")} {
+		if !strings.HasPrefix(link, "https://clipsync.example.com/") || strings.Contains(link, "example.com//") {
+			t.Errorf("link %s is not under PublicURL", link)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/clips"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/ws"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ClipMetadataRequest holds the changes to a clip's metadata; nil fields are
+// left as they are.
+type ClipMetadataRequest struct {
+	Pinned     *bool     `json:"pinned"`
+	Favorite   *bool     `json:"favorite"`
+	Tags       *[]string `json:"tags"` // replaces every tag
+	AddTags    []string  `json:"add_tags"`
+	RemoveTags []string  `json:"remove_tags"`
+}
+
+func clipMetadataResponse(c *models.Clip, tags []string) map[string]interface{} {
+	if tags == nil {
+		tags = []string{}
+	}
+	resp := map[string]interface{}{
+		"id":           c.ID,
+		"device_id":    c.DeviceID,
+		"content_type": c.ContentType,
+		"encrypted":    c.Encrypted,
+		"size":         c.Size,
+		"pinned":       c.Pinned,
+		"favorite":     c.Favorite,
+		"tags":         tags,
+		"created_at":   c.CreatedAt,
+	}
+	if c.SenderID != nil {
+		resp["sender_id"] = c.SenderID
+	}
+	return resp
+}
+
+// normalizeTags normalizes each tag, writing a 400 if one is invalid.
+func normalizeTags(w http.ResponseWriter, tags []string) ([]string, bool) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag, err := clips.NormalizeTag(tag)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		normalized = append(normalized, tag)
+	}
+	return normalized, true
+}
+
+// ClipMetadataHandler manages pins, favorites and tags on the clips in the
+// user's history and inbox. GET lists clips newest first, filtered by
+// ?pinned=true, ?favorite=true and ?tag= (repeatable; all must match), with
+// ?limit= and ?offset=. GET ?id= returns one clip, and PATCH ?id= changes
+// it and notifies the user's devices. Content is not included.
+func ClipMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	owned := db.DB.Model(&models.Clip{}).Where("user_id = ? AND space_id IS NULL", user.ID).
+		Omit("content").Session(&gorm.Session{})
+	id := r.URL.Query().Get("id")
+	if id != "" {
+		if _, err := uuid.Parse(id); err != nil {
+			http.Error(w, "Clip not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		limit, offset, ok := listLimit(r)
+		if !ok {
+			http.Error(w, "Invalid limit or offset", http.StatusBadRequest)
+			return
+		}
+		q := r.URL.Query()
+		tags, ok := normalizeTags(w, q["tag"])
+		if !ok {
+			return
+		}
+		query := clips.WithTags(owned, tags)
+		if q.Get("pinned") == "true" {
+			query = query.Where("pinned")
+		}
+		if q.Get("favorite") == "true" {
+			query = query.Where("favorite")
+		}
+
+		var list []models.Clip
+		if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&list).Error; err != nil {
+			http.Error(w, "Error loading clips", http.StatusInternalServerError)
+			return
+		}
+		ids := make([]uuid.UUID, 0, len(list))
+		for _, c := range list {
+			ids = append(ids, c.ID)
+		}
+		byClip, err := clips.Tags(ids)
+		if err != nil {
+			http.Error(w, "Error loading clips", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]map[string]interface{}, 0, len(list))
+		for i := range list {
+			resp = append(resp, clipMetadataResponse(&list[i], byClip[list[i].ID]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case r.Method == http.MethodGet:
+		var clip models.Clip
+		if err := owned.Where("id = ?", id).First(&clip).Error; err != nil {
+			http.Error(w, "Clip not found", http.StatusNotFound)
+			return
+		}
+		meta, err := clips.LoadMetadata(&clip)
+		if err != nil {
+			http.Error(w, "Error loading clip", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(clipMetadataResponse(&clip, meta.Tags))
+
+	case r.Method == http.MethodPatch:
+		if !auth.GrantFromContext(r.Context()).Allows(auth.ScopeClipsWrite) {
+			http.Error(w, "Token is missing the "+auth.ScopeClipsWrite+" scope", http.StatusForbidden)
+			return
+		}
+		var req ClipMetadataRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		var set []string
+		if req.Tags != nil {
+			var ok bool
+			if set, ok = normalizeTags(w, *req.Tags); !ok {
+				return
+			}
+		}
+		add, ok := normalizeTags(w, req.AddTags)
+		if !ok {
+			return
+		}
+		remove, ok := normalizeTags(w, req.RemoveTags)
+		if !ok {
+			return
+		}
+
+		var clip models.Clip
+		if err := owned.Where("id = ?", id).First(&clip).Error; err != nil {
+			http.Error(w, "Clip not found", http.StatusNotFound)
+			return
+		}
+		if req.Pinned != nil {
+			clip.Pinned = *req.Pinned
+		}
+		if req.Favorite != nil {
+			clip.Favorite = *req.Favorite
+		}
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&clip).Updates(map[string]interface{}{"pinned": clip.Pinned, "favorite": clip.Favorite}).Error
+			if err != nil {
+				return err
+			}
+			if req.Tags != nil {
+				if err := clips.SetTags(tx, &clip, set); err != nil {
+					return err
+				}
+			}
+			if len(remove) > 0 {
+				if err := tx.Where("clip_id = ? AND tag IN ?", clip.ID, remove).Delete(&models.ClipTag{}).Error; err != nil {
+					return err
+				}
+			}
+			return clips.AddTags(tx, &clip, add)
+		})
+		if errors.Is(err, clips.ErrTooManyTags) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to update clip %s for user %s: %v", clip.ID, user.ID, err)
+			http.Error(w, "Error updating clip", http.StatusInternalServerError)
+			return
+		}
+
+		meta, err := clips.LoadMetadata(&clip)
+		if err != nil {
+			http.Error(w, "Error loading clip", http.StatusInternalServerError)
+			return
+		}
+		ws.ClipUpdated(user.ID.String(), meta)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(clipMetadataResponse(&clip, meta.Tags))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ClipTagsHandler lists the tags the user has used, with how many clips
+// have each.
+func ClipTagsHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	type tagCount struct {
+		Tag   string `json:"tag"`
+		Count int    `json:"count"`
+	}
+	list := []tagCount{}
+	err := db.DB.Model(&models.ClipTag{}).Select("tag, COUNT(*) AS count").
+		Where("user_id = ?", user.ID).Group("tag").Order("tag").Scan(&list).Error
+	if err != nil {
+		http.Error(w, "Error loading tags", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
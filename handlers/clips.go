@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/clips"
+	"clipsync.com/m/config"
+	"clipsync.com/m/orgs"
+	"clipsync.com/m/ws"
+	"github.com/google/uuid"
+)
+
+// defaultClipSource is the device id recorded for clips pushed over REST
+// without one.
+const defaultClipSource = "api"
+
+type PushClipRequest struct {
+	Content     string `json:"content"`
+	ContentType string `json:"content_type"`
+	DeviceID    string `json:"device_id"`
+	SpaceID     string `json:"space_id"`  // share in this space instead of the user's own devices
+	Encrypted   bool   `json:"encrypted"` // content is ciphertext the user's devices can decrypt
+}
+
+// writeClipRejected answers an error from ws.PublishClip or ws.SendToUser
+// that rejects the clip, such as an organization policy violation, and
+// reports whether it did.
+func writeClipRejected(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, clips.ErrTooLarge):
+		http.Error(w, "Clip is too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, orgs.ErrContentTypeNotAllowed):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case ws.Rejected(err):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		return false
+	}
+	return true
+}
+
+// PushClipHandler accepts a clip over plain HTTP and sends it through the
+// same pipeline as WebSocket clients. The body is either JSON
+// (PushClipRequest) or the raw clip, with ?device_id= naming the source,
+// ?space_id= the target space, if any, and ?encrypted=true marking
+// ciphertext.
+func PushClipHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(config.MaxClipSize)+4096))
+	if err != nil {
+		http.Error(w, "Clip is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var req PushClipRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	} else {
+		req.Content = string(body)
+		req.ContentType = r.Header.Get("Content-Type")
+		req.DeviceID = r.URL.Query().Get("device_id")
+		req.SpaceID = r.URL.Query().Get("space_id")
+		req.Encrypted = r.URL.Query().Get("encrypted") == "true"
+	}
+	if req.Content == "" {
+		http.Error(w, "Clip content is required", http.StatusBadRequest)
+		return
+	}
+	if req.DeviceID == "" {
+		req.DeviceID = defaultClipSource
+	}
+
+	clip, err := ws.PublishClip(user.ID.String(), req.SpaceID, req.DeviceID, req.ContentType, req.Encrypted, []byte(req.Content))
+	if writeClipRejected(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to publish clip for user %s: %v", user.ID, err)
+		http.Error(w, "Error publishing clip", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{}
+	if clip.ID != uuid.Nil {
+		resp["id"] = clip.ID // relay-only clips are not stored
+	}
+	if req.SpaceID != "" {
+		resp["space_id"] = req.SpaceID
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	online, err := ws.OnlineDevices(user.ID.String())
+	if err != nil {
+		log.Printf("Failed to load presence for user %s: %v", user.ID, err)
+	}
+	recipients := make([]string, 0, len(online))
+	for _, deviceID := range online {
+		if deviceID != req.DeviceID {
+			recipients = append(recipients, deviceID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	resp["online_devices"] = recipients
+	json.NewEncoder(w).Encode(resp)
+}
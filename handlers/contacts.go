@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
+	"clipsync.com/m/contacts"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/orgs"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ContactRequest struct {
+	Email string `json:"email"`
+}
+
+func contactResponse(c *models.Contact, other *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         c.ID,
+		"user_id":    other.ID,
+		"name":       other.Name,
+		"email":      other.Email,
+		"status":     c.Status,
+		"created_at": c.CreatedAt,
+	}
+}
+
+// findContact loads the contact entry ?id= involving user, writing a 404 if
+// there is none. Users never see that someone blocked them.
+func findContact(w http.ResponseWriter, r *http.Request, user *models.User) (*models.Contact, bool) {
+	var contact models.Contact
+	err := db.DB.Where("id = ? AND (user_id = ? OR contact_id = ?)", r.URL.Query().Get("id"), user.ID, user.ID).
+		First(&contact).Error
+	if err == nil && contact.Status == contacts.StatusBlocked && contact.ContactID != user.ID {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		http.Error(w, "Contact not found", http.StatusNotFound)
+		return nil, false
+	}
+	return &contact, true
+}
+
+// ContactsHandler lists the user's contacts and pending requests (GET), sends
+// a contact request by email (POST), and removes a contact, declines or
+// cancels a request, or lifts a block (DELETE ?id=). Requesting someone who
+// has already asked you accepts their request.
+func ContactsHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		var list []models.Contact
+		err := db.DB.Where("user_id = ? OR contact_id = ?", user.ID, user.ID).Order("created_at").Find(&list).Error
+		if err != nil {
+			http.Error(w, "Error loading contacts", http.StatusInternalServerError)
+			return
+		}
+		otherIDs := make([]uuid.UUID, 0, len(list))
+		for _, c := range list {
+			if c.UserID == user.ID {
+				otherIDs = append(otherIDs, c.ContactID)
+			} else {
+				otherIDs = append(otherIDs, c.UserID)
+			}
+		}
+		var others []models.User
+		if len(otherIDs) > 0 {
+			if err := db.DB.Where("id IN ?", otherIDs).Find(&others).Error; err != nil {
+				http.Error(w, "Error loading contacts", http.StatusInternalServerError)
+				return
+			}
+		}
+		byID := make(map[uuid.UUID]*models.User, len(others))
+		for i := range others {
+			byID[others[i].ID] = &others[i]
+		}
+
+		resp := map[string][]map[string]interface{}{
+			"contacts": {},
+			"incoming": {},
+			"outgoing": {},
+			"blocked":  {},
+		}
+		for i, c := range list {
+			other := byID[otherIDs[i]]
+			if other == nil {
+				continue
+			}
+			entry := contactResponse(&list[i], other)
+			switch {
+			case c.Status == contacts.StatusAccepted:
+				resp["contacts"] = append(resp["contacts"], entry)
+			case c.Status == contacts.StatusPending && c.ContactID == user.ID:
+				resp["incoming"] = append(resp["incoming"], entry)
+			case c.Status == contacts.StatusPending:
+				resp["outgoing"] = append(resp["outgoing"], entry)
+			case c.Status == contacts.StatusBlocked && c.ContactID == user.ID:
+				resp["blocked"] = append(resp["blocked"], entry)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodPost:
+		if !requireVerifiedEmail(w, user, config.RequireVerifiedEmailForSharing) {
+			return
+		}
+		var req ContactRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		var other models.User
+		err := db.DB.Where("LOWER(email) = ?", normalizeEmail(req.Email)).First(&other).Error
+		// Contacts never cross organizations; users elsewhere look like strangers.
+		if err != nil || !orgs.Same(user, &other) {
+			http.Error(w, "No account with that email", http.StatusNotFound)
+			return
+		}
+		if other.ID == user.ID {
+			http.Error(w, "You cannot add yourself", http.StatusBadRequest)
+			return
+		}
+
+		existing, err := contacts.Between(user.ID, other.ID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Error sending request", http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.Status == contacts.StatusAccepted:
+				http.Error(w, "Already a contact", http.StatusConflict)
+			case existing.Status == contacts.StatusPending && existing.UserID == user.ID:
+				http.Error(w, "Request already sent", http.StatusConflict)
+			case existing.Status == contacts.StatusPending:
+				if err := db.DB.Model(existing).Update("status", contacts.StatusAccepted).Error; err != nil {
+					http.Error(w, "Error accepting request", http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(contactResponse(existing, &other))
+			case existing.ContactID == user.ID:
+				http.Error(w, "You blocked this user; unblock them first", http.StatusConflict)
+			default:
+				// Blocked by the other user; answer as if the request was sent.
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusAccepted)
+				json.NewEncoder(w).Encode(map[string]string{"message": "Contact request sent"})
+			}
+			return
+		}
+
+		var pending, total int64
+		db.DB.Model(&models.Contact{}).Where("user_id = ? AND status = ?", user.ID, contacts.StatusPending).Count(&pending)
+		db.DB.Model(&models.Contact{}).Where("user_id = ? OR contact_id = ?", user.ID, user.ID).Count(&total)
+		if pending >= int64(config.ContactMaxPendingRequests) {
+			http.Error(w, "Too many unanswered requests, wait for some to be accepted", http.StatusConflict)
+			return
+		}
+		if total >= int64(config.ContactsMaxPerUser) {
+			http.Error(w, "Too many contacts, remove some first", http.StatusConflict)
+			return
+		}
+
+		contact := models.Contact{UserID: user.ID, ContactID: other.ID, Status: contacts.StatusPending}
+		if err := db.DB.Create(&contact).Error; err != nil {
+			http.Error(w, "Error sending request", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Contact request sent"})
+
+	case http.MethodDelete:
+		contact, ok := findContact(w, r, user)
+		if !ok {
+			return
+		}
+		if err := db.DB.Delete(contact).Error; err != nil {
+			http.Error(w, "Error removing contact", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Contact removed"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ContactAcceptHandler accepts the incoming contact request ?id=.
+func ContactAcceptHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	contact, ok := findContact(w, r, user)
+	if !ok {
+		return
+	}
+	if contact.Status != contacts.StatusPending || contact.ContactID != user.ID {
+		http.Error(w, "No pending request to accept", http.StatusConflict)
+		return
+	}
+	var requester models.User
+	if err := db.DB.Where("id = ?", contact.UserID).First(&requester).Error; err != nil {
+		http.Error(w, "Contact not found", http.StatusNotFound)
+		return
+	}
+	if err := db.DB.Model(contact).Update("status", contacts.StatusAccepted).Error; err != nil {
+		http.Error(w, "Error accepting request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(contactResponse(contact, &requester))
+}
+
+// ContactBlockHandler blocks the user behind the contact or request ?id=.
+// They can no longer send requests or clips; DELETE /contacts?id= lifts the
+// block.
+func ContactBlockHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	contact, ok := findContact(w, r, user)
+	if !ok {
+		return
+	}
+	blocked := contact.UserID
+	if blocked == user.ID {
+		blocked = contact.ContactID
+	}
+	err := db.DB.Model(contact).Updates(map[string]interface{}{
+		"user_id":    blocked,
+		"contact_id": user.ID,
+		"status":     contacts.StatusBlocked,
+	}).Error
+	if err != nil {
+		http.Error(w, "Error blocking user", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "User blocked"})
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/auth"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/ws"
+)
+
+func deviceResponse(d *models.Device) map[string]interface{} {
+	return map[string]interface{}{
+		"device_id":   d.DeviceID,
+		"name":        d.Name,
+		"paired_from": d.PairedFrom,
+		"created_at":  d.CreatedAt,
+	}
+}
+
+// DevicesHandler lists the authenticated user's paired devices (GET) and
+// removes one (DELETE ?device_id=, full sessions only), closing its
+// connections.
+func DevicesHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		var devices []models.Device
+		if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&devices).Error; err != nil {
+			http.Error(w, "Error loading devices", http.StatusInternalServerError)
+			return
+		}
+
+		list := make([]map[string]interface{}, 0, len(devices))
+		for i := range devices {
+			list = append(list, deviceResponse(&devices[i]))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodDelete:
+		if !auth.GrantFromContext(r.Context()).Allows(auth.ScopeAccount) {
+			http.Error(w, "Token is missing the "+auth.ScopeAccount+" scope", http.StatusForbidden)
+			return
+		}
+		deviceID := r.URL.Query().Get("device_id")
+		var device models.Device
+		if err := db.DB.Where("user_id = ? AND device_id = ?", user.ID, deviceID).First(&device).Error; err != nil {
+			http.Error(w, "Device not found", http.StatusNotFound)
+			return
+		}
+		if err := db.DB.Delete(&device).Error; err != nil {
+			http.Error(w, "Error removing device", http.StatusInternalServerError)
+			return
+		}
+		ws.Disconnect(user.ID.String(), device.DeviceID)
+		audit.Record(r, user, &user.ID, audit.ActionDeviceRemoved, map[string]interface{}{
+			"device_id": device.DeviceID,
+			"name":      device.Name,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Device removed"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/mailer"
+	"clipsync.com/m/models"
+	"clipsync.com/m/utils"
+	"clipsync.com/m/webhooks"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	emailVerifyPurpose     = "email_verify"
+	emailVerifyMaxAttempts = 5
+)
+
+type VerifyEmailRequest struct {
+	Code string `json:"code"`
+}
+
+// randomDigits returns an n-digit numeric code from crypto/rand.
+func randomDigits(n int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	v, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", n, v), nil
+}
+
+// sendVerificationEmail emails a verification link and a 6-digit code to
+// address, which is either the user's current email or a pending new one.
+// A new code replaces any previous one.
+func sendVerificationEmail(r *http.Request, user *models.User, address string) error {
+	token, err := utils.GeneratePurposeToken(user.ID, address, emailVerifyPurpose, config.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	code, err := randomDigits(6)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	codeKey := fmt.Sprintf("email_verify_code:%s", user.ID)
+	attemptsKey := fmt.Sprintf("email_verify_attempts:%s", user.ID)
+	if err := db.RedisClient.Set(ctx, codeKey, address+":"+code, config.EmailVerificationTTL).Err(); err != nil {
+		return err
+	}
+	db.RedisClient.Del(ctx, attemptsKey)
+
+	return mailer.Send(address, "email_verification", map[string]string{
+		"Name":      user.Name,
+		"Code":      code,
+		"Link":      fmt.Sprintf("%s/verify-email?token=%s", baseURL(), url.QueryEscape(token)),
+		"ExpiresIn": "24 hours",
+	})
+}
+
+// markEmailVerified confirms address for the user. Confirming the pending
+// address of an email change also makes it the account's email.
+func markEmailVerified(r *http.Request, userID, address string) error {
+	var user models.User
+	if err := db.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	switch {
+	case user.Email == address:
+		if err := db.DB.Model(&user).Updates(map[string]interface{}{
+			"email_verified":    true,
+			"email_verified_at": now,
+		}).Error; err != nil {
+			return err
+		}
+	case user.PendingEmail != "" && user.PendingEmail == address:
+		oldUser := user
+		if err := db.DB.Model(&user).Updates(map[string]interface{}{
+			"email":             address,
+			"pending_email":     "",
+			"email_verified":    true,
+			"email_verified_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		audit.Record(r, &user, &user.ID, audit.ActionEmailChanged, map[string]interface{}{"from": oldUser.Email, "to": address})
+		sendSecurityAlert(&oldUser, webhooks.EventEmailChanged, fmt.Sprintf("Your account email was changed to %s", address))
+	default:
+		return fmt.Errorf("user or email no longer matches")
+	}
+
+	ctx := context.Background()
+	db.RedisClient.Del(ctx,
+		fmt.Sprintf("email_verify_code:%s", userID),
+		fmt.Sprintf("email_verify_attempts:%s", userID))
+	autoJoinOrg(&user)
+	return nil
+}
+
+// requireVerifiedEmail writes a 403 and returns false when policy says the
+// action needs a verified address and the user has not confirmed theirs.
+func requireVerifiedEmail(w http.ResponseWriter, user *models.User, required bool) bool {
+	if !required || user.EmailVerified {
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":                     "Please verify your email address first",
+		"email_verification_required": true,
+	})
+	return false
+}
+
+var verifyEmailPage = template.Must(template.New("verify").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<title>Verify Email - ClipSync</title>
+	<style>
+		body {
+			font-family: Arial, sans-serif;
+			background: #f0f2f5;
+			display: flex;
+			justify-content: center;
+			align-items: center;
+			height: 100vh;
+			margin: 0;
+		}
+		.container {
+			background: white;
+			padding: 2rem;
+			border-radius: 12px;
+			box-shadow: 0 0 10px rgba(0,0,0,0.1);
+			width: 300px;
+			text-align: center;
+		}
+	</style>
+</head>
+<body>
+	<div class="container">
+		<h2>{{.Title}}</h2>
+		<p>{{.Message}}</p>
+	</div>
+</body>
+</html>`))
+
+// VerifyEmailHandler confirms an address from the emailed link (?token=).
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	userID, email, err := utils.ValidatePurposeToken(r.URL.Query().Get("token"), emailVerifyPurpose)
+	if err == nil {
+		err = markEmailVerified(r, userID, email)
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		verifyEmailPage.Execute(w, map[string]string{
+			"Title":   "Link expired",
+			"Message": "This verification link is invalid or has expired. Request a new one from the app.",
+		})
+		return
+	}
+	verifyEmailPage.Execute(w, map[string]string{
+		"Title":   "Email verified",
+		"Message": "Thanks! Your email address has been confirmed. You can close this page.",
+	})
+}
+
+// VerifyEmailCodeHandler confirms an address with the emailed 6-digit code.
+func VerifyEmailCodeHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	codeKey := fmt.Sprintf("email_verify_code:%s", user.ID)
+	attemptsKey := fmt.Sprintf("email_verify_attempts:%s", user.ID)
+
+	attempts, err := db.RedisClient.Incr(ctx, attemptsKey).Result()
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+	db.RedisClient.Expire(ctx, attemptsKey, config.EmailVerificationTTL)
+	if attempts > emailVerifyMaxAttempts {
+		// Too many guesses: burn the code so a new one has to be requested.
+		db.RedisClient.Del(ctx, codeKey)
+		http.Error(w, "Too many attempts, request a new code", http.StatusTooManyRequests)
+		return
+	}
+
+	stored, err := db.RedisClient.Get(ctx, codeKey).Result()
+	sep := strings.LastIndex(stored, ":")
+	if err == redis.Nil || sep < 0 || subtle.ConstantTimeCompare([]byte(stored[sep+1:]), []byte(req.Code)) != 1 {
+		http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+		return
+	}
+
+	if err := markEmailVerified(r, user.ID.String(), stored[:sep]); err != nil {
+		http.Error(w, "Error verifying email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email verified"})
+}
+
+// ResendVerificationHandler sends a fresh verification email, to the pending
+// address if an email change is in progress. It is throttled to one per
+// EmailVerificationResendInterval and EmailVerificationMaxResends a day.
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	address := user.Email
+	if user.PendingEmail != "" {
+		address = user.PendingEmail
+	} else if user.EmailVerified {
+		http.Error(w, "Email already verified", http.StatusConflict)
+		return
+	}
+
+	ctx := context.Background()
+	throttleKey := fmt.Sprintf("email_verify_resend:%s", user.ID)
+	countKey := fmt.Sprintf("email_verify_resend_count:%s", user.ID)
+
+	ok, err := db.RedisClient.SetNX(ctx, throttleKey, 1, config.EmailVerificationResendInterval).Result()
+	if err != nil {
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		ttl, _ := db.RedisClient.TTL(ctx, throttleKey).Result()
+		w.Header().Set("Retry-After", strconv.Itoa(int(ttl.Seconds())+1))
+		http.Error(w, "Please wait before requesting another email", http.StatusTooManyRequests)
+		return
+	}
+
+	count, err := db.RedisClient.Incr(ctx, countKey).Result()
+	if err != nil {
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+	if count == 1 {
+		db.RedisClient.Expire(ctx, countKey, 24*time.Hour)
+	}
+	if count > int64(config.EmailVerificationMaxResends) {
+		ttl, _ := db.RedisClient.TTL(ctx, countKey).Result()
+		w.Header().Set("Retry-After", strconv.Itoa(int(ttl.Seconds())+1))
+		http.Error(w, "Too many verification emails today", http.StatusTooManyRequests)
+		return
+	}
+
+	if err := sendVerificationEmail(r, user, address); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", address, err)
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
+}
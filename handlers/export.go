@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/clips"
+	"clipsync.com/m/contacts"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// exportFile is one JSON document in a data export archive.
+type exportFile struct {
+	Name string
+	Data interface{}
+}
+
+// exportFiles gathers everything stored about the user except clip history,
+// which writeClipsExport streams separately. Secrets (password
+// hash, TOTP secret, recovery code hashes, passkey public keys, webhook
+// signing secrets, share link tokens) are summarised rather than included.
+func exportFiles(user *models.User) ([]exportFile, error) {
+	var devices []models.Device
+	if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&devices).Error; err != nil {
+		return nil, err
+	}
+	var passkeys []models.WebAuthnCredential
+	if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&passkeys).Error; err != nil {
+		return nil, err
+	}
+	var identities []models.Identity
+	if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	var apiKeys []models.APIKey
+	if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&apiKeys).Error; err != nil {
+		return nil, err
+	}
+	var hooks []models.Webhook
+	if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&hooks).Error; err != nil {
+		return nil, err
+	}
+	var memberships []models.SpaceMember
+	if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+	var links []models.ShareLink
+	if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	var contactList []models.Contact
+	if err := db.DB.Where("user_id = ? OR contact_id = ?", user.ID, user.ID).Order("created_at").Find(&contactList).Error; err != nil {
+		return nil, err
+	}
+	var recoveryCodes []models.RecoveryCode
+	if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&recoveryCodes).Error; err != nil {
+		return nil, err
+	}
+
+	deviceList := make([]map[string]interface{}, 0, len(devices))
+	for i := range devices {
+		deviceList = append(deviceList, deviceResponse(&devices[i]))
+	}
+
+	passkeyList := make([]map[string]interface{}, 0, len(passkeys))
+	for _, c := range passkeys {
+		passkeyList = append(passkeyList, map[string]interface{}{
+			"id":           c.ID,
+			"name":         c.Name,
+			"created_at":   c.CreatedAt,
+			"last_used_at": c.LastUsedAt,
+		})
+	}
+
+	identityList := make([]map[string]interface{}, 0, len(identities))
+	for _, i := range identities {
+		identityList = append(identityList, map[string]interface{}{
+			"issuer":        i.Issuer,
+			"subject":       i.Subject,
+			"email":         i.Email,
+			"created_at":    i.CreatedAt,
+			"last_login_at": i.LastLoginAt,
+		})
+	}
+
+	apiKeyList := make([]map[string]interface{}, 0, len(apiKeys))
+	for i := range apiKeys {
+		apiKeyList = append(apiKeyList, apiKeyResponse(&apiKeys[i]))
+	}
+
+	webhookList := make([]map[string]interface{}, 0, len(hooks))
+	for i := range hooks {
+		webhookList = append(webhookList, webhookResponse(&hooks[i]))
+	}
+
+	spaceList := make([]map[string]interface{}, 0, len(memberships))
+	for _, m := range memberships {
+		var space models.Space
+		if err := db.DB.Where("id = ?", m.SpaceID).First(&space).Error; err != nil {
+			return nil, err
+		}
+		spaceList = append(spaceList, map[string]interface{}{
+			"id":        space.ID,
+			"name":      space.Name,
+			"role":      m.Role,
+			"joined_at": m.CreatedAt,
+		})
+	}
+
+	contactsExport := make([]map[string]interface{}, 0, len(contactList))
+	for _, c := range contactList {
+		// Don't reveal who blocked the user.
+		if c.Status == contacts.StatusBlocked && c.ContactID != user.ID {
+			continue
+		}
+		contactsExport = append(contactsExport, map[string]interface{}{
+			"user_id":    c.UserID,
+			"contact_id": c.ContactID,
+			"status":     c.Status,
+			"created_at": c.CreatedAt,
+		})
+	}
+
+	shareList := make([]map[string]interface{}, 0, len(links))
+	for i := range links {
+		shareList = append(shareList, shareResponse(&links[i]))
+	}
+
+	recoveryList := make([]map[string]interface{}, 0, len(recoveryCodes))
+	for _, c := range recoveryCodes {
+		recoveryList = append(recoveryList, map[string]interface{}{
+			"created_at": c.CreatedAt,
+			"used_at":    c.UsedAt,
+		})
+	}
+
+	profile := profileResponse(user)
+	profile["email_verified_at"] = user.EmailVerifiedAt
+	if user.OrgID != nil {
+		var org models.Organization
+		if err := db.DB.Where("id = ?", user.OrgID).First(&org).Error; err == nil {
+			profile["org"] = orgResponse(&org)
+		}
+	}
+
+	return []exportFile{
+		{"profile.json", profile},
+		{"devices.json", deviceList},
+		{"spaces.json", spaceList},
+		{"contacts.json", contactsExport},
+		{"shares.json", shareList},
+		{"sessions.json", map[string]interface{}{
+			// Sessions are stateless JWTs; only their revocation generation is stored.
+			"session_version":    user.SessionVersion,
+			"two_factor_enabled": user.TOTPEnabled,
+			"recovery_codes":     recoveryList,
+			"passkeys":           passkeyList,
+			"identities":         identityList,
+			"api_keys":           apiKeyList,
+			"webhooks":           webhookList,
+		}},
+	}, nil
+}
+
+// writeClipsExport adds clips.json with the user's clip history and inbox,
+// in batches so large histories aren't loaded at once, followed by one
+// blobs/<id> file per clip too large to be stored inline.
+func writeClipsExport(zw *zip.Writer, user *models.User) error {
+	fw, err := zw.Create("clips.json")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(fw, "[\n"); err != nil {
+		return err
+	}
+
+	var blobClips []models.Clip
+	first := true
+	var batch []models.Clip
+	result := db.DB.Where("user_id = ?", user.ID).Order("created_at").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		ids := make([]uuid.UUID, 0, len(batch))
+		for _, c := range batch {
+			ids = append(ids, c.ID)
+		}
+		tags, err := clips.Tags(ids)
+		if err != nil {
+			return err
+		}
+		for _, c := range batch {
+			entry := map[string]interface{}{
+				"id":           c.ID,
+				"device_id":    c.DeviceID,
+				"content_type": c.ContentType,
+				"encrypted":    c.Encrypted,
+				"size":         c.Size,
+				"pinned":       c.Pinned,
+				"favorite":     c.Favorite,
+				"created_at":   c.CreatedAt,
+			}
+			if len(tags[c.ID]) > 0 {
+				entry["tags"] = tags[c.ID]
+			}
+			if c.SpaceID != nil {
+				entry["space_id"] = c.SpaceID
+			}
+			if c.SenderID != nil {
+				entry["sender_id"] = c.SenderID
+			}
+			switch {
+			case c.BlobKey != "":
+				entry["blob"] = "blobs/" + c.ID.String()
+				blobClips = append(blobClips, models.Clip{ID: c.ID, UserID: c.UserID, BlobKey: c.BlobKey})
+			case !c.Encrypted && strings.HasPrefix(c.ContentType, "text/"):
+				entry["content"] = string(c.Content)
+			default:
+				entry["content_base64"] = base64.StdEncoding.EncodeToString(c.Content)
+			}
+
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if !first {
+				if _, err := io.WriteString(fw, ",\n"); err != nil {
+					return err
+				}
+			}
+			first = false
+			if _, err := fw.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if _, err := io.WriteString(fw, "\n]\n"); err != nil {
+		return err
+	}
+
+	for i := range blobClips {
+		content, err := clips.Content(&blobClips[i])
+		if err != nil {
+			log.Printf("Export for user %s: missing blob for clip %s: %v", user.ID, blobClips[i].ID, err)
+			continue
+		}
+		bw, err := zw.Create("blobs/" + blobClips[i].ID.String())
+		if err != nil {
+			return err
+		}
+		if _, err := bw.Write(content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportHandler streams a zip archive of the authenticated user's data:
+// JSON documents plus clip blobs.
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	files, err := exportFiles(user)
+	if err != nil {
+		http.Error(w, "Error exporting data", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("clipsync-export-%s.zip", time.Now().Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.Name)
+		if err != nil {
+			log.Printf("Export for user %s failed: %v", user.ID, err)
+			return
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.Data); err != nil {
+			log.Printf("Export for user %s failed: %v", user.ID, err)
+			return
+		}
+	}
+	if err := writeClipsExport(zw, user); err != nil {
+		log.Printf("Export for user %s failed: %v", user.ID, err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Export for user %s failed: %v", user.ID, err)
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// useTestRedis points db.RedisClient at an in-process Redis for the test.
+func useTestRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	prev := db.RedisClient
+	db.RedisClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		db.RedisClient.Close()
+		db.RedisClient = prev
+	})
+	return mr
+}
+
+// sqliteCompat rewrites the Postgres-only SQL of the models and the audit
+// log for SQLite: ids are set by setTestIDs instead of a column default, and
+// the advisory lock becomes a no-op, as the single connection already
+// serializes appends.
+var sqliteCompat = strings.NewReplacer(
+	" DEFAULT gen_random_uuid()", "",
+	"pg_advisory_xact_lock(", "abs(",
+)
+
+// useTestDB points db.DB at a fresh in-memory SQLite database with the
+// account tables. It is not Postgres, so tests using it stay away from
+// search and other Postgres features.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := gdb.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1) // every connection would get its own database
+	gdb.Callback().Raw().Before("gorm:raw").Register("test:sqlite_compat", func(tx *gorm.DB) {
+		sql := sqliteCompat.Replace(tx.Statement.SQL.String())
+		tx.Statement.SQL.Reset()
+		tx.Statement.SQL.WriteString(sql)
+	})
+	gdb.Callback().Create().Before("gorm:create").Register("test:ids", setTestIDs)
+
+	err = gdb.AutoMigrate(&models.User{}, &models.Device{}, &models.RecoveryCode{}, &models.WebAuthnCredential{},
+		&models.Identity{}, &models.APIKey{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.Organization{},
+		&models.OrgInvitation{}, &models.AuditEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Background work, such as webhooks, may still run after the test;
+	// it then fails on the closed database rather than a nil one.
+	db.DB = gdb
+	t.Cleanup(func() { sqlDB.Close() })
+}
+
+// setTestIDs gives new rows a random uuid primary key, which Postgres does
+// with gen_random_uuid().
+func setTestIDs(tx *gorm.DB) {
+	if tx.Statement.Schema == nil || tx.Statement.Schema.PrioritizedPrimaryField == nil {
+		return
+	}
+	field := tx.Statement.Schema.PrioritizedPrimaryField
+	if field.FieldType != reflect.TypeOf(uuid.UUID{}) {
+		return
+	}
+	set := func(v reflect.Value) {
+		if _, zero := field.ValueOf(tx.Statement.Context, v); zero {
+			field.Set(tx.Statement.Context, v, uuid.New())
+		}
+	}
+	rv := tx.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			set(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		set(rv)
+	}
+}
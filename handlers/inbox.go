@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/clips"
+	"clipsync.com/m/config"
+	"clipsync.com/m/contacts"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/ws"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultInboxLimit = 50
+	maxInboxLimit     = 200
+)
+
+type SendClipRequest struct {
+	Email       string `json:"email"`
+	UserID      string `json:"user_id"` // alternative to email
+	Content     string `json:"content"`
+	ContentType string `json:"content_type"`
+	DeviceID    string `json:"device_id"`
+	Encrypted   bool   `json:"encrypted"` // content is ciphertext the recipient can decrypt
+}
+
+func inboxResponse(c *models.Clip, sender *models.User) map[string]interface{} {
+	resp := map[string]interface{}{
+		"id":           c.ID,
+		"from":         nil, // the sender deleted their account
+		"device_id":    c.DeviceID,
+		"content_type": c.ContentType,
+		"encrypted":    c.Encrypted,
+		"size":         c.Size,
+		"created_at":   c.CreatedAt,
+	}
+	if sender != nil {
+		resp["from"] = map[string]interface{}{
+			"user_id": sender.ID,
+			"name":    sender.Name,
+			"email":   sender.Email,
+		}
+	}
+	return resp
+}
+
+// SendClipHandler sends a clip to another user's inbox. The recipient must be
+// an accepted contact; their online devices are notified at once.
+func SendClipHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireVerifiedEmail(w, user, config.RequireVerifiedEmailForSharing) {
+		return
+	}
+
+	var req SendClipRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, int64(config.MaxClipSize)*2)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Content == "" {
+		http.Error(w, "Clip content is required", http.StatusBadRequest)
+		return
+	}
+	if req.DeviceID == "" {
+		req.DeviceID = defaultClipSource
+	}
+
+	var recipient models.User
+	query := db.DB.Where("LOWER(email) = ?", normalizeEmail(req.Email))
+	if req.UserID != "" {
+		if _, err := uuid.Parse(req.UserID); err != nil {
+			http.Error(w, "Invalid user_id", http.StatusBadRequest)
+			return
+		}
+		query = db.DB.Where("id = ?", req.UserID)
+	}
+	if err := query.First(&recipient).Error; err != nil {
+		// Same answer as for strangers, so the endpoint can't probe for accounts.
+		http.Error(w, contacts.ErrNotContact.Error(), http.StatusForbidden)
+		return
+	}
+
+	clip, err := ws.SendToUser(user, &recipient, req.DeviceID, req.ContentType, req.Encrypted, []byte(req.Content))
+	if writeClipRejected(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to send clip from user %s to %s: %v", user.ID, recipient.ID, err)
+		http.Error(w, "Error sending clip", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{"recipient_id": recipient.ID}
+	if clip.ID != uuid.Nil {
+		resp["id"] = clip.ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// InboxHandler lists the clips other users sent to the user, newest first
+// (GET), returns one clip's content with its content type (GET ?id=), and
+// deletes one (DELETE ?id=).
+func InboxHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	inbox := db.DB.Where("user_id = ? AND sender_id IS NOT NULL", user.ID)
+	id := r.URL.Query().Get("id")
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		limit := defaultInboxLimit
+		if s := r.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxInboxLimit)
+		}
+
+		var list []models.Clip
+		err := inbox.Omit("content").Order("created_at DESC").Limit(limit).Find(&list).Error
+		if err != nil {
+			http.Error(w, "Error loading inbox", http.StatusInternalServerError)
+			return
+		}
+		senderIDs := make([]uuid.UUID, 0, len(list))
+		for _, c := range list {
+			senderIDs = append(senderIDs, *c.SenderID)
+		}
+		var senders []models.User
+		if len(senderIDs) > 0 {
+			if err := db.DB.Where("id IN ?", senderIDs).Find(&senders).Error; err != nil {
+				http.Error(w, "Error loading inbox", http.StatusInternalServerError)
+				return
+			}
+		}
+		byID := make(map[uuid.UUID]*models.User, len(senders))
+		for i := range senders {
+			byID[senders[i].ID] = &senders[i]
+		}
+
+		resp := make([]map[string]interface{}, 0, len(list))
+		for i := range list {
+			resp = append(resp, inboxResponse(&list[i], byID[*list[i].SenderID]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case r.Method == http.MethodGet:
+		var clip models.Clip
+		if err := inbox.Where("id = ?", id).First(&clip).Error; err != nil {
+			http.Error(w, "Clip not found", http.StatusNotFound)
+			return
+		}
+		content, err := clips.Content(&clip)
+		if err != nil {
+			log.Printf("Failed to read clip %s for user %s: %v", clip.ID, user.ID, err)
+			http.Error(w, "Error loading clip", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", clip.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Disposition", "attachment")
+		w.Write(content)
+
+	case r.Method == http.MethodDelete:
+		var clip models.Clip
+		if err := inbox.Where("id = ?", id).First(&clip).Error; err != nil {
+			http.Error(w, "Clip not found", http.StatusNotFound)
+			return
+		}
+		if err := db.DB.Delete(&clip).Error; err != nil {
+			http.Error(w, "Error deleting clip", http.StatusInternalServerError)
+			return
+		}
+		if err := clips.DeleteBlob(&clip); err != nil {
+			log.Printf("Failed to delete blob of clip %s: %v", clip.ID, err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Clip deleted"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
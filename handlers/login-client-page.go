@@ -2,13 +2,22 @@ package handlers
 
 import (
 	"fmt"
+	"html/template"
 	"net/http"
+
+	"clipsync.com/m/config"
 )
 
 func LoginClientPage(w http.ResponseWriter, r *http.Request) {
-	redirectURI := r.URL.Query().Get("redirect_uri")
-	if redirectURI == "" {
-		redirectURI = "http://localhost:8000/callback"
+	redirectURI, ok := clientRedirectURI(w, r)
+	if !ok {
+		return
+	}
+
+	ssoButton := ""
+	if config.OIDCEnabled {
+		ssoButton = fmt.Sprintf(`<button id="ssoButton" class="secondary">Sign in with %s</button>`,
+			template.HTMLEscapeString(config.OIDCProviderName))
 	}
 
 	html := fmt.Sprintf(`<!DOCTYPE html>
@@ -63,6 +72,12 @@ func LoginClientPage(w http.ResponseWriter, r *http.Request) {
 			color: #4CAF50;
 			margin: 0 5px;
 		}
+		button.secondary {
+			margin-top: 10px;
+			background: white;
+			color: #4CAF50;
+			border: 1px solid #4CAF50;
+		}
 		#status {
 			margin-top: 10px;
 			color: #d00;
@@ -76,37 +91,77 @@ func LoginClientPage(w http.ResponseWriter, r *http.Request) {
 		<form id="loginForm">
 			<input type="email" id="email" placeholder="Email" required>
 			<input type="password" id="password" placeholder="Password" required>
+			<div id="twoFactor" style="display: none">
+				<input type="text" id="totpCode" placeholder="Authenticator code or recovery code" autocomplete="one-time-code">
+			</div>
 			<button type="submit">Login</button>
 		</form>
+		<button id="passkeyButton" class="secondary" style="display: none">Sign in with a passkey</button>
+		%[3]s
 		<div class="links">
 			<a href="/forgot-password-client">Forgot Password?</a> |
 			<a href="/register-client">Sign up</a>
 		</div>
 		<div id="status"></div>
 	</div>
-	<script>
+	<script>%[2]s
+		const redirectURI = "%[1]s";
 		const form = document.getElementById("loginForm");
 		form.onsubmit = async (e) => {
 			e.preventDefault();
 			const email = document.getElementById("email").value;
 			const password = document.getElementById("password").value;
+			const code = document.getElementById("totpCode").value.trim();
+			const body = { email, password };
+			if (code) {
+				// Authenticator codes are 6 digits; anything else is a recovery code.
+				if (/^\d{6}$/.test(code)) {
+					body.totp_code = code;
+				} else {
+					body.recovery_code = code;
+				}
+			}
 
 			const res = await fetch("/login", {
 				method: "POST",
 				headers: { "Content-Type": "application/json" },
-				body: JSON.stringify({ email, password })
+				body: JSON.stringify(body)
 			});
 
-			const data = await res.json();
+			const data = await res.json().catch(() => ({}));
 			if (res.ok && data.token) {
-				window.location.href = "%s?token=" + data.token;
+				window.location.href = redirectURI + "?token=" + data.token;
+			} else if (data.two_factor_required) {
+				document.getElementById("twoFactor").style.display = "block";
+				document.getElementById("totpCode").focus();
+				document.getElementById("status").textContent = code ? "Invalid code" : "Enter your two-factor code";
 			} else {
 				document.getElementById("status").textContent = data.message || "Login failed";
 			}
 		};
+
+		const passkeyButton = document.getElementById("passkeyButton");
+		if (passkeysSupported()) {
+			passkeyButton.style.display = "block";
+		}
+		passkeyButton.onclick = async () => {
+			try {
+				const token = await passkeyLogin();
+				window.location.href = redirectURI + "?token=" + token;
+			} catch (err) {
+				document.getElementById("status").textContent = err.message;
+			}
+		};
+
+		const ssoButton = document.getElementById("ssoButton");
+		if (ssoButton) {
+			ssoButton.onclick = () => {
+				window.location.href = "/oidc/login?redirect_uri=" + encodeURIComponent(redirectURI);
+			};
+		}
 	</script>
 </body>
-</html>`, redirectURI)
+</html>`, template.JSEscapeString(redirectURI), passkeyScript, ssoButton)
 
 	w.Header().Set("Content-Type", "text/html")
 	w.WriteHeader(http.StatusOK)
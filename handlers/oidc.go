@@ -0,0 +1,499 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/utils"
+	"clipsync.com/m/webhooks"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateTTL = 10 * time.Minute
+	// oidcMaxCodeAttempts is how many wrong 2FA codes a login code survives.
+	oidcMaxCodeAttempts = 5
+)
+
+var (
+	errOIDCUnverifiedEmail = errors.New("identity provider did not supply a verified email")
+	errOIDCNoAccount       = errors.New("no account for identity provider email")
+)
+
+// OIDCTokenRequest exchanges the one-time login code from the callback for a
+// session token. Accounts with 2FA also send a code.
+type OIDCTokenRequest struct {
+	LoginCode    string `json:"login_code"`
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// oidcLogin is kept in Redis between the callback and the token exchange.
+type oidcLogin struct {
+	UserID   string `json:"user_id"`
+	Attempts int    `json:"attempts"`
+}
+
+// oidcState is kept in Redis between the redirect to the provider and the
+// callback.
+type oidcState struct {
+	Verifier    string `json:"verifier"`
+	Nonce       string `json:"nonce"`
+	RedirectURI string `json:"redirect_uri"`
+}
+
+var (
+	oidcMu       sync.Mutex
+	oidcProvider *oidc.Provider
+)
+
+// oidcClient discovers the provider on first use, so the server can start
+// while the identity provider is unreachable.
+func oidcClient(ctx context.Context) (*oidc.Provider, *oauth2.Config, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	if oidcProvider == nil {
+		provider, err := oidc.NewProvider(ctx, config.OIDCIssuerURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		oidcProvider = provider
+	}
+
+	return oidcProvider, &oauth2.Config{
+		ClientID:     config.OIDCClientID,
+		ClientSecret: config.OIDCClientSecret,
+		RedirectURL:  config.OIDCRedirectURL,
+		Endpoint:     oidcProvider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}, nil
+}
+
+// allowedRedirectURI reports whether raw is one of config.ClientRedirectURIs:
+// the same scheme, host and path. Tokens and login codes are only ever sent
+// there.
+func allowedRedirectURI(raw string) bool {
+	target, err := url.Parse(raw)
+	if err != nil || target.User != nil || target.Fragment != "" || target.Host == "" {
+		return false
+	}
+	for _, allowed := range config.ClientRedirectURIs {
+		a, err := url.Parse(allowed)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(a.Scheme, target.Scheme) && strings.EqualFold(a.Host, target.Host) && a.Path == target.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// clientRedirectURI returns the request's redirect_uri, or
+// config.DefaultClientRedirectURI when it has none. It writes a 400 and
+// returns false if the URI is not allowed.
+func clientRedirectURI(w http.ResponseWriter, r *http.Request) (string, bool) {
+	redirectURI := r.URL.Query().Get("redirect_uri")
+	if redirectURI == "" {
+		redirectURI = config.DefaultClientRedirectURI
+	}
+	if !allowedRedirectURI(redirectURI) {
+		http.Error(w, "redirect_uri is not an allowed client callback", http.StatusBadRequest)
+		return "", false
+	}
+	return redirectURI, true
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// OIDCLoginHandler redirects to the identity provider using the
+// authorization code flow with PKCE. After sign-in the browser is sent to
+// redirect_uri, which must be in config.ClientRedirectURIs, with a one-time
+// login code for OIDCTokenHandler.
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !config.OIDCEnabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	redirectURI, ok := clientRedirectURI(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	_, oauthConfig, err := oidcClient(ctx)
+	if err != nil {
+		log.Println("OIDC discovery failed:", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state, err := randomHex(16)
+	if err != nil {
+		http.Error(w, "Error starting sign-in", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		http.Error(w, "Error starting sign-in", http.StatusInternalServerError)
+		return
+	}
+	stored := oidcState{
+		Verifier:    oauth2.GenerateVerifier(),
+		Nonce:       nonce,
+		RedirectURI: redirectURI,
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		http.Error(w, "Error starting sign-in", http.StatusInternalServerError)
+		return
+	}
+	if err := db.RedisClient.Set(ctx, fmt.Sprintf("oidc_state:%s", state), data, oidcStateTTL).Err(); err != nil {
+		http.Error(w, "Error starting sign-in", http.StatusInternalServerError)
+		return
+	}
+
+	authURL := oauthConfig.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(stored.Verifier))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler exchanges the authorization code, verifies the ID
+// token and sends the browser back to the client with a one-time login code
+// for the matching user. The session token itself never goes in a URL.
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !config.OIDCEnabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		http.Error(w, "Sign-in was cancelled or refused: "+errCode, http.StatusUnauthorized)
+		return
+	}
+
+	data, err := db.RedisClient.GetDel(ctx, fmt.Sprintf("oidc_state:%s", query.Get("state"))).Bytes()
+	if err == redis.Nil {
+		http.Error(w, "Sign-in session expired, please try again", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error completing sign-in", http.StatusInternalServerError)
+		return
+	}
+	var state oidcState
+	if err := json.Unmarshal(data, &state); err != nil {
+		http.Error(w, "Error completing sign-in", http.StatusInternalServerError)
+		return
+	}
+
+	provider, oauthConfig, err := oidcClient(ctx)
+	if err != nil {
+		log.Println("OIDC discovery failed:", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	oauthToken, err := oauthConfig.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		log.Println("OIDC code exchange failed:", err)
+		http.Error(w, "Invalid authorization code", http.StatusUnauthorized)
+		return
+	}
+	rawIDToken, ok := oauthToken.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "Identity provider returned no ID token", http.StatusUnauthorized)
+		return
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: config.OIDCClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		log.Println("OIDC ID token verification failed:", err)
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		return
+	}
+	if idToken.Nonce != state.Nonce {
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		return
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := userForIdentity(idToken.Issuer, idToken.Subject, claims.Email, claims.EmailVerified, claims.Name)
+	switch {
+	case errors.Is(err, errOIDCUnverifiedEmail):
+		audit.Record(r, nil, nil, audit.ActionLoginFailed, map[string]interface{}{"method": "oidc", "email": claims.Email, "reason": "unverified_email"})
+		http.Error(w, "Your identity provider did not supply a verified email address", http.StatusForbidden)
+		return
+	case errors.Is(err, errOIDCNoAccount):
+		audit.Record(r, nil, nil, audit.ActionLoginFailed, map[string]interface{}{"method": "oidc", "email": claims.Email, "reason": "unknown_email"})
+		http.Error(w, "No ClipSync account exists for this email", http.StatusForbidden)
+		return
+	case err != nil:
+		log.Println("OIDC account linking failed:", err)
+		http.Error(w, "Error completing sign-in", http.StatusInternalServerError)
+		return
+	}
+
+	if user.LockedAt != nil {
+		audit.Record(r, nil, &user.ID, audit.ActionLoginFailed, map[string]interface{}{"method": "oidc", "reason": "locked"})
+		http.Error(w, "Account is locked", http.StatusForbidden)
+		return
+	}
+
+	// Checked again in case the allowed callbacks changed since the login began.
+	target, err := url.Parse(state.RedirectURI)
+	if err != nil || !allowedRedirectURI(state.RedirectURI) {
+		http.Error(w, "redirect_uri is not an allowed client callback", http.StatusBadRequest)
+		return
+	}
+	loginCode, err := randomHex(32)
+	if err != nil {
+		http.Error(w, "Error completing sign-in", http.StatusInternalServerError)
+		return
+	}
+	data, err = json.Marshal(oidcLogin{UserID: user.ID.String()})
+	if err != nil {
+		http.Error(w, "Error completing sign-in", http.StatusInternalServerError)
+		return
+	}
+	if err := db.RedisClient.Set(ctx, oidcLoginKey(loginCode), data, config.OIDCLoginCodeTTL).Err(); err != nil {
+		http.Error(w, "Error completing sign-in", http.StatusInternalServerError)
+		return
+	}
+
+	q := target.Query()
+	q.Set("login_code", loginCode)
+	target.RawQuery = q.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func oidcLoginKey(loginCode string) string {
+	return "oidc_login:" + utils.HashSecret(loginCode)
+}
+
+func oidcReauthKey(token string) string {
+	return "oidc_reauth:" + utils.HashSecret(token)
+}
+
+// OIDCTokenHandler exchanges a login code from OIDCCallbackHandler for a
+// session token. The code can be used once. Accounts with 2FA must also send
+// a TOTP or recovery code; the login code survives a few wrong ones so the
+// user can retry. The token can confirm sensitive changes on password-less
+// accounts for config.OIDCReauthWindow, see confirmPassword.
+func OIDCTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !config.OIDCEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req OIDCTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.LoginCode == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	key := oidcLoginKey(req.LoginCode)
+	data, err := db.RedisClient.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		http.Error(w, "Invalid or expired login code", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error completing sign-in", http.StatusInternalServerError)
+		return
+	}
+	var login oidcLogin
+	if err := json.Unmarshal(data, &login); err != nil {
+		http.Error(w, "Error completing sign-in", http.StatusInternalServerError)
+		return
+	}
+	var user models.User
+	if err := db.DB.Where("id = ?", login.UserID).First(&user).Error; err != nil {
+		http.Error(w, "Invalid or expired login code", http.StatusUnauthorized)
+		return
+	}
+	if user.LockedAt != nil {
+		db.RedisClient.Del(ctx, key)
+		audit.Record(r, nil, &user.ID, audit.ActionLoginFailed, map[string]interface{}{"method": "oidc", "reason": "locked"})
+		http.Error(w, "Account is locked", http.StatusForbidden)
+		return
+	}
+
+	if err := verifySecondFactor(&user, req.TOTPCode, req.RecoveryCode, time.Now()); err != nil {
+		if req.TOTPCode != "" || req.RecoveryCode != "" {
+			audit.Record(r, nil, &user.ID, audit.ActionLoginFailed, map[string]interface{}{"method": "oidc", "reason": "invalid_second_factor"})
+			login.Attempts++
+			if login.Attempts >= oidcMaxCodeAttempts {
+				db.RedisClient.Del(ctx, key)
+			} else if data, err := json.Marshal(login); err == nil {
+				db.RedisClient.Set(ctx, key, data, redis.KeepTTL)
+			}
+		}
+		writeTwoFactorRequired(w)
+		return
+	}
+	// Deleting claims the code, so two racing exchanges can't both succeed.
+	if n, err := db.RedisClient.Del(ctx, key).Result(); err != nil || n == 0 {
+		http.Error(w, "Invalid or expired login code", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := utils.GenerateJWT(user.ID, user.Email, user.SessionVersion)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+	if user.PasswordHash == "" {
+		if err := db.RedisClient.Set(ctx, oidcReauthKey(token), 1, config.OIDCReauthWindow).Err(); err != nil {
+			log.Printf("Failed to record SSO sign-in for user %s: %v", user.ID, err)
+		}
+	}
+	audit.Record(r, &user, &user.ID, audit.ActionLoginSucceeded, map[string]interface{}{"method": "oidc"})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// confirmPassword re-checks the user's identity before a sensitive change,
+// writing a 401 if it fails. Users with a password must send it. Accounts
+// created through single sign-on have none; they confirm by signing in with
+// the identity provider again and using that token within
+// config.OIDCReauthWindow.
+func confirmPassword(w http.ResponseWriter, r *http.Request, user *models.User, password string) bool {
+	if user.PasswordHash != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	if token, err := utils.BearerToken(r); err == nil {
+		if n, err := db.RedisClient.Exists(r.Context(), oidcReauthKey(token)).Result(); err == nil && n > 0 {
+			return true
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":         fmt.Sprintf("Sign in with %s again to confirm this change", config.OIDCProviderName),
+		"reauth_required": true,
+	})
+	return false
+}
+
+// userForIdentity finds the user linked to the provider account. An unlinked
+// provider account is linked to the user with the same verified email, or
+// gets a new user when OIDCAutoCreate is set.
+func userForIdentity(issuer, subject, email string, emailVerified bool, name string) (*models.User, error) {
+	now := time.Now()
+
+	var identity models.Identity
+	err := db.DB.Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error
+	if err == nil {
+		var user models.User
+		if err := db.DB.Where("id = ?", identity.UserID).First(&user).Error; err != nil {
+			return nil, err
+		}
+		db.DB.Model(&identity).Update("last_login_at", now)
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	email = normalizeEmail(email)
+	if email == "" || !emailVerified {
+		return nil, errOIDCUnverifiedEmail
+	}
+
+	var user models.User
+	linked := false
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("LOWER(email) = ?", email).First(&user).Error
+		switch {
+		case err == nil:
+			linked = true
+			if !user.EmailVerified {
+				if err := tx.Model(&user).Updates(map[string]interface{}{
+					"email_verified":    true,
+					"email_verified_at": now,
+				}).Error; err != nil {
+					return err
+				}
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound) && config.OIDCAutoCreate:
+			if name == "" {
+				name = strings.SplitN(email, "@", 2)[0]
+			}
+			user = models.User{
+				Name:            name,
+				Email:           email,
+				EmailVerified:   true,
+				EmailVerifiedAt: &now,
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return errOIDCNoAccount
+		default:
+			return err
+		}
+
+		return tx.Create(&models.Identity{
+			UserID:      user.ID,
+			Issuer:      issuer,
+			Subject:     subject,
+			Email:       email,
+			LastLoginAt: &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	autoJoinOrg(&user)
+	if linked {
+		sendSecurityAlert(&user, webhooks.EventIdentityLinked, fmt.Sprintf("Your account was linked to %s sign-in", config.OIDCProviderName))
+	}
+	return &user, nil
+}
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/oidctest"
+	"clipsync.com/m/utils"
+	"github.com/alicebob/miniredis/v2"
+	"golang.org/x/oauth2"
+)
+
+const testClientCallback = "http://localhost:8765/callback"
+
+// oidcTest runs the mock identity provider in-process and points the OIDC
+// config at it.
+type oidcTest struct {
+	t   *testing.T
+	mr  *miniredis.Miniredis
+	idp *oidctest.Provider
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+	useTestDB(t)
+	mr := useTestRedis(t)
+
+	srv := httptest.NewUnstartedServer(nil)
+	idp, err := oidctest.NewProvider("http://"+srv.Listener.Addr().String(), "clipsync-test", "test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = idp
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	enabled, issuer, clientID, secret := config.OIDCEnabled, config.OIDCIssuerURL, config.OIDCClientID, config.OIDCClientSecret
+	redirect, autoCreate, callbacks := config.OIDCRedirectURL, config.OIDCAutoCreate, config.ClientRedirectURIs
+	t.Cleanup(func() {
+		config.OIDCEnabled, config.OIDCIssuerURL, config.OIDCClientID, config.OIDCClientSecret = enabled, issuer, clientID, secret
+		config.OIDCRedirectURL, config.OIDCAutoCreate, config.ClientRedirectURIs = redirect, autoCreate, callbacks
+		oidcProvider = nil
+	})
+	config.OIDCEnabled = true
+	config.OIDCIssuerURL = idp.Issuer()
+	config.OIDCClientID = "clipsync-test"
+	config.OIDCClientSecret = "test-secret"
+	config.OIDCRedirectURL = "http://clipsync.test/oidc/callback"
+	config.OIDCAutoCreate = false
+	config.ClientRedirectURIs = []string{testClientCallback}
+	oidcProvider = nil
+
+	return &oidcTest{t: t, mr: mr, idp: idp}
+}
+
+// begin starts a sign-in and returns the provider's authorization URL.
+func (o *oidcTest) begin() *url.URL {
+	o.t.Helper()
+	rec := httptest.NewRecorder()
+	OIDCLoginHandler(rec, httptest.NewRequest("GET", "/oidc/login?redirect_uri="+url.QueryEscape(testClientCallback), nil))
+	if rec.Code != http.StatusFound {
+		o.t.Fatalf("login: %d %s", rec.Code, rec.Body)
+	}
+	authURL, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	return authURL
+}
+
+// authorize signs in at the provider and returns the callback URL it sends
+// the browser to.
+func (o *oidcTest) authorize(authURL *url.URL, email string, verified bool) *url.URL {
+	o.t.Helper()
+	form := url.Values{"email": {email}}
+	if verified {
+		form.Set("email_verified", "true")
+	}
+	req := httptest.NewRequest("POST", authURL.String(), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	o.idp.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		o.t.Fatalf("authorize: %d %s", rec.Code, rec.Body)
+	}
+	callback, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	return callback
+}
+
+func (o *oidcTest) callback(callbackURL *url.URL) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	OIDCCallbackHandler(rec, httptest.NewRequest("GET", callbackURL.String(), nil))
+	return rec
+}
+
+// loginCode signs in as email through the whole redirect flow and returns
+// the login code handed to the client.
+func (o *oidcTest) loginCode(email string) string {
+	o.t.Helper()
+	rec := o.callback(o.authorize(o.begin(), email, true))
+	if rec.Code != http.StatusFound {
+		o.t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+	}
+	target, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	if target.Scheme+"://"+target.Host+target.Path != testClientCallback {
+		o.t.Fatalf("callback redirected to %s", target)
+	}
+	if target.Query().Has("token") {
+		o.t.Fatal("callback put the session token in the URL")
+	}
+	code := target.Query().Get("login_code")
+	if code == "" {
+		o.t.Fatalf("callback redirect has no login code: %s", target)
+	}
+	return code
+}
+
+func (o *oidcTest) exchange(req OIDCTokenRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	rec := httptest.NewRecorder()
+	OIDCTokenHandler(rec, httptest.NewRequest("POST", "/oidc/token", strings.NewReader(string(body))))
+	return rec
+}
+
+// signIn signs in as email and returns the id of the session token's user.
+func (o *oidcTest) signIn(email string) string {
+	o.t.Helper()
+	rec := o.exchange(OIDCTokenRequest{LoginCode: o.loginCode(email)})
+	if rec.Code != http.StatusOK {
+		o.t.Fatalf("token: %d %s", rec.Code, rec.Body)
+	}
+	var resp struct{ Token string }
+	json.NewDecoder(rec.Body).Decode(&resp)
+	userID, err := utils.ValidateJWT(resp.Token)
+	if err != nil {
+		o.t.Fatalf("session token: %v", err)
+	}
+	return userID
+}
+
+// tamperState changes the stored sign-in state for the state in authURL.
+func (o *oidcTest) tamperState(authURL *url.URL, change func(*oidcState)) {
+	o.t.Helper()
+	key := "oidc_state:" + authURL.Query().Get("state")
+	data, err := o.mr.Get(key)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	var state oidcState
+	json.Unmarshal([]byte(data), &state)
+	change(&state)
+	changed, _ := json.Marshal(state)
+	o.mr.Set(key, string(changed))
+}
+
+func TestOIDCLoginUsesPKCEAndNonce(t *testing.T) {
+	o := newOIDCTest(t)
+	q := o.begin().Query()
+
+	if q.Get("response_type") != "code" || q.Get("client_id") != "clipsync-test" || q.Get("redirect_uri") != config.OIDCRedirectURL {
+		t.Errorf("authorization request %v", q)
+	}
+	if q.Get("code_challenge_method") != "S256" || len(q.Get("code_challenge")) != 43 {
+		t.Errorf("PKCE parameters %q, %q", q.Get("code_challenge_method"), q.Get("code_challenge"))
+	}
+	if q.Get("state") == "" || q.Get("nonce") == "" || q.Get("state") == q.Get("nonce") {
+		t.Errorf("state %q, nonce %q", q.Get("state"), q.Get("nonce"))
+	}
+	if !o.mr.Exists("oidc_state:" + q.Get("state")) {
+		t.Error("sign-in state was not stored")
+	}
+}
+
+func TestOIDCLoginRequiresAllowedRedirectURI(t *testing.T) {
+	o := newOIDCTest(t)
+	for _, uri := range []string{"", "https://evil.example/callback", "http://localhost:8765/other", "http://user@localhost:8765/callback", testClientCallback + "#x"} {
+		rec := httptest.NewRecorder()
+		OIDCLoginHandler(rec, httptest.NewRequest("GET", "/oidc/login?redirect_uri="+url.QueryEscape(uri), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("redirect_uri %q: %d", uri, rec.Code)
+		}
+	}
+	if len(o.mr.Keys()) > 0 {
+		t.Errorf("state stored for refused sign-ins: %v", o.mr.Keys())
+	}
+}
+
+func TestOIDCLinksExistingAccount(t *testing.T) {
+	o := newOIDCTest(t)
+	user := models.User{Name: "Ann", Email: "ann@example.com", PasswordHash: "x"}
+	db.DB.Create(&user)
+
+	if got := o.signIn("Ann@Example.com"); got != user.ID.String() {
+		t.Fatalf("signed in as %s, want %s", got, user.ID)
+	}
+	var identity models.Identity
+	if err := db.DB.Where("user_id = ?", user.ID).First(&identity).Error; err != nil {
+		t.Fatalf("identity not linked: %v", err)
+	}
+	if identity.Issuer != o.idp.Issuer() || identity.Subject != "mock|ann@example.com" {
+		t.Errorf("identity %s / %s", identity.Issuer, identity.Subject)
+	}
+	db.DB.First(&user, "id = ?", user.ID)
+	if !user.EmailVerified {
+		t.Error("linking did not mark the email verified")
+	}
+
+	// Later sign-ins follow the link, even once the account's email changed.
+	db.DB.Model(&user).Update("email", "ann@elsewhere.example")
+	if got := o.signIn("ann@example.com"); got != user.ID.String() {
+		t.Fatalf("second sign-in as %s, want %s", got, user.ID)
+	}
+	var identities, users int64
+	db.DB.Model(&models.Identity{}).Count(&identities)
+	db.DB.Model(&models.User{}).Count(&users)
+	if identities != 1 || users != 1 {
+		t.Errorf("%d identities and %d users after two sign-ins", identities, users)
+	}
+}
+
+func TestOIDCRefusesUnverifiedOrUnknownEmail(t *testing.T) {
+	o := newOIDCTest(t)
+	db.DB.Create(&models.User{Name: "Ann", Email: "ann@example.com"})
+
+	if rec := o.callback(o.authorize(o.begin(), "ann@example.com", false)); rec.Code != http.StatusForbidden {
+		t.Errorf("unverified email: %d %s", rec.Code, rec.Body)
+	}
+	if rec := o.callback(o.authorize(o.begin(), "bob@example.com", true)); rec.Code != http.StatusForbidden {
+		t.Errorf("unknown email without auto-create: %d %s", rec.Code, rec.Body)
+	}
+	var identities int64
+	db.DB.Model(&models.Identity{}).Count(&identities)
+	if identities != 0 {
+		t.Errorf("%d identities linked", identities)
+	}
+
+	config.OIDCAutoCreate = true
+	userID := o.signIn("bob@example.com")
+	var bob models.User
+	if err := db.DB.First(&bob, "id = ?", userID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bob.Email != "bob@example.com" || !bob.EmailVerified || bob.PasswordHash != "" {
+		t.Errorf("auto-created user %+v", bob)
+	}
+}
+
+func TestOIDCCallbackStateIsSingleUse(t *testing.T) {
+	o := newOIDCTest(t)
+	db.DB.Create(&models.User{Name: "Ann", Email: "ann@example.com"})
+
+	callbackURL := o.authorize(o.begin(), "ann@example.com", true)
+	if rec := o.callback(callbackURL); rec.Code != http.StatusFound {
+		t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+	}
+	if rec := o.callback(callbackURL); rec.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback: %d", rec.Code)
+	}
+
+	forged := *callbackURL
+	q := forged.Query()
+	q.Set("state", "forged")
+	forged.RawQuery = q.Encode()
+	if rec := o.callback(&forged); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown state: %d", rec.Code)
+	}
+}
+
+func TestOIDCCallbackChecksPKCEVerifier(t *testing.T) {
+	o := newOIDCTest(t)
+	db.DB.Create(&models.User{Name: "Ann", Email: "ann@example.com"})
+
+	authURL := o.begin()
+	callbackURL := o.authorize(authURL, "ann@example.com", true)
+	o.tamperState(authURL, func(s *oidcState) { s.Verifier = oauth2.GenerateVerifier() })
+	if rec := o.callback(callbackURL); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code verifier: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCCallbackChecksNonce(t *testing.T) {
+	o := newOIDCTest(t)
+	db.DB.Create(&models.User{Name: "Ann", Email: "ann@example.com"})
+
+	authURL := o.begin()
+	callbackURL := o.authorize(authURL, "ann@example.com", true)
+	o.tamperState(authURL, func(s *oidcState) { s.Nonce = "other-nonce" })
+	if rec := o.callback(callbackURL); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong nonce: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCCallbackRefusesLockedAccount(t *testing.T) {
+	o := newOIDCTest(t)
+	now := time.Now()
+	db.DB.Create(&models.User{Name: "Ann", Email: "ann@example.com", LockedAt: &now})
+
+	if rec := o.callback(o.authorize(o.begin(), "ann@example.com", true)); rec.Code != http.StatusForbidden {
+		t.Errorf("locked account: %d %s", rec.Code, rec.Body)
+	}
+	var failures int64
+	db.DB.Model(&models.AuditEvent{}).Where("action = ?", audit.ActionLoginFailed).Count(&failures)
+	if failures != 1 {
+		t.Errorf("%d failed sign-ins audited", failures)
+	}
+}
+
+func TestOIDCTokenRequiresSecondFactor(t *testing.T) {
+	o := newOIDCTest(t)
+	secret, _ := utils.GenerateTOTPSecret()
+	user := models.User{Name: "Ann", Email: "ann@example.com", TOTPEnabled: true, TOTPSecret: secret}
+	db.DB.Create(&user)
+
+	loginCode := o.loginCode("ann@example.com")
+	rec := o.exchange(OIDCTokenRequest{LoginCode: loginCode})
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "two_factor_required") {
+		t.Fatalf("without a code: %d %s", rec.Code, rec.Body)
+	}
+	if rec := o.exchange(OIDCTokenRequest{LoginCode: loginCode, TOTPCode: "000000"}); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong code: %d", rec.Code)
+	}
+
+	code, _ := utils.TOTPCode(secret, utils.TOTPStep(time.Now()))
+	if rec := o.exchange(OIDCTokenRequest{LoginCode: loginCode, TOTPCode: code}); rec.Code != http.StatusOK {
+		t.Fatalf("right code: %d %s", rec.Code, rec.Body)
+	}
+	next, _ := utils.TOTPCode(secret, utils.TOTPStep(time.Now())+1)
+	if rec := o.exchange(OIDCTokenRequest{LoginCode: loginCode, TOTPCode: next}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("reused login code: %d", rec.Code)
+	}
+}
+
+func TestOIDCLoginCodeSurvivesFewWrongCodes(t *testing.T) {
+	o := newOIDCTest(t)
+	secret, _ := utils.GenerateTOTPSecret()
+	db.DB.Create(&models.User{Name: "Ann", Email: "ann@example.com", TOTPEnabled: true, TOTPSecret: secret})
+
+	loginCode := o.loginCode("ann@example.com")
+	for i := 0; i < oidcMaxCodeAttempts; i++ {
+		o.exchange(OIDCTokenRequest{LoginCode: loginCode, TOTPCode: "000000"})
+	}
+	code, _ := utils.TOTPCode(secret, utils.TOTPStep(time.Now()))
+	rec := o.exchange(OIDCTokenRequest{LoginCode: loginCode, TOTPCode: code})
+	if rec.Code != http.StatusUnauthorized || strings.Contains(rec.Body.String(), "two_factor_required") {
+		t.Errorf("after %d wrong codes: %d %s; the login code should be gone", oidcMaxCodeAttempts, rec.Code, rec.Body)
+	}
+}
+
+func TestConfirmPasswordAfterFreshSSO(t *testing.T) {
+	o := newOIDCTest(t)
+	config.OIDCAutoCreate = true
+	o.signIn("bob@example.com")
+
+	var bob models.User
+	db.DB.First(&bob, "email = ?", "bob@example.com")
+	fresh := o.exchange(OIDCTokenRequest{LoginCode: o.loginCode("bob@example.com")})
+	var resp struct{ Token string }
+	json.NewDecoder(fresh.Body).Decode(&resp)
+
+	confirm := func(token string) (bool, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest("POST", "/me/email", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		return confirmPassword(rec, req, &bob, ""), rec
+	}
+	if ok, rec := confirm(resp.Token); !ok {
+		t.Errorf("fresh SSO token refused: %d %s", rec.Code, rec.Body)
+	}
+
+	other, _ := utils.GenerateScopedJWT(bob.ID, bob.Email, bob.SessionVersion, []string{auth.ScopeClipsRead}, time.Hour)
+	if ok, rec := confirm(other); ok || rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "reauth_required") {
+		t.Errorf("token not from a fresh sign-in: %v, %d %s", ok, rec.Code, rec.Body)
+	}
+	o.mr.FastForward(config.OIDCReauthWindow + time.Second)
+	if ok, _ := confirm(resp.Token); ok {
+		t.Error("SSO token accepted after the re-auth window")
+	}
+}
@@ -0,0 +1,685 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
+	"clipsync.com/m/contacts"
+	"clipsync.com/m/db"
+	"clipsync.com/m/mailer"
+	"clipsync.com/m/models"
+	"clipsync.com/m/orgs"
+	"clipsync.com/m/spaces"
+	"clipsync.com/m/ws"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OrgRequest holds the organization settings to change; nil fields are left
+// as they are.
+type OrgRequest struct {
+	Name                *string   `json:"name"`
+	Domain              *string   `json:"domain"` // "" to turn off auto-join
+	MaxClipSize         *int      `json:"max_clip_size"`
+	RetentionDays       *int      `json:"retention_days"`
+	KeepLastClips       *int      `json:"keep_last_clips"`
+	RelayOnly           *bool     `json:"relay_only"`
+	RequireEncryption   *bool     `json:"require_encryption"`
+	AllowedContentTypes *[]string `json:"allowed_content_types"` // [] to allow all
+}
+
+type CreateOrgRequest struct {
+	OrgRequest
+	AdminEmail string `json:"admin_email"` // optional first admin
+}
+
+type OrgInvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"` // defaults to member
+}
+
+type OrgMemberRequest struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+func orgResponse(org *models.Organization) map[string]interface{} {
+	types := strings.Fields(org.AllowedContentTypes)
+	if types == nil {
+		types = []string{}
+	}
+	return map[string]interface{}{
+		"id":                    org.ID,
+		"name":                  org.Name,
+		"domain":                org.Domain,
+		"max_clip_size":         org.MaxClipSize,
+		"retention_days":        org.RetentionDays,
+		"keep_last_clips":       org.KeepLastClips,
+		"relay_only":            org.RelayOnly,
+		"require_encryption":    org.RequireEncryption,
+		"allowed_content_types": types,
+		"created_at":            org.CreatedAt,
+	}
+}
+
+func orgMemberResponse(user *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":            user.ID,
+		"name":               user.Name,
+		"email":              user.Email,
+		"role":               user.OrgRole,
+		"encryption_enabled": user.EncryptionEnabled,
+	}
+}
+
+func orgInvitationResponse(inv *models.OrgInvitation) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         inv.ID,
+		"org_id":     inv.OrgID,
+		"email":      inv.Email,
+		"role":       inv.Role,
+		"expires_at": inv.ExpiresAt,
+		"created_at": inv.CreatedAt,
+	}
+}
+
+// orgUpdates validates req against org (nil when creating one) and returns
+// the column updates it asks for, writing an error if it is invalid.
+func orgUpdates(w http.ResponseWriter, org *models.Organization, req *OrgRequest) (map[string]interface{}, bool) {
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" || len(name) > 100 {
+			http.Error(w, "Name is required and must be at most 100 characters", http.StatusBadRequest)
+			return nil, false
+		}
+		updates["name"] = name
+	}
+	if req.Domain != nil {
+		domain := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(*req.Domain)), "@")
+		if domain != "" && (!strings.Contains(domain, ".") || strings.ContainsAny(domain, "@ /")) {
+			http.Error(w, "Invalid domain", http.StatusBadRequest)
+			return nil, false
+		}
+		if orgs.PublicEmailDomain(domain) {
+			http.Error(w, "Public email domains can't be used for auto-join", http.StatusBadRequest)
+			return nil, false
+		}
+		if domain != "" {
+			query := db.DB.Model(&models.Organization{}).Where("domain = ?", domain)
+			if org != nil {
+				query = query.Where("id <> ?", org.ID)
+			}
+			var taken int64
+			if err := query.Count(&taken).Error; err != nil {
+				http.Error(w, "Error saving organization", http.StatusInternalServerError)
+				return nil, false
+			}
+			if taken > 0 {
+				http.Error(w, "Another organization already uses that domain", http.StatusConflict)
+				return nil, false
+			}
+		}
+		updates["domain"] = domain
+	}
+	if req.MaxClipSize != nil {
+		if *req.MaxClipSize < 0 || *req.MaxClipSize > config.MaxClipSize {
+			http.Error(w, fmt.Sprintf("max_clip_size must be between 0 and %d", config.MaxClipSize), http.StatusBadRequest)
+			return nil, false
+		}
+		updates["max_clip_size"] = *req.MaxClipSize
+	}
+	if req.RetentionDays != nil {
+		if *req.RetentionDays < 0 {
+			http.Error(w, "retention_days cannot be negative", http.StatusBadRequest)
+			return nil, false
+		}
+		updates["retention_days"] = *req.RetentionDays
+	}
+	if req.KeepLastClips != nil {
+		if *req.KeepLastClips < 0 {
+			http.Error(w, "keep_last_clips cannot be negative", http.StatusBadRequest)
+			return nil, false
+		}
+		updates["keep_last_clips"] = *req.KeepLastClips
+	}
+	if req.RelayOnly != nil {
+		updates["relay_only"] = *req.RelayOnly
+	}
+	if req.RequireEncryption != nil {
+		updates["require_encryption"] = *req.RequireEncryption
+	}
+	if req.AllowedContentTypes != nil {
+		if !orgs.ValidContentTypes(*req.AllowedContentTypes) {
+			http.Error(w, `allowed_content_types must be media types such as "text/plain" or "image/*"`, http.StatusBadRequest)
+			return nil, false
+		}
+		updates["allowed_content_types"] = strings.Join(*req.AllowedContentTypes, " ")
+	}
+	return updates, true
+}
+
+// moveToOrg puts the user in org with role, or takes them out of their
+// organization when org is nil. Contacts and spaces can't cross
+// organizations, so the user's are removed and any connections to those
+// spaces closed.
+func moveToOrg(user *models.User, org *models.Organization, role string) error {
+	updates := map[string]interface{}{"org_id": nil, "org_role": ""}
+	if org != nil {
+		updates = map[string]interface{}{"org_id": org.ID, "org_role": role}
+	}
+
+	var left, deleted []uuid.UUID
+	var blobs []models.Clip
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.SpaceMember{}).Where("user_id = ?", user.ID).Pluck("space_id", &left).Error; err != nil {
+			return err
+		}
+		var err error
+		if deleted, blobs, err = spaces.LeaveAll(tx, user.ID); err != nil {
+			return err
+		}
+		return contacts.DeleteAll(tx, user.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	deleteClipBlobs(blobs)
+	for _, spaceID := range left {
+		ws.RemoveSpaceMember(spaceID.String(), user.ID.String())
+	}
+	for _, spaceID := range deleted {
+		ws.DeleteSpace(spaceID.String())
+	}
+	return nil
+}
+
+// autoJoinOrg adds a user who is in no organization to the one that claims
+// their verified email's domain, if any.
+func autoJoinOrg(user *models.User) {
+	if user.OrgID != nil || !user.EmailVerified {
+		return
+	}
+	org, err := orgs.ForEmail(user.Email)
+	if err != nil {
+		log.Printf("Failed to look up organization for user %s: %v", user.ID, err)
+		return
+	}
+	if org == nil {
+		return
+	}
+	if err := moveToOrg(user, org, orgs.RoleMember); err != nil {
+		log.Printf("Failed to add user %s to organization %s: %v", user.ID, org.ID, err)
+		return
+	}
+	log.Printf("User %s joined organization %s by email domain", user.ID, org.ID)
+}
+
+// lastOrgAdmin reports whether user is the only admin of their organization.
+func lastOrgAdmin(user *models.User) (bool, error) {
+	if !orgs.IsAdmin(user) {
+		return false, nil
+	}
+	var admins int64
+	err := db.DB.Model(&models.User{}).Where("org_id = ? AND org_role = ?", user.OrgID, orgs.RoleAdmin).Count(&admins).Error
+	return admins <= 1, err
+}
+
+// loadOrg returns the user's organization, writing a 404 if they are not in
+// one, or a 403 if adminOnly is set and they are not its admin.
+func loadOrg(w http.ResponseWriter, user *models.User, adminOnly bool) (*models.Organization, bool) {
+	if user.OrgID == nil {
+		http.Error(w, "You are not in an organization", http.StatusNotFound)
+		return nil, false
+	}
+	if adminOnly && !orgs.IsAdmin(user) {
+		http.Error(w, "Only organization admins can do this", http.StatusForbidden)
+		return nil, false
+	}
+	var org models.Organization
+	if err := db.DB.Where("id = ?", user.OrgID).First(&org).Error; err != nil {
+		http.Error(w, "Error loading organization", http.StatusInternalServerError)
+		return nil, false
+	}
+	return &org, true
+}
+
+// OrgHandler returns the user's organization and its policies (GET) and lets
+// its admins change them (PATCH).
+func OrgHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		org, ok := loadOrg(w, user, false)
+		if !ok {
+			return
+		}
+		resp := orgResponse(org)
+		resp["role"] = user.OrgRole
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodPatch:
+		org, ok := loadOrg(w, user, true)
+		if !ok {
+			return
+		}
+		var req OrgRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		// Auto-join moves every new user at the domain into the
+		// organization, so only server admins may choose it.
+		if req.Domain != nil {
+			http.Error(w, "Only server admins can change the organization's domain", http.StatusForbidden)
+			return
+		}
+		updates, ok := orgUpdates(w, org, &req)
+		if !ok {
+			return
+		}
+		if len(updates) > 0 {
+			if err := db.DB.Model(org).Updates(updates).Error; err != nil {
+				http.Error(w, "Error saving organization", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(orgResponse(org))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// OrgMembersHandler lets organization admins list members (GET), change
+// their role (PATCH) and remove them (DELETE ?user_id=). Any member can
+// remove themselves to leave. An organization always keeps at least one
+// admin.
+func OrgMembersHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		org, ok := loadOrg(w, user, true)
+		if !ok {
+			return
+		}
+		var members []models.User
+		if err := db.DB.Where("org_id = ?", org.ID).Order("created_at").Find(&members).Error; err != nil {
+			http.Error(w, "Error loading members", http.StatusInternalServerError)
+			return
+		}
+		list := make([]map[string]interface{}, 0, len(members))
+		for i := range members {
+			list = append(list, orgMemberResponse(&members[i]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPatch:
+		org, ok := loadOrg(w, user, true)
+		if !ok {
+			return
+		}
+		var req OrgMemberRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !orgs.ValidRole(req.Role) {
+			http.Error(w, "Role must be admin or member", http.StatusBadRequest)
+			return
+		}
+		var member models.User
+		if err := db.DB.Where("id = ? AND org_id = ?", req.UserID, org.ID).First(&member).Error; err != nil {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		if last, err := lastOrgAdmin(&member); err != nil || (last && req.Role != orgs.RoleAdmin) {
+			http.Error(w, "An organization needs at least one admin", http.StatusConflict)
+			return
+		}
+		if err := db.DB.Model(&member).Update("org_role", req.Role).Error; err != nil {
+			http.Error(w, "Error changing role", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(orgMemberResponse(&member))
+
+	case http.MethodDelete:
+		userID := r.URL.Query().Get("user_id")
+		self := userID == user.ID.String()
+		org, ok := loadOrg(w, user, !self)
+		if !ok {
+			return
+		}
+		var member models.User
+		if err := db.DB.Where("id = ? AND org_id = ?", userID, org.ID).First(&member).Error; err != nil {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		if last, err := lastOrgAdmin(&member); err != nil || last {
+			http.Error(w, "An organization needs at least one admin; make someone else admin first", http.StatusConflict)
+			return
+		}
+		if err := moveToOrg(&member, nil, ""); err != nil {
+			http.Error(w, "Error removing member", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Member removed"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// OrgInvitationsHandler lets organization admins list pending invitations
+// (GET), invite an email address (POST) and withdraw an invitation (DELETE
+// ?id=). The invitee gets an email and accepts with POST /org/join.
+func OrgInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	org, ok := loadOrg(w, user, true)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var list []models.OrgInvitation
+		err := db.DB.Where("org_id = ? AND accepted_at IS NULL AND expires_at > ?", org.ID, time.Now()).
+			Order("created_at").Find(&list).Error
+		if err != nil {
+			http.Error(w, "Error loading invitations", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]map[string]interface{}, 0, len(list))
+		for i := range list {
+			resp = append(resp, orgInvitationResponse(&list[i]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodPost:
+		var req OrgInvitationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		email := normalizeEmail(req.Email)
+		if !strings.Contains(email, "@") {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+		if req.Role == "" {
+			req.Role = orgs.RoleMember
+		}
+		if !orgs.ValidRole(req.Role) {
+			http.Error(w, "Role must be admin or member", http.StatusBadRequest)
+			return
+		}
+		var members int64
+		db.DB.Model(&models.User{}).Where("LOWER(email) = ? AND org_id = ?", email, org.ID).Count(&members)
+		if members > 0 {
+			http.Error(w, "Already a member", http.StatusConflict)
+			return
+		}
+
+		inv := models.OrgInvitation{
+			OrgID:     org.ID,
+			Email:     email,
+			Role:      req.Role,
+			InvitedBy: user.ID,
+			ExpiresAt: time.Now().Add(config.OrgInvitationTTL),
+		}
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			// A new invitation replaces any pending one for the address.
+			if err := tx.Where("org_id = ? AND email = ? AND accepted_at IS NULL", org.ID, email).
+				Delete(&models.OrgInvitation{}).Error; err != nil {
+				return err
+			}
+			return tx.Create(&inv).Error
+		})
+		if err != nil {
+			http.Error(w, "Error creating invitation", http.StatusInternalServerError)
+			return
+		}
+
+		if err := mailer.Send(email, "org_invitation", map[string]string{
+			"Org":       org.Name,
+			"InvitedBy": user.Name,
+			"Role":      inv.Role,
+			"ExpiresIn": fmt.Sprintf("%d days", int(config.OrgInvitationTTL.Hours()/24)),
+		}); err != nil {
+			log.Printf("Failed to send organization invitation to %s: %v", email, err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(orgInvitationResponse(&inv))
+
+	case http.MethodDelete:
+		result := db.DB.Where("id = ? AND org_id = ? AND accepted_at IS NULL", r.URL.Query().Get("id"), org.ID).
+			Delete(&models.OrgInvitation{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Invitation withdrawn"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// OrgJoinHandler lists the pending invitations for the user's verified
+// email (GET) and accepts one (POST ?id=). Accepting moves the user out of
+// their current organization, if any.
+func OrgJoinHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if !requireVerifiedEmail(w, user, true) {
+		return
+	}
+	pending := db.DB.Where("email = ? AND accepted_at IS NULL AND expires_at > ?", normalizeEmail(user.Email), time.Now())
+
+	switch r.Method {
+	case http.MethodGet:
+		var list []models.OrgInvitation
+		if err := pending.Order("created_at").Find(&list).Error; err != nil {
+			http.Error(w, "Error loading invitations", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]map[string]interface{}, 0, len(list))
+		for i := range list {
+			entry := orgInvitationResponse(&list[i])
+			var org models.Organization
+			if err := db.DB.Where("id = ?", list[i].OrgID).First(&org).Error; err == nil {
+				entry["org_name"] = org.Name
+			}
+			resp = append(resp, entry)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodPost:
+		var inv models.OrgInvitation
+		if err := pending.Where("id = ?", r.URL.Query().Get("id")).First(&inv).Error; err != nil {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+		var org models.Organization
+		if err := db.DB.Where("id = ?", inv.OrgID).First(&org).Error; err != nil {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+		if last, err := lastOrgAdmin(user); err != nil || last {
+			http.Error(w, "You are the only admin of your current organization; make someone else admin first", http.StatusConflict)
+			return
+		}
+		if err := moveToOrg(user, &org, inv.Role); err != nil {
+			http.Error(w, "Error joining organization", http.StatusInternalServerError)
+			return
+		}
+		db.DB.Model(&inv).Update("accepted_at", time.Now())
+
+		resp := orgResponse(&org)
+		resp["role"] = user.OrgRole
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AdminOrgsHandler lets server admins list organizations (GET), create one
+// with an optional first admin (POST), change one's settings including its
+// domain (PATCH ?id=), and delete one (DELETE ?id=), which moves its members
+// out of it.
+func AdminOrgsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var list []models.Organization
+		if err := db.DB.Order("created_at").Find(&list).Error; err != nil {
+			http.Error(w, "Error loading organizations", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]map[string]interface{}, 0, len(list))
+		for i := range list {
+			entry := orgResponse(&list[i])
+			var members int64
+			db.DB.Model(&models.User{}).Where("org_id = ?", list[i].ID).Count(&members)
+			entry["members"] = members
+			resp = append(resp, entry)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodPost:
+		var req CreateOrgRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Name == nil {
+			http.Error(w, "Name is required and must be at most 100 characters", http.StatusBadRequest)
+			return
+		}
+		updates, ok := orgUpdates(w, nil, &req.OrgRequest)
+		if !ok {
+			return
+		}
+		var admin *models.User
+		if req.AdminEmail != "" {
+			var u models.User
+			if err := db.DB.Where("LOWER(email) = ?", normalizeEmail(req.AdminEmail)).First(&u).Error; err != nil {
+				http.Error(w, "No account with that email", http.StatusNotFound)
+				return
+			}
+			if last, err := lastOrgAdmin(&u); err != nil || last {
+				http.Error(w, "That user is the only admin of another organization", http.StatusConflict)
+				return
+			}
+			admin = &u
+		}
+
+		org := models.Organization{Name: updates["name"].(string)}
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&org).Error; err != nil {
+				return err
+			}
+			return tx.Model(&org).Updates(updates).Error
+		})
+		if err != nil {
+			http.Error(w, "Error creating organization", http.StatusInternalServerError)
+			return
+		}
+		if admin != nil {
+			if err := moveToOrg(admin, &org, orgs.RoleAdmin); err != nil {
+				http.Error(w, "Organization created, but adding its admin failed", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(orgResponse(&org))
+
+	case http.MethodPatch:
+		var org models.Organization
+		if err := db.DB.Where("id = ?", r.URL.Query().Get("id")).First(&org).Error; err != nil {
+			http.Error(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		var req OrgRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		updates, ok := orgUpdates(w, &org, &req)
+		if !ok {
+			return
+		}
+		if len(updates) > 0 {
+			if err := db.DB.Model(&org).Updates(updates).Error; err != nil {
+				http.Error(w, "Error saving organization", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(orgResponse(&org))
+
+	case http.MethodDelete:
+		var org models.Organization
+		if err := db.DB.Where("id = ?", r.URL.Query().Get("id")).First(&org).Error; err != nil {
+			http.Error(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		var members []models.User
+		if err := db.DB.Where("org_id = ?", org.ID).Find(&members).Error; err != nil {
+			http.Error(w, "Error deleting organization", http.StatusInternalServerError)
+			return
+		}
+		for i := range members {
+			if err := moveToOrg(&members[i], nil, ""); err != nil {
+				http.Error(w, "Error deleting organization", http.StatusInternalServerError)
+				return
+			}
+		}
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("org_id = ?", org.ID).Delete(&models.OrgInvitation{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&org).Error
+		})
+		if err != nil {
+			http.Error(w, "Error deleting organization", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Organization deleted"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
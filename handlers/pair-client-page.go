@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+func PairClientPage(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	redirectURI, ok := clientRedirectURI(w, r)
+	if !ok {
+		return
+	}
+
+	page := fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Pair Device - ClipSync</title>
+	<style>
+		body {
+			font-family: Arial, sans-serif;
+			background: #f0f2f5;
+			display: flex;
+			justify-content: center;
+			align-items: center;
+			height: 100vh;
+			margin: 0;
+		}
+		.container {
+			background: white;
+			padding: 2rem;
+			border-radius: 12px;
+			box-shadow: 0 0 10px rgba(0,0,0,0.1);
+			width: 300px;
+			text-align: center;
+		}
+		input {
+			width: 100%%;
+			padding: 10px;
+			margin: 10px 0;
+			border: 1px solid #ccc;
+			border-radius: 8px;
+		}
+		button {
+			width: 100%%;
+			padding: 10px;
+			background: #4CAF50;
+			color: white;
+			border: none;
+			border-radius: 8px;
+			cursor: pointer;
+			font-size: 16px;
+		}
+		button:hover {
+			background: #45a049;
+		}
+		#status {
+			margin-top: 10px;
+			color: #d00;
+			font-size: 14px;
+		}
+	</style>
+</head>
+<body>
+	<div class="container">
+		<h2>Pair this device</h2>
+		<form id="pairForm">
+			<input type="text" id="deviceName" placeholder="Device name" required>
+			<button type="submit">Pair</button>
+		</form>
+		<div id="status"></div>
+	</div>
+	<script>
+		const code = "%s";
+		const redirectURI = "%s";
+		const status = document.getElementById("status");
+		const deviceID = localStorage.getItem("clipsync_device_id") || crypto.randomUUID();
+		localStorage.setItem("clipsync_device_id", deviceID);
+
+		const poll = async () => {
+			const res = await fetch("/pair/status?token=" + encodeURIComponent(code) + "&device_id=" + encodeURIComponent(deviceID));
+			if (!res.ok) {
+				status.textContent = res.status === 403 ? "Pairing was declined." : "Pairing code expired.";
+				return;
+			}
+			const data = await res.json();
+			if (data.token) {
+				// In the fragment, so the token stays out of server logs and Referer.
+				window.location.href = redirectURI + "#token=" + encodeURIComponent(data.token) + "&device_id=" + encodeURIComponent(deviceID);
+				return;
+			}
+			setTimeout(poll, 2000);
+		};
+
+		document.getElementById("pairForm").onsubmit = async (e) => {
+			e.preventDefault();
+			const res = await fetch("/pair/redeem", {
+				method: "POST",
+				headers: { "Content-Type": "application/json" },
+				body: JSON.stringify({
+					token: code,
+					device_id: deviceID,
+					device_name: document.getElementById("deviceName").value
+				})
+			});
+
+			if (res.ok) {
+				status.style.color = "#333";
+				status.textContent = "Confirm the pairing on your other device...";
+				poll();
+			} else {
+				status.textContent = "Invalid or expired pairing code.";
+			}
+		};
+	</script>
+</body>
+</html>`, template.JSEscapeString(code), template.JSEscapeString(redirectURI))
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(page))
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/pairing"
+	"clipsync.com/m/utils"
+	"clipsync.com/m/ws"
+	"github.com/skip2/go-qrcode"
+)
+
+type PairStartRequest struct {
+	DeviceID string `json:"device_id"`
+}
+
+type PairRedeemRequest struct {
+	Token      string `json:"token"`
+	DeviceID   string `json:"device_id"`
+	DeviceName string `json:"device_name"`
+}
+
+type PairConfirmRequest struct {
+	Token    string `json:"token"`
+	DeviceID string `json:"device_id"` // the device that started the pairing
+	Approve  bool   `json:"approve"`
+}
+
+// baseURL is config.PublicURL without a trailing slash. Outward links use it
+// rather than the request's Host, which the client controls.
+func baseURL() string {
+	return strings.TrimRight(config.PublicURL, "/")
+}
+
+func pairingURL(token string) string {
+	return fmt.Sprintf("%s/pair-client?code=%s", baseURL(), url.QueryEscape(token))
+}
+
+// PairStartHandler is called by an already signed-in device to obtain a
+// pairing token it can show as a QR code.
+func PairStartHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if !requireVerifiedEmail(w, user, config.RequireVerifiedEmailForPairing) {
+		return
+	}
+
+	var req PairStartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DeviceID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, err := pairing.Start(context.Background(), user.ID.String(), req.DeviceID)
+	if err != nil {
+		http.Error(w, "Failed to create pairing token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":       token,
+		"pairing_url": pairingURL(token),
+		"qr_url":      fmt.Sprintf("/pair/qr?token=%s", url.QueryEscape(token)),
+		"expires_in":  int(pairing.TokenTTL.Seconds()),
+	})
+}
+
+// PairQRHandler renders the pairing URL for a pending token as a PNG QR code.
+func PairQRHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	req, err := pairing.Get(context.Background(), token)
+	if err != nil || req.Status != pairing.StatusPending {
+		http.Error(w, "Invalid or expired pairing token", http.StatusNotFound)
+		return
+	}
+
+	png, err := qrcode.Encode(pairingURL(token), qrcode.Medium, 256)
+	if err != nil {
+		http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
+// PairRedeemHandler is called by the new device after scanning the code. The
+// device that started the pairing is asked to confirm with a pair_request
+// control message, and answers with PairConfirmHandler.
+func PairRedeemHandler(w http.ResponseWriter, r *http.Request) {
+	var req PairRedeemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.DeviceID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	pr, err := pairing.Claim(context.Background(), req.Token, req.DeviceID, req.DeviceName)
+	if errors.Is(err, pairing.ErrNotFound) || errors.Is(err, pairing.ErrInvalidState) {
+		http.Error(w, "Invalid or expired pairing token", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to redeem pairing token", http.StatusInternalServerError)
+		return
+	}
+
+	ws.SendToDevice(pr.UserID, pr.FromDevice, ws.ControlMessage{
+		Type:       ws.ControlPairRequest,
+		Token:      req.Token,
+		DeviceID:   req.DeviceID,
+		DeviceName: req.DeviceName,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": string(pr.Status)})
+}
+
+// PairConfirmHandler lets the device that started a pairing approve or
+// reject the new device once it has redeemed the token. Only signed-in
+// devices may do so, not scoped tokens or API keys.
+func PairConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	var req PairConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.DeviceID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	pr, err := pairing.Confirm(context.Background(), req.Token, user.ID.String(), req.DeviceID, req.Approve)
+	if errors.Is(err, pairing.ErrNotFound) {
+		http.Error(w, "Invalid or expired pairing token", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, pairing.ErrForbidden) {
+		http.Error(w, "Pairing was started by another device", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, pairing.ErrInvalidState) {
+		http.Error(w, "Pairing is not waiting for confirmation", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to confirm pairing", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":      string(pr.Status),
+		"device_id":   pr.NewDeviceID,
+		"device_name": pr.NewDeviceName,
+	})
+}
+
+// PairStatusHandler is polled by the new device until the pairing has been
+// confirmed, at which point it receives its own token.
+func PairStatusHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	deviceID := r.URL.Query().Get("device_id")
+	if token == "" || deviceID == "" {
+		http.Error(w, "Missing token or device_id", http.StatusBadRequest)
+		return
+	}
+
+	pr, err := pairing.Finish(context.Background(), token, deviceID)
+	if errors.Is(err, pairing.ErrNotFound) || errors.Is(err, pairing.ErrForbidden) {
+		http.Error(w, "Invalid or expired pairing token", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read pairing status", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch pr.Status {
+	case pairing.StatusRejected:
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"status": string(pr.Status)})
+		return
+	case pairing.StatusApproved:
+	default:
+		json.NewEncoder(w).Encode(map[string]string{"status": string(pr.Status)})
+		return
+	}
+
+	var user models.User
+	if err := db.DB.Where("id = ?", pr.UserID).First(&user).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	device := models.Device{UserID: user.ID, DeviceID: pr.NewDeviceID}
+	if err := db.DB.Where(models.Device{UserID: user.ID, DeviceID: pr.NewDeviceID}).
+		Assign(models.Device{Name: pr.NewDeviceName, PairedFrom: pr.FromDevice}).
+		FirstOrCreate(&device).Error; err != nil {
+		http.Error(w, "Error saving device", http.StatusInternalServerError)
+		return
+	}
+
+	jwtToken, err := utils.GenerateJWT(user.ID, user.Email, user.SessionVersion)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+	audit.Record(r, &user, &user.ID, audit.ActionDeviceRegistered, map[string]interface{}{
+		"device_id":   device.DeviceID,
+		"name":        device.Name,
+		"paired_from": device.PairedFrom,
+	})
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":    string(pr.Status),
+		"token":     jwtToken,
+		"device_id": device.DeviceID,
+	})
+}
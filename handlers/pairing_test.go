@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/models"
+	"clipsync.com/m/pairing"
+	"github.com/google/uuid"
+)
+
+func confirmPairing(user *models.User, req PairConfirmRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest("POST", "/pair/confirm", strings.NewReader(string(body)))
+	r = r.WithContext(auth.WithGrant(r.Context(), &auth.Grant{User: user, Scopes: auth.AllScopes}))
+	rec := httptest.NewRecorder()
+	PairConfirmHandler(rec, r)
+	return rec
+}
+
+func TestPairConfirm(t *testing.T) {
+	useTestRedis(t)
+	ctx := context.Background()
+	user := &models.User{ID: uuid.New()}
+	token, err := pairing.Start(ctx, user.ID.String(), "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not redeemed by a new device yet.
+	if rec := confirmPairing(user, PairConfirmRequest{Token: token, DeviceID: "laptop", Approve: true}); rec.Code != http.StatusConflict {
+		t.Errorf("unclaimed pairing: %d", rec.Code)
+	}
+	if _, err := pairing.Claim(ctx, token, "phone", "Phone"); err != nil {
+		t.Fatal(err)
+	}
+
+	other := &models.User{ID: uuid.New()}
+	if rec := confirmPairing(other, PairConfirmRequest{Token: token, DeviceID: "laptop", Approve: true}); rec.Code != http.StatusForbidden {
+		t.Errorf("another user: %d", rec.Code)
+	}
+	if rec := confirmPairing(user, PairConfirmRequest{Token: token, DeviceID: "desktop", Approve: true}); rec.Code != http.StatusForbidden {
+		t.Errorf("another device: %d", rec.Code)
+	}
+	if rec := confirmPairing(user, PairConfirmRequest{Token: "unknown", DeviceID: "laptop", Approve: true}); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown token: %d", rec.Code)
+	}
+
+	rec := confirmPairing(user, PairConfirmRequest{Token: token, DeviceID: "laptop", Approve: true})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("confirm: %d %s", rec.Code, rec.Body)
+	}
+	var resp map[string]string
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp["status"] != string(pairing.StatusApproved) || resp["device_id"] != "phone" || resp["device_name"] != "Phone" {
+		t.Errorf("response %v", resp)
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+// passkeyScript holds the browser helpers shared by the client pages for
+// talking to the /passkey endpoints. WebAuthn works on ArrayBuffers while the
+// server exchanges base64url strings, so options and responses are converted
+// in both directions.
+const passkeyScript = `
+		const b64urlToBuf = (s) => {
+			s = s.replace(/-/g, "+").replace(/_/g, "/");
+			while (s.length % 4) s += "=";
+			return Uint8Array.from(atob(s), c => c.charCodeAt(0)).buffer;
+		};
+		const bufToB64url = (buf) => btoa(String.fromCharCode(...new Uint8Array(buf)))
+			.replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+		const passkeysSupported = () => !!window.PublicKeyCredential;
+
+		async function passkeyLogin() {
+			const begin = await fetch("/passkey/login/begin", { method: "POST" });
+			if (!begin.ok) throw new Error("Could not start passkey sign-in");
+			const { session_id, options } = await begin.json();
+
+			const publicKey = options.publicKey;
+			publicKey.challenge = b64urlToBuf(publicKey.challenge);
+			(publicKey.allowCredentials || []).forEach(c => c.id = b64urlToBuf(c.id));
+
+			const cred = await navigator.credentials.get({ publicKey });
+			const res = await fetch("/passkey/login/finish?session_id=" + session_id, {
+				method: "POST",
+				headers: { "Content-Type": "application/json" },
+				body: JSON.stringify({
+					id: cred.id,
+					rawId: bufToB64url(cred.rawId),
+					type: cred.type,
+					response: {
+						clientDataJSON: bufToB64url(cred.response.clientDataJSON),
+						authenticatorData: bufToB64url(cred.response.authenticatorData),
+						signature: bufToB64url(cred.response.signature),
+						userHandle: cred.response.userHandle ? bufToB64url(cred.response.userHandle) : null
+					}
+				})
+			});
+			const data = await res.json().catch(() => ({}));
+			if (!res.ok || !data.token) throw new Error("Passkey sign-in failed");
+			return data.token;
+		}
+
+		async function passkeyRegister(token, name) {
+			const auth = { "Authorization": "Bearer " + token };
+			const begin = await fetch("/passkey/register/begin", { method: "POST", headers: auth });
+			if (!begin.ok) throw new Error("Could not start passkey registration");
+			const { session_id, options } = await begin.json();
+
+			const publicKey = options.publicKey;
+			publicKey.challenge = b64urlToBuf(publicKey.challenge);
+			publicKey.user.id = b64urlToBuf(publicKey.user.id);
+			(publicKey.excludeCredentials || []).forEach(c => c.id = b64urlToBuf(c.id));
+
+			const cred = await navigator.credentials.create({ publicKey });
+			const res = await fetch("/passkey/register/finish?session_id=" + session_id + "&name=" + encodeURIComponent(name), {
+				method: "POST",
+				headers: Object.assign({ "Content-Type": "application/json" }, auth),
+				body: JSON.stringify({
+					id: cred.id,
+					rawId: bufToB64url(cred.rawId),
+					type: cred.type,
+					response: {
+						clientDataJSON: bufToB64url(cred.response.clientDataJSON),
+						attestationObject: bufToB64url(cred.response.attestationObject),
+						transports: cred.response.getTransports ? cred.response.getTransports() : []
+					}
+				})
+			});
+			if (!res.ok) throw new Error("Passkey registration failed");
+		}
+`
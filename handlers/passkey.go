@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/utils"
+	"clipsync.com/m/webhooks"
+	"github.com/go-redis/redis/v8"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const passkeySessionTTL = 5 * time.Minute
+
+var webAuthn *webauthn.WebAuthn
+
+// InitWebAuthn configures the passkey relying party from config.
+func InitWebAuthn() {
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          config.WebAuthnRPID,
+		RPDisplayName: config.WebAuthnRPName,
+		RPOrigins:     config.WebAuthnRPOrigins,
+	})
+	if err != nil {
+		log.Fatal("Failed to configure WebAuthn: ", err)
+	}
+	webAuthn = wa
+}
+
+// passkeyUser adapts models.User to the webauthn.User interface.
+type passkeyUser struct {
+	user        *models.User
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	id := u.user.ID
+	return id[:]
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	if u.user.Name != "" {
+		return u.user.Name
+	}
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func loadPasskeyUser(user *models.User) (*passkeyUser, error) {
+	var stored []models.WebAuthnCredential
+	if err := db.DB.Where("user_id = ?", user.ID).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+
+	pu := &passkeyUser{user: user}
+	for _, s := range stored {
+		var cred webauthn.Credential
+		if err := json.Unmarshal(s.Credential, &cred); err != nil {
+			return nil, err
+		}
+		pu.credentials = append(pu.credentials, cred)
+	}
+	return pu, nil
+}
+
+// saveSession stores ceremony state in Redis and returns the id the client
+// must send back with the finish request.
+func saveSession(kind string, session *webauthn.SessionData) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	sessionID := hex.EncodeToString(b)
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	redisKey := fmt.Sprintf("webauthn_session:%s:%s", kind, sessionID)
+	if err := db.RedisClient.Set(context.Background(), redisKey, data, passkeySessionTTL).Err(); err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// takeSession loads and deletes ceremony state so it can be used only once.
+func takeSession(kind, sessionID string) (*webauthn.SessionData, error) {
+	redisKey := fmt.Sprintf("webauthn_session:%s:%s", kind, sessionID)
+	data, err := db.RedisClient.GetDel(context.Background(), redisKey).Bytes()
+	if err == redis.Nil {
+		return nil, errors.New("session not found or expired")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// PasskeyRegisterBeginHandler starts registering a passkey for the
+// authenticated user.
+func PasskeyRegisterBeginHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	pu, err := loadPasskeyUser(user)
+	if err != nil {
+		http.Error(w, "Error loading passkeys", http.StatusInternalServerError)
+		return
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(pu.credentials))
+	for _, cred := range pu.credentials {
+		exclusions = append(exclusions, cred.Descriptor())
+	}
+
+	options, session, err := webAuthn.BeginRegistration(pu,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		http.Error(w, "Error starting passkey registration", http.StatusInternalServerError)
+		return
+	}
+
+	sessionID, err := saveSession("register", session)
+	if err != nil {
+		http.Error(w, "Error saving passkey session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session_id": sessionID,
+		"options":    options,
+	})
+}
+
+// PasskeyRegisterFinishHandler verifies the authenticator's attestation and
+// stores the new credential. Expects ?session_id= and an optional ?name=.
+func PasskeyRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	session, err := takeSession("register", r.URL.Query().Get("session_id"))
+	if err != nil {
+		http.Error(w, "Invalid or expired passkey session", http.StatusBadRequest)
+		return
+	}
+
+	pu, err := loadPasskeyUser(user)
+	if err != nil {
+		http.Error(w, "Error loading passkeys", http.StatusInternalServerError)
+		return
+	}
+
+	cred, err := webAuthn.FinishRegistration(pu, *session, r)
+	if err != nil {
+		http.Error(w, "Passkey registration failed", http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.Marshal(cred)
+	if err != nil {
+		http.Error(w, "Error saving passkey", http.StatusInternalServerError)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "Passkey"
+	}
+	stored := models.WebAuthnCredential{
+		UserID:       user.ID,
+		CredentialID: cred.ID,
+		Name:         name,
+		Credential:   data,
+	}
+	if err := db.DB.Create(&stored).Error; err != nil {
+		http.Error(w, "Error saving passkey", http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(r, user, &user.ID, audit.ActionPasskeyAdded, map[string]interface{}{"id": stored.ID, "name": name})
+	sendSecurityAlert(user, webhooks.EventPasskeyAdded, fmt.Sprintf("A passkey (%s) was added", name))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Passkey registered",
+		"id":      stored.ID.String(),
+	})
+}
+
+// PasskeyLoginBeginHandler starts a discoverable (usernameless) passkey login.
+func PasskeyLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	options, session, err := webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		http.Error(w, "Error starting passkey login", http.StatusInternalServerError)
+		return
+	}
+
+	sessionID, err := saveSession("login", session)
+	if err != nil {
+		http.Error(w, "Error saving passkey session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session_id": sessionID,
+		"options":    options,
+	})
+}
+
+// PasskeyLoginFinishHandler verifies the assertion and returns a JWT for the
+// user the passkey belongs to. Expects ?session_id=.
+func PasskeyLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := takeSession("login", r.URL.Query().Get("session_id"))
+	if err != nil {
+		http.Error(w, "Invalid or expired passkey session", http.StatusBadRequest)
+		return
+	}
+
+	var pu *passkeyUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		var user models.User
+		if err := db.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+			return nil, err
+		}
+		pu, err = loadPasskeyUser(&user)
+		return pu, err
+	}
+
+	cred, err := webAuthn.FinishDiscoverableLogin(handler, *session, r)
+	if err != nil || pu == nil {
+		var userID *uuid.UUID
+		if pu != nil {
+			userID = &pu.user.ID
+		}
+		audit.Record(r, nil, userID, audit.ActionLoginFailed, map[string]interface{}{"method": "passkey", "reason": "invalid_credentials"})
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	if cred.Authenticator.CloneWarning {
+		log.Printf("Passkey sign counter went backwards for user %s, possible cloned authenticator", pu.user.ID)
+		audit.Record(r, nil, &pu.user.ID, audit.ActionLoginFailed, map[string]interface{}{"method": "passkey", "reason": "cloned_authenticator"})
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	// Persist the updated sign counter and last use.
+	data, err := json.Marshal(cred)
+	if err != nil {
+		http.Error(w, "Error updating passkey", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	if err := db.DB.Model(&models.WebAuthnCredential{}).
+		Where("user_id = ? AND credential_id = ?", pu.user.ID, cred.ID).
+		Updates(map[string]interface{}{"credential": data, "last_used_at": now}).Error; err != nil {
+		http.Error(w, "Error updating passkey", http.StatusInternalServerError)
+		return
+	}
+
+	if pu.user.LockedAt != nil {
+		audit.Record(r, nil, &pu.user.ID, audit.ActionLoginFailed, map[string]interface{}{"method": "passkey", "reason": "locked"})
+		http.Error(w, "Account is locked", http.StatusForbidden)
+		return
+	}
+
+	token, err := utils.GenerateJWT(pu.user.ID, pu.user.Email, pu.user.SessionVersion)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+	audit.Record(r, pu.user, &pu.user.ID, audit.ActionLoginSucceeded, map[string]interface{}{"method": "passkey"})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// PasskeysHandler lists (GET) or deletes (DELETE ?id=) the authenticated
+// user's passkeys.
+func PasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		var stored []models.WebAuthnCredential
+		if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&stored).Error; err != nil {
+			http.Error(w, "Error loading passkeys", http.StatusInternalServerError)
+			return
+		}
+
+		passkeys := make([]map[string]interface{}, 0, len(stored))
+		for _, s := range stored {
+			passkeys = append(passkeys, map[string]interface{}{
+				"id":           s.ID,
+				"name":         s.Name,
+				"created_at":   s.CreatedAt,
+				"last_used_at": s.LastUsedAt,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"passkeys": passkeys})
+
+	case http.MethodDelete:
+		result := db.DB.Where("id = ? AND user_id = ?", r.URL.Query().Get("id"), user.ID).
+			Delete(&models.WebAuthnCredential{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			http.Error(w, "Passkey not found", http.StatusNotFound)
+			return
+		}
+		audit.Record(r, user, &user.ID, audit.ActionPasskeyRemoved, map[string]interface{}{"id": r.URL.Query().Get("id")})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Passkey deleted"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
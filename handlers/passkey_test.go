@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+// testAuthenticator is a software passkey: a P-256 key and its credential id.
+type testAuthenticator struct {
+	id  []byte
+	key *ecdsa.PrivateKey
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &testAuthenticator{id: id, key: key}
+}
+
+// register stores the passkey for user with the given sign count, as a
+// finished registration would.
+func (a *testAuthenticator) register(t *testing.T, user *models.User, signCount uint32) {
+	t.Helper()
+	pub, err := a.key.PublicKey.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	point := pub.Bytes() // 0x04 || x || y
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: point[1:33],
+		YCoord: point[33:],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(webauthn.Credential{
+		ID:              a.id,
+		PublicKey:       publicKey,
+		AttestationType: "none",
+		Authenticator:   webauthn.Authenticator{SignCount: signCount},
+	})
+	err = db.DB.Create(&models.WebAuthnCredential{UserID: user.ID, CredentialID: a.id, Name: "Test key", Credential: data}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assert signs the challenge as userID's passkey, reporting signCount, and
+// returns the body the client would send to finish the login.
+func (a *testAuthenticator) assert(t *testing.T, challenge string, userID uuid.UUID, signCount uint32) []byte {
+	t.Helper()
+	clientData, _ := json.Marshal(map[string]interface{}{
+		"type":        "webauthn.get",
+		"challenge":   challenge,
+		"origin":      config.WebAuthnRPOrigins[0],
+		"crossOrigin": false,
+	})
+	rpIDHash := sha256.Sum256([]byte(config.WebAuthnRPID))
+	authData := append(rpIDHash[:], 0x05) // user present and verified
+	authData = binary.BigEndian.AppendUint32(authData, signCount)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(sig),
+			"userHandle":        b64(userID[:]),
+		},
+	})
+	return body
+}
+
+func setupPasskeys(t *testing.T) {
+	t.Helper()
+	useTestDB(t)
+	useTestRedis(t)
+	InitWebAuthn()
+}
+
+// beginPasskeyLogin starts a login and returns its session id and challenge.
+func beginPasskeyLogin(t *testing.T) (string, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	PasskeyLoginBeginHandler(rec, httptest.NewRequest("POST", "/passkey/login/begin", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("begin: %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		SessionID string `json:"session_id"`
+		Options   struct {
+			PublicKey struct {
+				Challenge string `json:"challenge"`
+			} `json:"publicKey"`
+		} `json:"options"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	return resp.SessionID, resp.Options.PublicKey.Challenge
+}
+
+func finishPasskeyLogin(sessionID string, body []byte) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/passkey/login/finish?session_id="+sessionID, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	PasskeyLoginFinishHandler(rec, req)
+	return rec
+}
+
+// storedSignCount returns the sign count saved for the authenticator.
+func storedSignCount(t *testing.T, a *testAuthenticator) (uint32, *time.Time) {
+	t.Helper()
+	var stored models.WebAuthnCredential
+	if err := db.DB.Where("credential_id = ?", a.id).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	var cred webauthn.Credential
+	json.Unmarshal(stored.Credential, &cred)
+	return cred.Authenticator.SignCount, stored.LastUsedAt
+}
+
+func loginFailures(reason string) int64 {
+	var n int64
+	db.DB.Model(&models.AuditEvent{}).
+		Where("action = ? AND details LIKE ?", audit.ActionLoginFailed, `%"reason":"`+reason+`"%`).Count(&n)
+	return n
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	setupPasskeys(t)
+	user := models.User{Name: "Ann", Email: "ann@example.com"}
+	db.DB.Create(&user)
+	key := newTestAuthenticator(t)
+	key.register(t, &user, 5)
+
+	sessionID, challenge := beginPasskeyLogin(t)
+	rec := finishPasskeyLogin(sessionID, key.assert(t, challenge, user.ID, 6))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", rec.Code, rec.Body)
+	}
+	if count, lastUsed := storedSignCount(t, key); count != 6 || lastUsed == nil {
+		t.Errorf("stored sign count %d, last used %v", count, lastUsed)
+	}
+
+	// The session is single use.
+	if rec := finishPasskeyLogin(sessionID, key.assert(t, challenge, user.ID, 7)); rec.Code != http.StatusBadRequest {
+		t.Errorf("reused session: %d", rec.Code)
+	}
+}
+
+func TestPasskeyLoginRejectsClonedAuthenticator(t *testing.T) {
+	setupPasskeys(t)
+	user := models.User{Name: "Ann", Email: "ann@example.com"}
+	db.DB.Create(&user)
+	key := newTestAuthenticator(t)
+	key.register(t, &user, 10)
+
+	for _, count := range []uint32{10, 3} {
+		sessionID, challenge := beginPasskeyLogin(t)
+		rec := finishPasskeyLogin(sessionID, key.assert(t, challenge, user.ID, count))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("sign count %d after 10: %d %s", count, rec.Code, rec.Body)
+		}
+	}
+	if count, lastUsed := storedSignCount(t, key); count != 10 || lastUsed != nil {
+		t.Errorf("rejected logins changed the passkey: sign count %d, last used %v", count, lastUsed)
+	}
+	if n := loginFailures("cloned_authenticator"); n != 2 {
+		t.Errorf("%d cloned authenticator failures audited, want 2", n)
+	}
+}
+
+func TestPasskeyLoginWithoutSignCounter(t *testing.T) {
+	setupPasskeys(t)
+	user := models.User{Name: "Ann", Email: "ann@example.com"}
+	db.DB.Create(&user)
+	key := newTestAuthenticator(t)
+	key.register(t, &user, 0)
+
+	// Some authenticators always report 0; that is not a clone.
+	for i := 0; i < 2; i++ {
+		sessionID, challenge := beginPasskeyLogin(t)
+		if rec := finishPasskeyLogin(sessionID, key.assert(t, challenge, user.ID, 0)); rec.Code != http.StatusOK {
+			t.Fatalf("login %d: %d %s", i+1, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestPasskeyLoginRefusesLockedAccount(t *testing.T) {
+	setupPasskeys(t)
+	now := time.Now()
+	user := models.User{Name: "Ann", Email: "ann@example.com", LockedAt: &now}
+	db.DB.Create(&user)
+	key := newTestAuthenticator(t)
+	key.register(t, &user, 1)
+
+	sessionID, challenge := beginPasskeyLogin(t)
+	rec := finishPasskeyLogin(sessionID, key.assert(t, challenge, user.ID, 2))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("locked account: %d %s", rec.Code, rec.Body)
+	}
+	if bytes.Contains(rec.Body.Bytes(), []byte("token")) {
+		t.Error("locked account got a token")
+	}
+	if n := loginFailures("locked"); n != 1 {
+		t.Errorf("%d locked failures audited, want 1", n)
+	}
+}
+
+func TestPasskeyLoginRejectsWrongKey(t *testing.T) {
+	setupPasskeys(t)
+	user := models.User{Name: "Ann", Email: "ann@example.com"}
+	db.DB.Create(&user)
+	key := newTestAuthenticator(t)
+	key.register(t, &user, 1)
+
+	// Same credential id, different private key.
+	forged := newTestAuthenticator(t)
+	forged.id = key.id
+	sessionID, challenge := beginPasskeyLogin(t)
+	if rec := finishPasskeyLogin(sessionID, forged.assert(t, challenge, user.ID, 2)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("forged signature: %d %s", rec.Code, rec.Body)
+	}
+
+	// A signature over another login's challenge.
+	_, otherChallenge := beginPasskeyLogin(t)
+	sessionID, _ = beginPasskeyLogin(t)
+	if rec := finishPasskeyLogin(sessionID, key.assert(t, otherChallenge, user.ID, 2)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong challenge: %d %s", rec.Code, rec.Body)
+	}
+	if n := loginFailures("invalid_credentials"); n != 2 {
+		t.Errorf("%d invalid credential failures audited, want 2", n)
+	}
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/mailer"
+	"clipsync.com/m/models"
+	"clipsync.com/m/utils"
+	"clipsync.com/m/webhooks"
+	"clipsync.com/m/ws"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Responses are identical whether or not an account exists for the email,
+// so these endpoints can't be used to discover registered addresses.
+const (
+	forgotPasswordMessage = "If an account exists for this email, a reset code has been sent"
+	invalidResetCode      = "Invalid or expired code"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Email        string `json:"email"`
+	OTP          string `json:"otp"`
+	NewPassword  string `json:"new_password"`
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// countResetAttempt increments a reset attempt counter and reports whether
+// the caller is still under limit. When over, it also returns how long the
+// lockout lasts.
+func countResetAttempt(ctx context.Context, key string, limit int) (bool, time.Duration, error) {
+	count, err := db.RedisClient.Incr(ctx, key).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	if count == 1 {
+		db.RedisClient.Expire(ctx, key, config.PasswordResetWindow)
+	}
+	if count > int64(limit) {
+		ttl, _ := db.RedisClient.TTL(ctx, key).Result()
+		return false, ttl, nil
+	}
+	return true, 0, nil
+}
+
+func writeTooManyAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+	http.Error(w, "Too many attempts, try again later", http.StatusTooManyRequests)
+}
+
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	email := normalizeEmail(req.Email)
+
+	ctx := context.Background()
+	ok, retryAfter, err := countResetAttempt(ctx, fmt.Sprintf("reset_attempts:ip:%s", utils.ClientIP(r)), config.PasswordResetMaxPerIP)
+	if err != nil {
+		http.Error(w, "Failed to process request", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		writeTooManyAttempts(w, retryAfter)
+		return
+	}
+
+	if err := issueResetCode(ctx, email); err != nil {
+		log.Printf("Failed to issue password reset code: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": forgotPasswordMessage,
+	})
+}
+
+// issueResetCode emails a fresh code if the account exists. Only a keyed hash
+// of the code is stored, and new codes are rate limited per email.
+func issueResetCode(ctx context.Context, email string) error {
+	var user models.User
+	if err := db.DB.Where("LOWER(email) = ?", email).First(&user).Error; err != nil {
+		return nil
+	}
+
+	spacingKey := fmt.Sprintf("reset_requested:%s", email)
+	fresh, err := db.RedisClient.SetNX(ctx, spacingKey, 1, config.PasswordResetRequestSpacing).Result()
+	if err != nil || !fresh {
+		return err
+	}
+
+	code, err := randomDigits(6)
+	if err != nil {
+		return err
+	}
+
+	redisKey := fmt.Sprintf("reset_otp:%s", email)
+	if err := db.RedisClient.Set(ctx, redisKey, utils.HashSecret(code), config.PasswordResetCodeTTL).Err(); err != nil {
+		return err
+	}
+
+	return mailer.Send(user.Email, "password_reset", map[string]string{
+		"Name":      user.Name,
+		"Code":      code,
+		"ExpiresIn": fmt.Sprintf("%d minutes", int(config.PasswordResetCodeTTL.Minutes())),
+	})
+}
+
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	email := normalizeEmail(req.Email)
+
+	ctx := context.Background()
+	redisKey := fmt.Sprintf("reset_otp:%s", email)
+
+	for _, limit := range []struct {
+		key string
+		max int
+	}{
+		{fmt.Sprintf("reset_attempts:ip:%s", utils.ClientIP(r)), config.PasswordResetMaxPerIP},
+		{fmt.Sprintf("reset_attempts:email:%s", email), config.PasswordResetMaxPerEmail},
+	} {
+		ok, retryAfter, err := countResetAttempt(ctx, limit.key, limit.max)
+		if err != nil {
+			http.Error(w, "Failed to process request", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			// Burn the outstanding code so guessing can't resume after the lockout.
+			db.RedisClient.Del(ctx, redisKey)
+			writeTooManyAttempts(w, retryAfter)
+			return
+		}
+	}
+
+	storedHash, err := db.RedisClient.Get(ctx, redisKey).Result()
+	if err == redis.Nil || subtle.ConstantTimeCompare([]byte(storedHash), []byte(utils.HashSecret(req.OTP))) != 1 {
+		http.Error(w, invalidResetCode, http.StatusUnauthorized)
+		return
+	}
+
+	var user models.User
+	if err := db.DB.Where("LOWER(email) = ?", email).First(&user).Error; err != nil {
+		http.Error(w, invalidResetCode, http.StatusUnauthorized)
+		return
+	}
+
+	if err := verifySecondFactor(&user, req.TOTPCode, req.RecoveryCode, time.Now()); err != nil {
+		writeTwoFactorRequired(w)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		return
+	}
+
+	var sessionVersion int
+	var keys []models.APIKey
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password_hash", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+		if sessionVersion, err = auth.RevokeSessions(tx, &user); err != nil {
+			return err
+		}
+		keys, err = auth.RevokeAPIKeys(tx, &user)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Error updating password", http.StatusInternalServerError)
+		return
+	}
+
+	// The code is single-use, and a successful reset clears the lockout.
+	db.RedisClient.Del(ctx, redisKey, fmt.Sprintf("reset_attempts:email:%s", email))
+	ws.RevokeSessions(user.ID.String(), sessionVersion)
+	closeAPIKeyConnections(&user, keys)
+	audit.Record(r, nil, &user.ID, audit.ActionPasswordReset, map[string]interface{}{"api_keys_revoked": len(keys)})
+	sendSecurityAlert(&user, webhooks.EventPasswordReset, "Your password was reset, all devices were signed out and API keys were revoked")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Password reset successfully",
+	})
+}
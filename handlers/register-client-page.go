@@ -46,6 +46,12 @@ func RegisterClientPage(w http.ResponseWriter, r *http.Request) {
 		button:hover {
 			background: #45a049;
 		}
+		button.secondary {
+			margin-top: 10px;
+			background: white;
+			color: #4CAF50;
+			border: 1px solid #4CAF50;
+		}
 		#status {
 			margin-top: 10px;
 			color: #d00;
@@ -63,10 +69,20 @@ func RegisterClientPage(w http.ResponseWriter, r *http.Request) {
 			<input type="password" id="confirmPassword" placeholder="Confirm Password" required>
 			<button type="submit">Register</button>
 		</form>
+		<div id="passkeyOffer" style="display: none">
+			<p>Account created. Sign in faster next time with a passkey on this device.</p>
+			<button id="createPasskey">Create a passkey</button>
+			<button id="skipPasskey" class="secondary">Not now</button>
+		</div>
 		<div id="status"></div>
 	</div>
-	<script>
+	<script>` + passkeyScript + `
 		const form = document.getElementById("registerForm");
+		const goToLogin = () => {
+			const params = new URLSearchParams(window.location.search);
+			const redirect = params.get("redirect_uri") || "";
+			window.location.href = "/login-client" + (redirect ? "?redirect_uri=" + encodeURIComponent(redirect) : "");
+		};
 		form.onsubmit = async (e) => {
 			e.preventDefault();
 
@@ -88,9 +104,21 @@ func RegisterClientPage(w http.ResponseWriter, r *http.Request) {
 
 			const data = await res.json();
 			if (res.ok && data.token) {
-				const params = new URLSearchParams(window.location.search);
-				const redirect = params.get("redirect_uri") || "";
-				window.location.href = "/login-client" + (redirect ? "?redirect_uri=" + encodeURIComponent(redirect) : "");
+				if (!passkeysSupported()) {
+					goToLogin();
+					return;
+				}
+				form.style.display = "none";
+				document.getElementById("passkeyOffer").style.display = "block";
+				document.getElementById("skipPasskey").onclick = goToLogin;
+				document.getElementById("createPasskey").onclick = async () => {
+					try {
+						await passkeyRegister(data.token, navigator.platform || "Passkey");
+						goToLogin();
+					} catch (err) {
+						document.getElementById("status").textContent = err.message;
+					}
+				};
 			} else {
 				document.getElementById("status").textContent = data.message || "Registration failed.";
 			}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/clips"
+	"github.com/google/uuid"
+)
+
+// SearchClipsHandler searches the text clips in the user's history, best
+// matches first. ?q= is the query, in web search syntax; ?device_id=,
+// ?type= (a media type), ?tag= (repeatable), ?from= and ?to= (RFC 3339)
+// narrow it down, and ?limit= and ?offset= page through the results. The
+// server can't read end-to-end encrypted clips, so those accounts are
+// refused.
+func SearchClipsHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if user.EncryptionEnabled {
+		http.Error(w, "Search is not available with end-to-end encryption: the server cannot read your clips. Search on your devices instead.", http.StatusConflict)
+		return
+	}
+
+	q := r.URL.Query()
+	search := clips.SearchQuery{
+		UserID:      user.ID,
+		Text:        strings.TrimSpace(q.Get("q")),
+		DeviceID:    q.Get("device_id"),
+		ContentType: strings.TrimSpace(q.Get("type")),
+	}
+	if search.Text == "" {
+		http.Error(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+	var ok bool
+	if search.Tags, ok = normalizeTags(w, q["tag"]); !ok {
+		return
+	}
+	search.Limit, search.Offset, ok = listLimit(r)
+	if !ok {
+		http.Error(w, "Invalid limit or offset", http.StatusBadRequest)
+		return
+	}
+	for _, param := range []string{"from", "to"} {
+		s := q.Get(param)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "Invalid "+param+", expected an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		if param == "from" {
+			search.From = &t
+		} else {
+			search.To = &t
+		}
+	}
+
+	results, err := clips.Search(search)
+	if err != nil {
+		log.Printf("Failed to search clips of user %s: %v", user.ID, err)
+		http.Error(w, "Error searching clips", http.StatusInternalServerError)
+		return
+	}
+	ids := make([]uuid.UUID, 0, len(results))
+	for _, res := range results {
+		ids = append(ids, res.ID)
+	}
+	byClip, err := clips.Tags(ids)
+	if err != nil {
+		http.Error(w, "Error searching clips", http.StatusInternalServerError)
+		return
+	}
+	list := make([]map[string]interface{}, 0, len(results))
+	for i := range results {
+		resp := clipMetadataResponse(&results[i].Clip, byClip[results[i].ID])
+		resp["rank"] = results[i].Rank
+		resp["highlight"] = results[i].Highlight
+		list = append(list, resp)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
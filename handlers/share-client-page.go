@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipsync.com/m/clips"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Share link access outcomes, as recorded in the access log.
+const (
+	shareViewed        = "viewed"
+	shareWrongPassword = "wrong_password"
+	shareExpired       = "expired"
+	shareRevoked       = "revoked"
+	shareExhausted     = "view_limit_reached"
+	shareClipDeleted   = "clip_deleted"
+)
+
+// shareImageTypes are shown inline on the share page. SVG is left out as it
+// can carry scripts.
+var shareImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+var shareGoneMessages = map[string]string{
+	shareExpired:     "This link has expired.",
+	shareRevoked:     "This link was revoked.",
+	shareExhausted:   "This link has reached its view limit.",
+	shareClipDeleted: "The shared clip was deleted.",
+}
+
+func logShareAccess(r *http.Request, link *models.ShareLink, outcome string) {
+	ua := r.UserAgent()
+	if len(ua) > 255 {
+		ua = ua[:255]
+	}
+	access := models.ShareLinkAccess{
+		ShareLinkID: link.ID,
+		Outcome:     outcome,
+		IP:          utils.ClientIP(r),
+		UserAgent:   ua,
+	}
+	if err := db.DB.Create(&access).Error; err != nil {
+		log.Printf("Failed to log access to share link %s: %v", link.ID, err)
+	}
+}
+
+// openShare resolves ?token=, checks that the link can still be opened and
+// that password matches, and logs failed attempts. On failure it returns the
+// status and message to show; a 401 with an empty message means a password
+// is needed.
+func openShare(r *http.Request, password string) (*models.ShareLink, *models.Clip, int, string) {
+	notFound := "This link does not exist."
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return nil, nil, http.StatusNotFound, notFound
+	}
+	var link models.ShareLink
+	if err := db.DB.Where("token_hash = ?", utils.HashSecret(token)).First(&link).Error; err != nil {
+		return nil, nil, http.StatusNotFound, notFound
+	}
+
+	if status := shareStatus(&link, time.Now()); status != "active" {
+		logShareAccess(r, &link, status)
+		return nil, nil, http.StatusGone, shareGoneMessages[status]
+	}
+	var clip models.Clip
+	if err := db.DB.Where("id = ? AND user_id = ?", link.ClipID, link.UserID).First(&clip).Error; err != nil {
+		logShareAccess(r, &link, shareClipDeleted)
+		return nil, nil, http.StatusGone, shareGoneMessages[shareClipDeleted]
+	}
+
+	if link.PasswordHash != "" {
+		if password == "" {
+			return &link, &clip, http.StatusUnauthorized, ""
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			logShareAccess(r, &link, shareWrongPassword)
+			return &link, &clip, http.StatusUnauthorized, "Wrong password."
+		}
+	}
+
+	return &link, &clip, http.StatusOK, ""
+}
+
+// countShareView uses up one view of link and logs it. It fails if the link
+// was revoked or ran out of views since openShare checked it.
+func countShareView(r *http.Request, link *models.ShareLink) (int, string) {
+	// Conditional, so concurrent requests can't exceed the view limit.
+	result := db.DB.Model(&models.ShareLink{}).
+		Where("id = ? AND revoked_at IS NULL AND (max_views = 0 OR views < max_views)", link.ID).
+		Updates(map[string]interface{}{
+			"views":          gorm.Expr("views + 1"),
+			"last_viewed_at": time.Now(),
+		})
+	if result.Error != nil {
+		return http.StatusInternalServerError, "Something went wrong, try again later."
+	}
+	if result.RowsAffected == 0 {
+		logShareAccess(r, link, shareExhausted)
+		return http.StatusGone, shareGoneMessages[shareExhausted]
+	}
+	logShareAccess(r, link, shareViewed)
+	return http.StatusOK, ""
+}
+
+func setShareHeaders(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+type sharePageData struct {
+	Message      string
+	NeedPassword bool
+	Password     string
+	RawURL       string
+	ContentType  string
+	Size         int
+	ExpiresAt    string
+	Text         string
+	Image        template.URL
+	Shown        bool
+}
+
+var sharePageTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="robots" content="noindex">
+	<title>Shared clip - ClipSync</title>
+	<style>
+		body {
+			font-family: Arial, sans-serif;
+			background: #f0f2f5;
+			display: flex;
+			justify-content: center;
+			margin: 0;
+			padding: 2rem 0;
+		}
+		.container {
+			background: white;
+			padding: 2rem;
+			border-radius: 12px;
+			box-shadow: 0 0 10px rgba(0,0,0,0.1);
+			width: 640px;
+			max-width: 90%;
+		}
+		.meta {
+			color: #666;
+			font-size: 14px;
+		}
+		pre {
+			background: #f7f7f7;
+			padding: 1rem;
+			border-radius: 8px;
+			white-space: pre-wrap;
+			word-break: break-word;
+		}
+		img {
+			max-width: 100%;
+		}
+		input {
+			width: 100%;
+			padding: 10px;
+			margin: 10px 0;
+			border: 1px solid #ccc;
+			border-radius: 8px;
+			box-sizing: border-box;
+		}
+		button {
+			width: 100%;
+			padding: 10px;
+			background: #4CAF50;
+			color: white;
+			border: none;
+			border-radius: 8px;
+			cursor: pointer;
+			font-size: 16px;
+		}
+		button:hover {
+			background: #45a049;
+		}
+		#status {
+			margin-top: 10px;
+			color: #d00;
+			font-size: 14px;
+		}
+	</style>
+</head>
+<body>
+	<div class="container">
+		<h2>Shared clip</h2>
+		{{if .NeedPassword}}
+		<form method="post">
+			<input type="password" name="password" placeholder="Password" required autofocus>
+			<button type="submit">Open</button>
+		</form>
+		{{else if .Shown}}
+		<p class="meta">{{.ContentType}}, {{.Size}} bytes. Link expires {{.ExpiresAt}}.</p>
+		{{if .Image}}<img src="{{.Image}}" alt="Shared image">{{else if .Text}}<pre>{{.Text}}</pre>{{end}}
+		<form method="post" action="{{.RawURL}}">
+			{{if .Password}}<input type="hidden" name="password" value="{{.Password}}">{{end}}
+			<button type="submit">Download</button>
+		</form>
+		{{end}}
+		<div id="status">{{.Message}}</div>
+	</div>
+</body>
+</html>`))
+
+// SharePage shows a shared clip read-only: text and common image types
+// inline, anything else as a download. Password-protected links ask for the
+// password first, which is posted back to the same URL. Each view of the
+// content counts towards the link's view limit.
+func SharePage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	setShareHeaders(w)
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src data:; form-action 'self'")
+	w.Header().Set("Content-Type", "text/html")
+
+	password := r.PostFormValue("password")
+	link, clip, status, message := openShare(r, password)
+	data := sharePageData{Message: message}
+	if status == http.StatusUnauthorized {
+		data.NeedPassword = true
+		if message == "" {
+			// Just the password form, not a failed attempt.
+			status = http.StatusOK
+		}
+	}
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		sharePageTemplate.Execute(w, data)
+		return
+	}
+
+	mediaType, _, _ := strings.Cut(clip.ContentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	inline := strings.HasPrefix(mediaType, "text/") || shareImageTypes[mediaType]
+	if inline {
+		// Only showing the content uses up a view; downloads count on their own.
+		if status, message = countShareView(r, link); status != http.StatusOK {
+			w.WriteHeader(status)
+			sharePageTemplate.Execute(w, sharePageData{Message: message})
+			return
+		}
+	}
+
+	data = sharePageData{
+		Shown:       true,
+		Password:    password,
+		RawURL:      "/share/raw?token=" + r.URL.Query().Get("token"),
+		ContentType: clip.ContentType,
+		Size:        clip.Size,
+		ExpiresAt:   link.ExpiresAt.UTC().Format("2006-01-02 15:04 UTC"),
+	}
+	if inline {
+		content, err := clips.Content(clip)
+		if err != nil {
+			log.Printf("Failed to read shared clip %s: %v", clip.ID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			sharePageTemplate.Execute(w, sharePageData{Message: "Something went wrong, try again later."})
+			return
+		}
+		if shareImageTypes[mediaType] {
+			data.Image = template.URL("data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(content))
+		} else {
+			data.Text = string(content)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	sharePageTemplate.Execute(w, data)
+}
+
+// ShareRawHandler returns a shared clip's content with its content type. A
+// password, if the link has one, is sent as the "password" form field or the
+// X-Share-Password header. Each download counts as a view.
+func ShareRawHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	setShareHeaders(w)
+
+	password := r.Header.Get("X-Share-Password")
+	if password == "" {
+		password = r.PostFormValue("password")
+	}
+	link, clip, status, message := openShare(r, password)
+	if status == http.StatusUnauthorized && message == "" {
+		message = "This link needs a password."
+	}
+	if status == http.StatusOK {
+		status, message = countShareView(r, link)
+	}
+	if status != http.StatusOK {
+		http.Error(w, message, status)
+		return
+	}
+
+	content, err := clips.Content(clip)
+	if err != nil {
+		log.Printf("Failed to read shared clip %s: %v", clip.ID, err)
+		http.Error(w, "Error loading clip", http.StatusInternalServerError)
+		return
+	}
+	// Served from our origin, so never let the browser run it.
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("Content-Type", clip.ContentType)
+	w.Header().Set("Content-Disposition", "attachment")
+	w.Write(content)
+}
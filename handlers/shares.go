@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultShareAccessLimit = 50
+	maxShareAccessLimit     = 200
+)
+
+type CreateShareRequest struct {
+	ClipID           string `json:"clip_id"`
+	ExpiresInSeconds int    `json:"expires_in_seconds"` // 0 = config.ShareLinkDefaultTTL
+	MaxViews         int    `json:"max_views"`          // 0 = unlimited
+	Password         string `json:"password"`           // optional
+}
+
+func generateShareToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func shareURL(path, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", baseURL(), path, url.QueryEscape(token))
+}
+
+// shareStatus is why a link can no longer be opened, or "active".
+func shareStatus(link *models.ShareLink, now time.Time) string {
+	switch {
+	case link.RevokedAt != nil:
+		return shareRevoked
+	case !now.Before(link.ExpiresAt):
+		return shareExpired
+	case link.MaxViews > 0 && link.Views >= link.MaxViews:
+		return shareExhausted
+	}
+	return "active"
+}
+
+func shareResponse(link *models.ShareLink) map[string]interface{} {
+	return map[string]interface{}{
+		"id":             link.ID,
+		"clip_id":        link.ClipID,
+		"prefix":         link.Prefix,
+		"status":         shareStatus(link, time.Now()),
+		"has_password":   link.PasswordHash != "",
+		"expires_at":     link.ExpiresAt,
+		"max_views":      link.MaxViews,
+		"views":          link.Views,
+		"last_viewed_at": link.LastViewedAt,
+		"revoked_at":     link.RevokedAt,
+		"created_at":     link.CreatedAt,
+	}
+}
+
+// activeShareLinks scopes a query to the user's links that can still be
+// opened.
+func activeShareLinks(user *models.User) *gorm.DB {
+	return db.DB.Model(&models.ShareLink{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Where("max_views = 0 OR views < max_views")
+}
+
+// SharesHandler lists (GET, optionally ?clip_id=), creates (POST) and revokes
+// (DELETE ?id=) public links to the user's clips. The link URL is only
+// returned on creation. Revoked links are kept along with their access log.
+func SharesHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		query := db.DB.Where("user_id = ?", user.ID)
+		if clipID := r.URL.Query().Get("clip_id"); clipID != "" {
+			query = query.Where("clip_id = ?", clipID)
+		}
+		var links []models.ShareLink
+		if err := query.Order("created_at DESC").Find(&links).Error; err != nil {
+			http.Error(w, "Error loading share links", http.StatusInternalServerError)
+			return
+		}
+		list := make([]map[string]interface{}, 0, len(links))
+		for i := range links {
+			list = append(list, shareResponse(&links[i]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		if !requireVerifiedEmail(w, user, config.RequireVerifiedEmailForSharing) {
+			return
+		}
+		var req CreateShareRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		ttl := config.ShareLinkDefaultTTL
+		if req.ExpiresInSeconds != 0 {
+			ttl = time.Duration(req.ExpiresInSeconds) * time.Second
+		}
+		if ttl <= 0 || ttl > config.ShareLinkMaxTTL {
+			http.Error(w, fmt.Sprintf("expires_in_seconds must be between 1 and %d", int(config.ShareLinkMaxTTL.Seconds())), http.StatusBadRequest)
+			return
+		}
+		if req.MaxViews < 0 {
+			http.Error(w, "max_views cannot be negative", http.StatusBadRequest)
+			return
+		}
+
+		var clip models.Clip
+		if err := db.DB.Omit("content").Where("id = ? AND user_id = ?", req.ClipID, user.ID).First(&clip).Error; err != nil {
+			http.Error(w, "Clip not found", http.StatusNotFound)
+			return
+		}
+		if clip.Encrypted {
+			http.Error(w, "End-to-end encrypted clips cannot be shared publicly", http.StatusConflict)
+			return
+		}
+
+		var count int64
+		if err := activeShareLinks(user).Count(&count).Error; err != nil {
+			http.Error(w, "Error creating share link", http.StatusInternalServerError)
+			return
+		}
+		if count >= int64(config.ShareLinksMaxPerUser) {
+			http.Error(w, "Too many active share links, revoke some first", http.StatusConflict)
+			return
+		}
+
+		token, err := generateShareToken()
+		if err != nil {
+			http.Error(w, "Error creating share link", http.StatusInternalServerError)
+			return
+		}
+		link := models.ShareLink{
+			UserID:    user.ID,
+			ClipID:    clip.ID,
+			Prefix:    token[:8],
+			TokenHash: utils.HashSecret(token),
+			ExpiresAt: time.Now().Add(ttl),
+			MaxViews:  req.MaxViews,
+		}
+		if req.Password != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+			if err != nil {
+				http.Error(w, "Error creating share link", http.StatusInternalServerError)
+				return
+			}
+			link.PasswordHash = string(hash)
+		}
+		if err := db.DB.Create(&link).Error; err != nil {
+			http.Error(w, "Error creating share link", http.StatusInternalServerError)
+			return
+		}
+
+		resp := shareResponse(&link)
+		resp["url"] = shareURL("/share", token)
+		resp["raw_url"] = shareURL("/share/raw", token)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodDelete:
+		var link models.ShareLink
+		if err := db.DB.Where("id = ? AND user_id = ?", r.URL.Query().Get("id"), user.ID).First(&link).Error; err != nil {
+			http.Error(w, "Share link not found", http.StatusNotFound)
+			return
+		}
+		if link.RevokedAt == nil {
+			if err := db.DB.Model(&link).Update("revoked_at", time.Now()).Error; err != nil {
+				http.Error(w, "Error revoking share link", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Share link revoked"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ShareAccessLogHandler returns the access log of the share link ?id=,
+// newest first.
+func ShareAccessLogHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	limit := defaultShareAccessLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxShareAccessLimit)
+	}
+
+	var link models.ShareLink
+	if err := db.DB.Where("id = ? AND user_id = ?", q.Get("id"), user.ID).First(&link).Error; err != nil {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	var list []models.ShareLinkAccess
+	if err := db.DB.Where("share_link_id = ?", link.ID).Order("created_at DESC").Limit(limit).Find(&list).Error; err != nil {
+		http.Error(w, "Error loading access log", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]map[string]interface{}, 0, len(list))
+	for _, a := range list {
+		resp = append(resp, map[string]interface{}{
+			"outcome":    a.Outcome,
+			"ip":         a.IP,
+			"user_agent": a.UserAgent,
+			"created_at": a.CreatedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/clips"
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/orgs"
+	"clipsync.com/m/spaces"
+	"clipsync.com/m/ws"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const maxSpaceNameLength = 100
+
+type SpaceRequest struct {
+	Name string `json:"name"`
+}
+
+type SpaceMemberRequest struct {
+	SpaceID string `json:"space_id"`
+	Email   string `json:"email"`   // to add a member
+	UserID  string `json:"user_id"` // to change a member's role
+	Role    string `json:"role"`
+}
+
+func spaceResponse(space *models.Space, role string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         space.ID,
+		"name":       space.Name,
+		"role":       role,
+		"created_at": space.CreatedAt,
+	}
+}
+
+// loadMembership returns the space and the caller's membership of it,
+// writing a 404 if the caller is not a member.
+func loadMembership(w http.ResponseWriter, user *models.User, spaceID string) (*models.Space, *models.SpaceMember, bool) {
+	member, err := spaces.Membership(spaceID, user.ID.String())
+	if errors.Is(err, spaces.ErrNotMember) {
+		http.Error(w, "Space not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		http.Error(w, "Error loading space", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	var space models.Space
+	if err := db.DB.Where("id = ?", member.SpaceID).First(&space).Error; err != nil {
+		http.Error(w, "Space not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	return &space, member, true
+}
+
+func validSpaceName(w http.ResponseWriter, name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxSpaceNameLength {
+		http.Error(w, "Name is required and must be at most 100 characters", http.StatusBadRequest)
+		return "", false
+	}
+	return name, true
+}
+
+// SpacesHandler lists the spaces the user belongs to (GET), creates one with
+// the user as owner (POST), and renames (PATCH ?id=) or deletes (DELETE ?id=)
+// a space the user owns. Deleting a space removes its clip history and
+// disconnects its members.
+func SpacesHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		var memberships []models.SpaceMember
+		if err := db.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&memberships).Error; err != nil {
+			http.Error(w, "Error loading spaces", http.StatusInternalServerError)
+			return
+		}
+		roles := make(map[uuid.UUID]string, len(memberships))
+		ids := make([]uuid.UUID, 0, len(memberships))
+		for _, m := range memberships {
+			roles[m.SpaceID] = m.Role
+			ids = append(ids, m.SpaceID)
+		}
+		var list []models.Space
+		if err := db.DB.Where("id IN ?", ids).Order("name").Find(&list).Error; err != nil {
+			http.Error(w, "Error loading spaces", http.StatusInternalServerError)
+			return
+		}
+
+		resp := make([]map[string]interface{}, 0, len(list))
+		for i := range list {
+			resp = append(resp, spaceResponse(&list[i], roles[list[i].ID]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodPost:
+		if !requireVerifiedEmail(w, user, config.RequireVerifiedEmailForSharing) {
+			return
+		}
+		var req SpaceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		name, ok := validSpaceName(w, req.Name)
+		if !ok {
+			return
+		}
+
+		var count int64
+		if err := db.DB.Model(&models.SpaceMember{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+			http.Error(w, "Error creating space", http.StatusInternalServerError)
+			return
+		}
+		if count >= int64(config.SpacesMaxPerUser) {
+			http.Error(w, "Too many spaces, leave some first", http.StatusConflict)
+			return
+		}
+
+		space := models.Space{Name: name, CreatedBy: user.ID, OrgID: user.OrgID}
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&space).Error; err != nil {
+				return err
+			}
+			return tx.Create(&models.SpaceMember{SpaceID: space.ID, UserID: user.ID, Role: spaces.RoleOwner}).Error
+		})
+		if err != nil {
+			http.Error(w, "Error creating space", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(spaceResponse(&space, spaces.RoleOwner))
+
+	case http.MethodPatch:
+		space, member, ok := loadMembership(w, user, r.URL.Query().Get("id"))
+		if !ok {
+			return
+		}
+		if !spaces.CanManage(member.Role) {
+			http.Error(w, "Only owners can rename the space", http.StatusForbidden)
+			return
+		}
+		var req SpaceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		name, ok := validSpaceName(w, req.Name)
+		if !ok {
+			return
+		}
+		if err := db.DB.Model(space).Update("name", name).Error; err != nil {
+			http.Error(w, "Error renaming space", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(spaceResponse(space, member.Role))
+
+	case http.MethodDelete:
+		space, member, ok := loadMembership(w, user, r.URL.Query().Get("id"))
+		if !ok {
+			return
+		}
+		if !spaces.CanManage(member.Role) {
+			http.Error(w, "Only owners can delete the space", http.StatusForbidden)
+			return
+		}
+
+		var blobClips []models.Clip
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			blobClips, err = spaces.Delete(tx, space.ID)
+			return err
+		})
+		if err != nil {
+			http.Error(w, "Error deleting space", http.StatusInternalServerError)
+			return
+		}
+		deleteClipBlobs(blobClips)
+		ws.DeleteSpace(space.ID.String())
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Space deleted"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func deleteClipBlobs(list []models.Clip) {
+	for i := range list {
+		if err := clips.DeleteBlob(&list[i]); err != nil {
+			log.Printf("Failed to delete blob of clip %s: %v", list[i].ID, err)
+		}
+	}
+}
+
+// SpaceMembersHandler lists a space's members (GET ?space_id=) for any
+// member. Owners add members by email (POST), change roles (PATCH) and remove
+// members (DELETE ?space_id=&user_id=); any member can remove themselves to
+// leave. A space always keeps at least one owner.
+func SpaceMembersHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		space, _, ok := loadMembership(w, user, r.URL.Query().Get("space_id"))
+		if !ok {
+			return
+		}
+		var members []models.SpaceMember
+		if err := db.DB.Where("space_id = ?", space.ID).Order("created_at").Find(&members).Error; err != nil {
+			http.Error(w, "Error loading members", http.StatusInternalServerError)
+			return
+		}
+		userIDs := make([]uuid.UUID, 0, len(members))
+		for _, m := range members {
+			userIDs = append(userIDs, m.UserID)
+		}
+		var users []models.User
+		if err := db.DB.Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+			http.Error(w, "Error loading members", http.StatusInternalServerError)
+			return
+		}
+		byID := make(map[uuid.UUID]*models.User, len(users))
+		for i := range users {
+			byID[users[i].ID] = &users[i]
+		}
+
+		resp := make([]map[string]interface{}, 0, len(members))
+		for _, m := range members {
+			entry := map[string]interface{}{
+				"user_id":   m.UserID,
+				"role":      m.Role,
+				"joined_at": m.CreatedAt,
+			}
+			if u := byID[m.UserID]; u != nil {
+				entry["name"] = u.Name
+				entry["email"] = u.Email
+			}
+			resp = append(resp, entry)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodPost:
+		if !requireVerifiedEmail(w, user, config.RequireVerifiedEmailForSharing) {
+			return
+		}
+		var req SpaceMemberRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		space, member, ok := loadMembership(w, user, req.SpaceID)
+		if !ok {
+			return
+		}
+		if !spaces.CanManage(member.Role) {
+			http.Error(w, "Only owners can add members", http.StatusForbidden)
+			return
+		}
+		if req.Role == "" {
+			req.Role = spaces.RoleEditor
+		}
+		if !spaces.ValidRole(req.Role) {
+			http.Error(w, "Role must be owner, editor or viewer", http.StatusBadRequest)
+			return
+		}
+
+		var invitee models.User
+		err := db.DB.Where("LOWER(email) = ?", normalizeEmail(req.Email)).First(&invitee).Error
+		// Spaces never cross organizations; users elsewhere look like strangers.
+		if err != nil || !orgs.SameID(space.OrgID, invitee.OrgID) {
+			http.Error(w, "No account with that email", http.StatusNotFound)
+			return
+		}
+		if _, err := spaces.Membership(space.ID.String(), invitee.ID.String()); err == nil {
+			http.Error(w, "Already a member", http.StatusConflict)
+			return
+		}
+
+		var members, memberships int64
+		db.DB.Model(&models.SpaceMember{}).Where("space_id = ?", space.ID).Count(&members)
+		db.DB.Model(&models.SpaceMember{}).Where("user_id = ?", invitee.ID).Count(&memberships)
+		if members >= int64(config.SpaceMaxMembers) {
+			http.Error(w, "The space is full", http.StatusConflict)
+			return
+		}
+		if memberships >= int64(config.SpacesMaxPerUser) {
+			http.Error(w, "That user is in too many spaces", http.StatusConflict)
+			return
+		}
+
+		added := models.SpaceMember{SpaceID: space.ID, UserID: invitee.ID, Role: req.Role}
+		if err := db.DB.Create(&added).Error; err != nil {
+			http.Error(w, "Error adding member", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"user_id":   invitee.ID,
+			"name":      invitee.Name,
+			"email":     invitee.Email,
+			"role":      added.Role,
+			"joined_at": added.CreatedAt,
+		})
+
+	case http.MethodPatch:
+		var req SpaceMemberRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		space, member, ok := loadMembership(w, user, req.SpaceID)
+		if !ok {
+			return
+		}
+		if !spaces.CanManage(member.Role) {
+			http.Error(w, "Only owners can change roles", http.StatusForbidden)
+			return
+		}
+		if !spaces.ValidRole(req.Role) {
+			http.Error(w, "Role must be owner, editor or viewer", http.StatusBadRequest)
+			return
+		}
+		target, err := spaces.Membership(space.ID.String(), req.UserID)
+		if err != nil {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		if target.Role == spaces.RoleOwner && req.Role != spaces.RoleOwner && lastOwner(space.ID) {
+			http.Error(w, "A space needs at least one owner", http.StatusConflict)
+			return
+		}
+		// Takes effect on the member's next send; viewers keep receiving.
+		if err := db.DB.Model(target).Update("role", req.Role).Error; err != nil {
+			http.Error(w, "Error changing role", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"user_id": target.UserID, "role": req.Role})
+
+	case http.MethodDelete:
+		space, member, ok := loadMembership(w, user, r.URL.Query().Get("space_id"))
+		if !ok {
+			return
+		}
+		targetID := r.URL.Query().Get("user_id")
+		if targetID == "" {
+			targetID = user.ID.String()
+		}
+		if targetID != user.ID.String() && !spaces.CanManage(member.Role) {
+			http.Error(w, "Only owners can remove other members", http.StatusForbidden)
+			return
+		}
+		target, err := spaces.Membership(space.ID.String(), targetID)
+		if err != nil {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		if target.Role == spaces.RoleOwner && lastOwner(space.ID) {
+			http.Error(w, "A space needs at least one owner; make someone else owner or delete the space", http.StatusConflict)
+			return
+		}
+		if err := db.DB.Delete(target).Error; err != nil {
+			http.Error(w, "Error removing member", http.StatusInternalServerError)
+			return
+		}
+		ws.RemoveSpaceMember(space.ID.String(), target.UserID.String())
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Member removed"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func lastOwner(spaceID uuid.UUID) bool {
+	var owners int64
+	db.DB.Model(&models.SpaceMember{}).Where("space_id = ? AND role = ?", spaceID, spaces.RoleOwner).Count(&owners)
+	return owners <= 1
+}
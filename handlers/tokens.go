@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
+	"clipsync.com/m/utils"
+)
+
+type CreateTokenRequest struct {
+	Scopes           []string `json:"scopes"`
+	ExpiresInSeconds int      `json:"expires_in_seconds"` // 0 = ScopedTokenDefaultTTL
+}
+
+// CreateTokenHandler mints a short-lived JWT limited to the requested scopes,
+// such as a send-only token for a CI job or a read-only one for a dashboard.
+// Like any session token it stops working when the user's sessions are
+// revoked.
+func CreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			http.Error(w, fmt.Sprintf("Unknown scope %q", scope), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ttl := config.ScopedTokenDefaultTTL
+	if req.ExpiresInSeconds > 0 {
+		ttl = time.Duration(req.ExpiresInSeconds) * time.Second
+	}
+	if req.ExpiresInSeconds < 0 || ttl > config.ScopedTokenMaxTTL {
+		http.Error(w, fmt.Sprintf("expires_in_seconds must be between 1 and %d", int(config.ScopedTokenMaxTTL.Seconds())), http.StatusBadRequest)
+		return
+	}
+
+	token, err := utils.GenerateScopedJWT(user.ID, user.Email, user.SessionVersion, req.Scopes, ttl)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	audit.Record(r, user, &user.ID, audit.ActionTokenIssued, map[string]interface{}{
+		"scopes":     req.Scopes,
+		"expires_at": expiresAt,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"scopes":     req.Scopes,
+		"expires_at": expiresAt,
+	})
+}
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipsync.com/m/audit"
+	"clipsync.com/m/auth"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/utils"
+	"clipsync.com/m/webhooks"
+	"github.com/google/uuid"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+const recoveryCodeCount = 10
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorSetupRequest struct {
+	Password string `json:"password"`
+}
+
+type TwoFactorEnableRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type TwoFactorDisableRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+var ErrTwoFactorRequired = errors.New("two-factor code required")
+
+// writeTwoFactorRequired tells the client to retry with a TOTP or recovery code.
+func writeTwoFactorRequired(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":             "Two-factor code required",
+		"two_factor_required": true,
+	})
+}
+
+// hashRecoveryCode returns the keyed hash stored for one of the user's
+// recovery codes. The user id salts it, so equal codes of two users differ.
+func hashRecoveryCode(userID uuid.UUID, code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return utils.HashSecret(userID.String() + ":" + code)
+}
+
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := hex.EncodeToString(b)
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+	return codes, nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, user *models.User, codes []string) error {
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	for _, code := range codes {
+		rc := models.RecoveryCode{UserID: user.ID, CodeHash: hashRecoveryCode(user.ID, code)}
+		if err := tx.Create(&rc).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifySecondFactor checks a TOTP code (rejecting replays within its validity
+// window) or consumes a recovery code. Users without 2FA always pass.
+func verifySecondFactor(user *models.User, code, recoveryCode string, now time.Time) error {
+	if !user.TOTPEnabled {
+		return nil
+	}
+
+	if code != "" {
+		step, ok := utils.ValidateTOTP(user.TOTPSecret, code, now)
+		if !ok {
+			return ErrTwoFactorRequired
+		}
+		ctx := context.Background()
+		redisKey := fmt.Sprintf("totp_used:%s:%d", user.ID, step)
+		fresh, err := db.RedisClient.SetNX(ctx, redisKey, 1, 2*time.Minute).Result()
+		if err != nil || !fresh {
+			return ErrTwoFactorRequired
+		}
+		return nil
+	}
+
+	if recoveryCode != "" {
+		result := db.DB.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashRecoveryCode(user.ID, recoveryCode)).
+			Update("used_at", now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return ErrTwoFactorRequired
+		}
+		return nil
+	}
+
+	return ErrTwoFactorRequired
+}
+
+// TwoFactorSetupHandler generates a new TOTP secret for the authenticated user
+// after re-checking the password. 2FA is not enforced until the secret is
+// confirmed via TwoFactorEnableHandler.
+func TwoFactorSetupHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	var req TwoFactorSetupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if !confirmPassword(w, r, user, req.Password) {
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Error generating secret", http.StatusInternalServerError)
+		return
+	}
+	user.TOTPSecret = secret
+	if err := db.DB.Save(user).Error; err != nil {
+		http.Error(w, "Error saving secret", http.StatusInternalServerError)
+		return
+	}
+
+	uri := utils.TOTPProvisioningURI(secret, user.Email)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":           secret,
+		"provisioning_uri": uri,
+		"qr_code":          "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// TwoFactorEnableHandler re-checks the password, confirms the pending secret
+// with a code from the authenticator app and returns a fresh set of recovery
+// codes.
+func TwoFactorEnableHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	var req TwoFactorEnableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		http.Error(w, "Two-factor setup has not been started", http.StatusConflict)
+		return
+	}
+	if !confirmPassword(w, r, user, req.Password) {
+		return
+	}
+	if _, ok := utils.ValidateTOTP(user.TOTPSecret, req.Code, time.Now()); !ok {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := replaceRecoveryCodes(tx, user, codes); err != nil {
+			return err
+		}
+		return tx.Model(user).Update("totp_enabled", true).Error
+	})
+	if err != nil {
+		http.Error(w, "Error enabling two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(r, user, &user.ID, audit.ActionTwoFactorEnabled, nil)
+	sendSecurityAlert(user, webhooks.EventTwoFactorEnabled, "Two-factor authentication was enabled")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// TwoFactorDisableHandler turns 2FA off after re-checking password and a code.
+func TwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	var req TwoFactorDisableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !confirmPassword(w, r, user, req.Password) {
+		return
+	}
+	if err := verifySecondFactor(user, req.Code, req.RecoveryCode, time.Now()); err != nil {
+		writeTwoFactorRequired(w)
+		return
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(user).Updates(map[string]interface{}{
+			"totp_enabled": false,
+			"totp_secret":  "",
+		}).Error
+	})
+	if err != nil {
+		http.Error(w, "Error disabling two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(r, user, &user.ID, audit.ActionTwoFactorDisabled, nil)
+	sendSecurityAlert(user, webhooks.EventTwoFactorDisabled, "Two-factor authentication was disabled")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// RecoveryCodesHandler replaces the user's recovery codes with a new set.
+func RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+	if err := verifySecondFactor(user, req.Code, "", time.Now()); err != nil {
+		writeTwoFactorRequired(w)
+		return
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, user, codes)
+	})
+	if err != nil {
+		http.Error(w, "Error saving recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
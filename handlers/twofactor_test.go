@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/utils"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestVerifySecondFactorRejectsReplay(t *testing.T) {
+	useTestRedis(t)
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), TOTPEnabled: true, TOTPSecret: secret}
+	now := time.Date(2024, 5, 1, 12, 0, 10, 0, time.UTC)
+	code, _ := utils.TOTPCode(secret, utils.TOTPStep(now))
+
+	if err := verifySecondFactor(user, code, "", now); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	// Still inside the code's window, on the same and the next step.
+	for _, at := range []time.Time{now, now.Add(30 * time.Second)} {
+		if err := verifySecondFactor(user, code, "", at); !errors.Is(err, ErrTwoFactorRequired) {
+			t.Errorf("replay at %v = %v, want ErrTwoFactorRequired", at, err)
+		}
+	}
+
+	next, _ := utils.TOTPCode(secret, utils.TOTPStep(now)+1)
+	if err := verifySecondFactor(user, next, "", now.Add(30*time.Second)); err != nil {
+		t.Errorf("next step's code: %v", err)
+	}
+
+	other := &models.User{ID: uuid.New(), TOTPEnabled: true, TOTPSecret: secret}
+	if err := verifySecondFactor(other, code, "", now); err != nil {
+		t.Errorf("same code for another user: %v", err)
+	}
+}
+
+func TestVerifySecondFactorWrongOrMissingCode(t *testing.T) {
+	useTestRedis(t)
+	secret, _ := utils.GenerateTOTPSecret()
+	user := &models.User{ID: uuid.New(), TOTPEnabled: true, TOTPSecret: secret}
+	now := time.Date(2024, 5, 1, 12, 0, 10, 0, time.UTC)
+
+	stale, _ := utils.TOTPCode(secret, utils.TOTPStep(now)-2)
+	for _, code := range []string{"", "000000", stale} {
+		if err := verifySecondFactor(user, code, "", now); !errors.Is(err, ErrTwoFactorRequired) {
+			t.Errorf("code %q = %v, want ErrTwoFactorRequired", code, err)
+		}
+	}
+	if err := verifySecondFactor(&models.User{ID: uuid.New()}, "", "", now); err != nil {
+		t.Errorf("user without 2FA = %v", err)
+	}
+}
+
+// twoFactorRequest calls handler as user with body.
+func twoFactorRequest(handler http.HandlerFunc, user *models.User, body interface{}) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", "/2fa", strings.NewReader(string(b)))
+	req = req.WithContext(auth.WithGrant(req.Context(), &auth.Grant{User: user, Scopes: auth.AllScopes}))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestTwoFactorEnrollmentRequiresPassword(t *testing.T) {
+	useTestDB(t)
+	useTestRedis(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	user := models.User{Name: "Ann", Email: "ann@example.com", PasswordHash: string(hash)}
+	db.DB.Create(&user)
+
+	for _, password := range []string{"", "wrong"} {
+		if rec := twoFactorRequest(TwoFactorSetupHandler, &user, TwoFactorSetupRequest{Password: password}); rec.Code != http.StatusUnauthorized {
+			t.Errorf("setup with password %q: %d", password, rec.Code)
+		}
+	}
+	if user.TOTPSecret != "" {
+		t.Fatal("setup without the password stored a secret")
+	}
+
+	rec := twoFactorRequest(TwoFactorSetupHandler, &user, TwoFactorSetupRequest{Password: "correct horse"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("setup: %d %s", rec.Code, rec.Body)
+	}
+	code, _ := utils.TOTPCode(user.TOTPSecret, utils.TOTPStep(time.Now()))
+
+	if rec := twoFactorRequest(TwoFactorEnableHandler, &user, TwoFactorEnableRequest{Code: code}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("enable without password: %d", rec.Code)
+	}
+	if rec := twoFactorRequest(TwoFactorEnableHandler, &user, TwoFactorEnableRequest{Password: "correct horse", Code: code}); rec.Code != http.StatusOK {
+		t.Errorf("enable: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRecoveryCodesAreHashedPerUser(t *testing.T) {
+	useTestDB(t)
+	ann := models.User{Name: "Ann", Email: "ann@example.com", TOTPEnabled: true}
+	bob := models.User{Name: "Bob", Email: "bob@example.com", TOTPEnabled: true}
+	db.DB.Create(&ann)
+	db.DB.Create(&bob)
+
+	// The same code for two users is stored under different hashes.
+	codes := []string{"abcde-12345"}
+	for _, user := range []*models.User{&ann, &bob} {
+		if err := replaceRecoveryCodes(db.DB, user, codes); err != nil {
+			t.Fatalf("storing %s's codes: %v", user.Name, err)
+		}
+	}
+	var stored []models.RecoveryCode
+	db.DB.Find(&stored)
+	if len(stored) != 2 || stored[0].CodeHash == stored[1].CodeHash {
+		t.Fatalf("stored codes %+v", stored)
+	}
+	if stored[0].CodeHash == hashRecoveryCode(uuid.Nil, "abcde12345") {
+		t.Error("hash does not depend on the user")
+	}
+
+	now := time.Now()
+	if err := verifySecondFactor(&ann, "", "ABCDE-12345", now); err != nil {
+		t.Errorf("Ann's recovery code: %v", err)
+	}
+	if err := verifySecondFactor(&ann, "", "abcde-12345", now); !errors.Is(err, ErrTwoFactorRequired) {
+		t.Errorf("reused recovery code = %v", err)
+	}
+	if err := verifySecondFactor(&bob, "", "abcde12345", now); err != nil {
+		t.Errorf("Bob's recovery code after Ann used hers: %v", err)
+	}
+}
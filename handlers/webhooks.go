@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/webhooks"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultDeliveryLogLimit = 50
+	maxDeliveryLogLimit     = 200
+)
+
+type CreateWebhookRequest struct {
+	URL         string   `json:"url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events"` // empty for all events
+}
+
+func webhookResponse(hook *models.Webhook) map[string]interface{} {
+	events := strings.Fields(hook.Events)
+	if events == nil {
+		events = []string{}
+	}
+	return map[string]interface{}{
+		"id":          hook.ID,
+		"url":         hook.URL,
+		"description": hook.Description,
+		"events":      events,
+		"created_at":  hook.CreatedAt,
+	}
+}
+
+func deliveryResponse(d *models.WebhookDelivery) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              d.ID,
+		"webhook_id":      d.WebhookID,
+		"event_id":        d.EventID,
+		"event":           d.Event,
+		"status":          d.Status,
+		"attempts":        d.Attempts,
+		"response_status": d.ResponseStatus,
+		"last_error":      d.LastError,
+		"next_attempt_at": d.NextAttemptAt,
+		"last_attempt_at": d.LastAttemptAt,
+		"delivered_at":    d.DeliveredAt,
+		"created_at":      d.CreatedAt,
+		"payload":         json.RawMessage(d.Payload),
+	}
+}
+
+// ownedWebhooks scopes a query to the caller's webhooks, or to the global
+// ones for the /admin routes.
+func ownedWebhooks(r *http.Request, global bool) *gorm.DB {
+	if global {
+		return db.DB.Model(&models.Webhook{}).Where("user_id IS NULL")
+	}
+	return db.DB.Model(&models.Webhook{}).Where("user_id = ?", auth.UserFromContext(r.Context()).ID)
+}
+
+func findWebhook(r *http.Request, global bool, id string) (*models.Webhook, error) {
+	var hook models.Webhook
+	err := ownedWebhooks(r, global).Where("id = ?", id).First(&hook).Error
+	return &hook, err
+}
+
+// WebhooksHandler lists (GET), registers (POST) and deletes (DELETE ?id=) the
+// authenticated user's webhooks. The signing secret is only returned on
+// creation.
+func WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooksHandler(w, r, false)
+}
+
+// AdminWebhooksHandler is WebhooksHandler for global webhooks, which receive
+// events for every user.
+func AdminWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooksHandler(w, r, true)
+}
+
+func webhooksHandler(w http.ResponseWriter, r *http.Request, global bool) {
+	user := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		var hooks []models.Webhook
+		if err := ownedWebhooks(r, global).Order("created_at").Find(&hooks).Error; err != nil {
+			http.Error(w, "Error loading webhooks", http.StatusInternalServerError)
+			return
+		}
+		list := make([]map[string]interface{}, 0, len(hooks))
+		for i := range hooks {
+			list = append(list, webhookResponse(&hooks[i]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		var req CreateWebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		u, err := url.Parse(strings.TrimSpace(req.URL))
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			http.Error(w, "url must be an absolute http or https URL", http.StatusBadRequest)
+			return
+		}
+		for _, event := range req.Events {
+			if !webhooks.ValidEvent(event) {
+				http.Error(w, fmt.Sprintf("Unknown event %q", event), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if !global {
+			var count int64
+			if err := ownedWebhooks(r, global).Count(&count).Error; err != nil {
+				http.Error(w, "Error creating webhook", http.StatusInternalServerError)
+				return
+			}
+			if count >= int64(config.WebhookMaxPerUser) {
+				http.Error(w, "Too many webhooks, delete some first", http.StatusConflict)
+				return
+			}
+		}
+
+		secret, err := webhooks.GenerateSecret()
+		if err != nil {
+			http.Error(w, "Error creating webhook", http.StatusInternalServerError)
+			return
+		}
+		hook := models.Webhook{
+			URL:         u.String(),
+			Description: strings.TrimSpace(req.Description),
+			Secret:      secret,
+			Events:      strings.Join(req.Events, " "),
+		}
+		if !global {
+			hook.UserID = &user.ID
+		}
+		if err := db.DB.Create(&hook).Error; err != nil {
+			http.Error(w, "Error creating webhook", http.StatusInternalServerError)
+			return
+		}
+
+		resp := webhookResponse(&hook)
+		resp["secret"] = secret
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodDelete:
+		hook, err := findWebhook(r, global, r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		err = db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("webhook_id = ?", hook.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(hook).Error
+		})
+		if err != nil {
+			http.Error(w, "Error deleting webhook", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Webhook deleted"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// WebhookTestHandler sends a ping event to the webhook ?id= and returns the
+// queued delivery.
+func WebhookTestHandler(w http.ResponseWriter, r *http.Request) {
+	webhookTestHandler(w, r, false)
+}
+
+func AdminWebhookTestHandler(w http.ResponseWriter, r *http.Request) {
+	webhookTestHandler(w, r, true)
+}
+
+func webhookTestHandler(w http.ResponseWriter, r *http.Request, global bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hook, err := findWebhook(r, global, r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	delivery, err := webhooks.Ping(hook)
+	if err != nil {
+		http.Error(w, "Error queueing ping", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(deliveryResponse(delivery))
+}
+
+// WebhookDeliveriesHandler returns the delivery log, newest first, optionally
+// filtered by ?webhook_id= and ?status=. ?status=dead lists the dead letters.
+func WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	webhookDeliveriesHandler(w, r, false)
+}
+
+func AdminWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	webhookDeliveriesHandler(w, r, true)
+}
+
+func webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request, global bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	limit := defaultDeliveryLogLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxDeliveryLogLimit)
+	}
+
+	query := db.DB.Where("webhook_id IN (?)", ownedWebhooks(r, global).Select("id"))
+	if id := q.Get("webhook_id"); id != "" {
+		query = query.Where("webhook_id = ?", id)
+	}
+	if status := q.Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var list []models.WebhookDelivery
+	if err := query.Order("created_at DESC").Limit(limit).Find(&list).Error; err != nil {
+		http.Error(w, "Error loading deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]map[string]interface{}, 0, len(list))
+	for i := range list {
+		resp = append(resp, deliveryResponse(&list[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// WebhookRedeliverHandler requeues the delivery ?id=, typically a dead
+// letter, with a fresh set of attempts.
+func WebhookRedeliverHandler(w http.ResponseWriter, r *http.Request) {
+	webhookRedeliverHandler(w, r, false)
+}
+
+func AdminWebhookRedeliverHandler(w http.ResponseWriter, r *http.Request) {
+	webhookRedeliverHandler(w, r, true)
+}
+
+func webhookRedeliverHandler(w http.ResponseWriter, r *http.Request, global bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var delivery models.WebhookDelivery
+	err := db.DB.Where("id = ? AND webhook_id IN (?)", r.URL.Query().Get("id"), ownedWebhooks(r, global).Select("id")).
+		First(&delivery).Error
+	if err != nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	if delivery.Status == webhooks.StatusPending || delivery.Status == webhooks.StatusRetrying {
+		http.Error(w, "Delivery is still being attempted", http.StatusConflict)
+		return
+	}
+	if err := webhooks.Redeliver(&delivery); err != nil {
+		http.Error(w, "Error requeueing delivery", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Delivery requeued"})
+}
@@ -0,0 +1,70 @@
+// Package mailer sends transactional email. Handlers enqueue rendered
+// templates with Send; a background queue delivers them through the
+// configured Mailer so HTTP requests never wait on SMTP.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"clipsync.com/m/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers a single message.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogMailer writes messages to the log instead of sending them. Used in
+// development and as the default when no SMTP server is configured.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+var queue *Queue
+
+// Init starts the delivery queue using the driver selected in config.
+func Init() {
+	var m Mailer
+	switch config.MailDriver {
+	case "smtp":
+		m = &SMTPMailer{
+			Host:     config.SMTPHost,
+			Port:     config.SMTPPort,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			From:     config.MailFrom,
+		}
+	default:
+		m = LogMailer{}
+	}
+
+	queue = NewQueue(m, 256, 5, 2*time.Second)
+	queue.Start(2)
+}
+
+// Send renders the named template with data and queues it for delivery to to.
+func Send(to, template string, data interface{}) error {
+	if queue == nil {
+		return fmt.Errorf("mailer not initialised")
+	}
+
+	msg, err := Render(template, data)
+	if err != nil {
+		return err
+	}
+	msg.To = to
+	return queue.Enqueue(msg)
+}
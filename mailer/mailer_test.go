@@ -0,0 +1,356 @@
+package mailer
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"clipsync.com/m/config"
+)
+
+// smtpSink is a local SMTP server that keeps what it receives. It refuses
+// the first failures transactions with a temporary error.
+type smtpSink struct {
+	ln       net.Listener
+	received chan sinkMessage
+
+	mu           sync.Mutex
+	failures     int
+	transactions int
+	auth         []string
+}
+
+type sinkMessage struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+func newSMTPSink(t *testing.T, failures int) *smtpSink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpSink{ln: ln, received: make(chan sinkMessage, 16), failures: failures}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpSink) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 sink ESMTP")
+	var msg sinkMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			tp.PrintfLine("250-sink")
+			tp.PrintfLine("250-AUTH PLAIN")
+			tp.PrintfLine("250 8BITMIME")
+		case "HELO", "NOOP", "RSET":
+			tp.PrintfLine("250 OK")
+		case "AUTH":
+			_, creds, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(creds)
+			s.mu.Lock()
+			s.auth = append(s.auth, string(decoded))
+			s.mu.Unlock()
+			tp.PrintfLine("235 Authenticated")
+		case "MAIL":
+			s.mu.Lock()
+			s.transactions++
+			fail := s.failures > 0
+			if fail {
+				s.failures--
+			}
+			s.mu.Unlock()
+			if fail {
+				tp.PrintfLine("451 Try again later")
+				continue
+			}
+			msg = sinkMessage{From: envelopeAddress(arg, "FROM:")}
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, envelopeAddress(arg, "TO:"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.Data = data
+			tp.PrintfLine("250 Queued")
+			s.received <- msg
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+// envelopeAddress returns the address of a MAIL or RCPT argument such as
+// "FROM:<ann@example.com> BODY=8BITMIME".
+func envelopeAddress(arg, prefix string) string {
+	path, _, _ := strings.Cut(strings.TrimPrefix(arg, prefix), " ")
+	return strings.Trim(path, "<>")
+}
+
+func (s *smtpSink) next(t *testing.T) sinkMessage {
+	t.Helper()
+	select {
+	case msg := <-s.received:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message delivered")
+		return sinkMessage{}
+	}
+}
+
+// parts returns the decoded body of each MIME part by content type.
+func parts(t *testing.T, m *mail.Message) map[string]string {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type %q: %v", m.Header.Get("Content-Type"), err)
+	}
+	bodies := map[string]string{}
+	r := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			return bodies
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contentType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		b, _ := io.ReadAll(p) // quoted-printable is decoded by the reader
+		bodies[contentType] = string(b)
+	}
+}
+
+func TestSMTPMailerDelivers(t *testing.T) {
+	sink := newSMTPSink(t, 0)
+	m := &SMTPMailer{Host: "127.0.0.1", Port: sink.port(), From: "ClipSync <no-reply@clipsync.test>"}
+
+	err := m.Send(context.Background(), Message{
+		To:      "Ann <ann@example.com>",
+		Subject: "Grüße from ClipSync",
+		Text:    "Your code is 123456 = valid for 10 minutes.",
+		HTML:    "<p>Your code is <b>123456</b></p>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := sink.next(t)
+	if got.From != "no-reply@clipsync.test" || len(got.To) != 1 || got.To[0] != "ann@example.com" {
+		t.Errorf("envelope from %q to %v", got.From, got.To)
+	}
+	m2, err := mail.ReadMessage(strings.NewReader(string(got.Data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(m2.Header.Get("Subject"))
+	if subject != "Grüße from ClipSync" {
+		t.Errorf("Subject %q", subject)
+	}
+	if m2.Header.Get("To") != `"Ann" <ann@example.com>` || m2.Header.Get("Date") == "" {
+		t.Errorf("headers %v", m2.Header)
+	}
+	bodies := parts(t, m2)
+	if bodies["text/plain"] != "Your code is 123456 = valid for 10 minutes." {
+		t.Errorf("text part %q", bodies["text/plain"])
+	}
+	if bodies["text/html"] != "<p>Your code is <b>123456</b></p>" {
+		t.Errorf("HTML part %q", bodies["text/html"])
+	}
+}
+
+func TestSMTPMailerAuthenticates(t *testing.T) {
+	sink := newSMTPSink(t, 0)
+	m := &SMTPMailer{Host: "127.0.0.1", Port: sink.port(), Username: "user", Password: "pass", From: "no-reply@clipsync.test"}
+
+	if err := m.Send(context.Background(), Message{To: "ann@example.com", Subject: "Hi", Text: "Hi"}); err != nil {
+		t.Fatal(err)
+	}
+	sink.next(t)
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.auth) != 1 || sink.auth[0] != "\x00user\x00pass" {
+		t.Errorf("AUTH PLAIN credentials %q", sink.auth)
+	}
+}
+
+func TestSMTPMailerErrors(t *testing.T) {
+	sink := newSMTPSink(t, 1)
+	m := &SMTPMailer{Host: "127.0.0.1", Port: sink.port(), From: "no-reply@clipsync.test"}
+
+	if err := m.Send(context.Background(), Message{To: "not an address", Text: "Hi"}); err == nil {
+		t.Error("invalid recipient accepted")
+	}
+	if err := m.Send(context.Background(), Message{To: "ann@example.com", Text: "Hi"}); err == nil || !strings.Contains(err.Error(), "451") {
+		t.Errorf("temporary failure = %v, want the 451", err)
+	}
+	bad := &SMTPMailer{Host: "127.0.0.1", Port: sink.port(), From: "not an address"}
+	if err := bad.Send(context.Background(), Message{To: "ann@example.com", Text: "Hi"}); err == nil {
+		t.Error("invalid from address accepted")
+	}
+}
+
+// flakyMailer fails the first failures sends and records when each came.
+type flakyMailer struct {
+	mu       sync.Mutex
+	failures int
+	attempts []time.Time
+	done     chan struct{}
+}
+
+func (m *flakyMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts = append(m.attempts, time.Now())
+	if len(m.attempts) <= m.failures {
+		return errors.New("temporary failure")
+	}
+	close(m.done)
+	return nil
+}
+
+func (m *flakyMailer) sent() []time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]time.Time(nil), m.attempts...)
+}
+
+func TestQueueRetriesWithBackoff(t *testing.T) {
+	const base = 20 * time.Millisecond
+	m := &flakyMailer{failures: 2, done: make(chan struct{})}
+	q := NewQueue(m, 4, 5, base)
+	q.Start(1)
+
+	if err := q.Enqueue(Message{To: "ann@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-m.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was never delivered")
+	}
+
+	attempts := m.sent()
+	if len(attempts) != 3 {
+		t.Fatalf("%d attempts, want 3", len(attempts))
+	}
+	for i, want := range []time.Duration{base, 2 * base} {
+		if gap := attempts[i+1].Sub(attempts[i]); gap < want {
+			t.Errorf("retry %d after %v, want at least %v", i+1, gap, want)
+		}
+	}
+}
+
+func TestQueueGivesUpAfterMaxAttempts(t *testing.T) {
+	const base = 5 * time.Millisecond
+	m := &flakyMailer{failures: 100, done: make(chan struct{})}
+	q := NewQueue(m, 4, 3, base)
+	q.Start(1)
+
+	q.Enqueue(Message{To: "ann@example.com"})
+	// The last retry is due after base + 2*base; wait well past a fourth.
+	time.Sleep(20*base + 200*time.Millisecond)
+	if n := len(m.sent()); n != 3 {
+		t.Errorf("%d attempts, want 3", n)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	q := NewQueue(LogMailer{}, 1, 1, time.Millisecond) // not started
+	if err := q.Enqueue(Message{To: "ann@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(Message{To: "bob@example.com"}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Enqueue on a full queue = %v, want ErrQueueFull", err)
+	}
+}
+
+func TestSendRetriesThroughSMTP(t *testing.T) {
+	sink := newSMTPSink(t, 1)
+	defer func(driver, host string, port int, from string) {
+		config.MailDriver, config.SMTPHost, config.SMTPPort, config.MailFrom = driver, host, port, from
+	}(config.MailDriver, config.SMTPHost, config.SMTPPort, config.MailFrom)
+	config.MailDriver = "smtp"
+	config.SMTPHost = "127.0.0.1"
+	config.SMTPPort = sink.port()
+	config.MailFrom = "ClipSync <no-reply@clipsync.test>"
+
+	Init()
+	err := Send("ann@example.com", "security_alert", map[string]interface{}{
+		"Name":  "Ann",
+		"Event": "Your password was changed",
+		"Time":  time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := sink.next(t) // after the queue's retry, 2 seconds in
+	m, err := mail.ReadMessage(strings.NewReader(string(got.Data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Header.Get("Subject") != subjects["security_alert"] {
+		t.Errorf("Subject %q", m.Header.Get("Subject"))
+	}
+	text := parts(t, m)["text/plain"]
+	if !strings.Contains(text, "Hi Ann,") || !strings.Contains(text, "Your password was changed on your ClipSync account at May 1, 2024 12:00 UTC.") {
+		t.Errorf("text part %q", text)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.transactions != 2 {
+		t.Errorf("%d SMTP transactions, want 2", sink.transactions)
+	}
+}
+
+func TestRenderUnknownTemplate(t *testing.T) {
+	if _, err := Render("no_such_template", nil); err == nil {
+		t.Error("unknown template rendered")
+	}
+	for name := range subjects {
+		if textTemplates.Lookup(name+".txt") == nil || htmlTemplates.Lookup(name+".html") == nil {
+			t.Errorf("template %s is missing a variant", name)
+		}
+	}
+}
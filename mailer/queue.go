@@ -0,0 +1,79 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+var ErrQueueFull = errors.New("mail queue is full")
+
+const sendTimeout = 30 * time.Second
+
+type job struct {
+	msg     Message
+	attempt int
+}
+
+// Queue delivers messages asynchronously, retrying failures with exponential
+// backoff (baseDelay, 2*baseDelay, 4*baseDelay, ...) up to maxAttempts.
+type Queue struct {
+	mailer      Mailer
+	jobs        chan job
+	maxAttempts int
+	baseDelay   time.Duration
+}
+
+func NewQueue(m Mailer, size, maxAttempts int, baseDelay time.Duration) *Queue {
+	return &Queue{
+		mailer:      m,
+		jobs:        make(chan job, size),
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+	}
+}
+
+// Start launches the given number of delivery workers.
+func (q *Queue) Start(workers int) {
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+}
+
+// Enqueue adds a message without blocking the caller.
+func (q *Queue) Enqueue(msg Message) error {
+	select {
+	case q.jobs <- job{msg: msg, attempt: 1}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (q *Queue) work() {
+	for j := range q.jobs {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := q.mailer.Send(ctx, j.msg)
+		cancel()
+		if err == nil {
+			continue
+		}
+
+		if j.attempt >= q.maxAttempts {
+			log.Printf("Giving up on mail to %s (%q) after %d attempts: %v", j.msg.To, j.msg.Subject, j.attempt, err)
+			continue
+		}
+
+		delay := q.baseDelay << (j.attempt - 1)
+		log.Printf("Mail to %s failed (attempt %d), retrying in %s: %v", j.msg.To, j.attempt, delay, err)
+		retry := job{msg: j.msg, attempt: j.attempt + 1}
+		time.AfterFunc(delay, func() {
+			select {
+			case q.jobs <- retry:
+			default:
+				log.Printf("Mail queue full, dropping retry for %s", retry.msg.To)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+//go:embed templates/*
+var templateFS embed.FS
+
+// subjects maps each template name to its subject line. Every template needs
+// a <name>.txt and <name>.html file in templates/.
+var subjects = map[string]string{
+	"password_reset":     "Your ClipSync password reset code",
+	"email_verification": "Verify your ClipSync email address",
+	"security_alert":     "ClipSync security alert",
+	"org_invitation":     "You're invited to a ClipSync organization",
+}
+
+var (
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt"))
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+)
+
+// Render executes the text and HTML variants of the named template.
+func Render(name string, data interface{}) (Message, error) {
+	subject, ok := subjects[name]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown mail template %q", name)
+	}
+
+	var text, html bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return Message{}, err
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{Subject: subject, Text: text.String(), HTML: html.String()}, nil
+}
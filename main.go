@@ -2,31 +2,136 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 
+	"clipsync.com/m/auth"
+	"clipsync.com/m/clips"
+	"clipsync.com/m/config"
 	"clipsync.com/m/db"
+	"clipsync.com/m/grpcapi"
 	"clipsync.com/m/handlers"
+	"clipsync.com/m/mailer"
+	"clipsync.com/m/ratelimit"
+	"clipsync.com/m/retention"
+	"clipsync.com/m/webhooks"
 	"clipsync.com/m/ws"
 )
 
 func main() {
 	db.ConnectDB()
 	db.ConnectRedis()
+	handlers.InitWebAuthn()
+	mailer.Init()
+	ratelimit.Init()
+	handlers.StartAccountPurger()
+	webhooks.Start()
+	retention.Start()
+	clips.StartIndexer()
 	server := ws.NewServer()
 	go server.Run()
 
-	http.HandleFunc("/register", handlers.RegisterHandler)
-	http.HandleFunc("/login", handlers.LoginHandler)
-	http.HandleFunc("/update-password", handlers.UpdatePasswordHandler)
-	http.HandleFunc("/forgot-password", handlers.ForgotPasswordHandler)
-	http.HandleFunc("/reset-password", handlers.ResetPasswordHandler)
-	http.HandleFunc("/login-client", handlers.LoginClientPage)
-	http.HandleFunc("/register-client", handlers.RegisterClientPage)
-	http.HandleFunc("/forgot-password-client", handlers.ForgotPasswordClientPage)
-	http.HandleFunc("/reset-password-client", handlers.ResetPasswordClientPage)
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+	if config.GRPCEnabled {
+		go func() {
+			log.Fatal(grpcapi.Serve(server))
+		}()
+	}
+
+	// Every route gets the per-IP and server-wide limits; authentication
+	// endpoints also count failures per IP and per account.
+	limit := func(h http.HandlerFunc, rules ...ratelimit.Rule) http.HandlerFunc {
+		return ratelimit.Limit(h, append([]ratelimit.Rule{ratelimit.PerIP, ratelimit.PerServer}, rules...)...)
+	}
+	authFailures := []ratelimit.Rule{ratelimit.AuthFailuresPerIP, ratelimit.AuthFailuresPerAccount, ratelimit.AuthFailuresPerIPAccount}
+
+	http.HandleFunc("/register", limit(handlers.RegisterHandler))
+	http.HandleFunc("/login", limit(handlers.LoginHandler, authFailures...))
+	http.HandleFunc("/update-password", limit(auth.Required(handlers.UpdatePasswordHandler), authFailures...))
+	http.HandleFunc("/forgot-password", limit(handlers.ForgotPasswordHandler))
+	http.HandleFunc("/me", limit(auth.Required(handlers.MeHandler)))
+	http.HandleFunc("/me/email", limit(auth.Required(handlers.ChangeEmailHandler), authFailures...))
+	http.HandleFunc("/me/password", limit(auth.Required(handlers.UpdatePasswordHandler), authFailures...))
+	http.HandleFunc("/me/export", limit(auth.Required(handlers.ExportHandler)))
+	http.HandleFunc("/me/activity", limit(auth.Required(handlers.ActivityHandler)))
+	http.HandleFunc("/me/activity/export", limit(auth.Required(handlers.ActivityExportHandler)))
+	http.HandleFunc("/me/sessions/revoke", limit(auth.Required(handlers.RevokeSessionsHandler)))
+	http.HandleFunc("/me/delete", limit(auth.Required(handlers.DeleteAccountHandler), authFailures...))
+	http.HandleFunc("/reset-password", limit(handlers.ResetPasswordHandler, authFailures...))
+	http.HandleFunc("/login-client", limit(handlers.LoginClientPage))
+	http.HandleFunc("/register-client", limit(handlers.RegisterClientPage))
+	http.HandleFunc("/forgot-password-client", limit(handlers.ForgotPasswordClientPage))
+	http.HandleFunc("/reset-password-client", limit(handlers.ResetPasswordClientPage))
+	http.HandleFunc("/verify-email", limit(handlers.VerifyEmailHandler, ratelimit.AuthFailuresPerIP))
+	http.HandleFunc("/verify-email/code", limit(auth.Required(handlers.VerifyEmailCodeHandler), authFailures...))
+	http.HandleFunc("/verify-email/resend", limit(auth.Required(handlers.ResendVerificationHandler)))
+	http.HandleFunc("/2fa/setup", limit(auth.Required(handlers.TwoFactorSetupHandler), authFailures...))
+	http.HandleFunc("/2fa/enable", limit(auth.Required(handlers.TwoFactorEnableHandler), authFailures...))
+	http.HandleFunc("/2fa/disable", limit(auth.Required(handlers.TwoFactorDisableHandler), authFailures...))
+	http.HandleFunc("/2fa/recovery-codes", limit(auth.Required(handlers.RecoveryCodesHandler), authFailures...))
+	http.HandleFunc("/passkey/register/begin", limit(auth.Required(handlers.PasskeyRegisterBeginHandler)))
+	http.HandleFunc("/passkey/register/finish", limit(auth.Required(handlers.PasskeyRegisterFinishHandler)))
+	http.HandleFunc("/oidc/login", limit(handlers.OIDCLoginHandler))
+	http.HandleFunc("/oidc/callback", limit(handlers.OIDCCallbackHandler, ratelimit.AuthFailuresPerIP))
+	http.HandleFunc("/oidc/token", limit(handlers.OIDCTokenHandler, authFailures...))
+	http.HandleFunc("/passkey/login/begin", limit(handlers.PasskeyLoginBeginHandler))
+	http.HandleFunc("/passkey/login/finish", limit(handlers.PasskeyLoginFinishHandler, authFailures...))
+	http.HandleFunc("/passkeys", limit(auth.Required(handlers.PasskeysHandler)))
+	http.HandleFunc("/tokens", limit(auth.Required(handlers.CreateTokenHandler)))
+	http.HandleFunc("/api-keys", limit(auth.Required(handlers.APIKeysHandler)))
+	http.HandleFunc("/devices", limit(auth.RequireScope(auth.ScopeDevicesRead, handlers.DevicesHandler)))
+	http.HandleFunc("/clips", limit(auth.RequireScope(auth.ScopeClipsWrite, handlers.PushClipHandler)))
+	http.HandleFunc("/clips/search", limit(auth.RequireScope(auth.ScopeClipsRead, handlers.SearchClipsHandler)))
+	http.HandleFunc("/clips/metadata", limit(auth.RequireScope(auth.ScopeClipsRead, handlers.ClipMetadataHandler)))
+	http.HandleFunc("/clips/tags", limit(auth.RequireScope(auth.ScopeClipsRead, handlers.ClipTagsHandler)))
+	http.HandleFunc("/spaces", limit(auth.Required(handlers.SpacesHandler)))
+	http.HandleFunc("/spaces/members", limit(auth.Required(handlers.SpaceMembersHandler)))
+	http.HandleFunc("/contacts", limit(auth.Required(handlers.ContactsHandler)))
+	http.HandleFunc("/contacts/accept", limit(auth.Required(handlers.ContactAcceptHandler)))
+	http.HandleFunc("/contacts/block", limit(auth.Required(handlers.ContactBlockHandler)))
+	http.HandleFunc("/send", limit(auth.RequireScope(auth.ScopeClipsWrite, handlers.SendClipHandler)))
+	http.HandleFunc("/inbox", limit(auth.RequireScope(auth.ScopeClipsRead, handlers.InboxHandler)))
+	http.HandleFunc("/org", limit(auth.Required(handlers.OrgHandler)))
+	http.HandleFunc("/org/members", limit(auth.Required(handlers.OrgMembersHandler)))
+	http.HandleFunc("/org/invitations", limit(auth.Required(handlers.OrgInvitationsHandler)))
+	http.HandleFunc("/org/join", limit(auth.Required(handlers.OrgJoinHandler)))
+	http.HandleFunc("/shares", limit(auth.Required(handlers.SharesHandler)))
+	http.HandleFunc("/shares/access", limit(auth.Required(handlers.ShareAccessLogHandler)))
+	http.HandleFunc("/share", limit(handlers.SharePage, ratelimit.AuthFailuresPerIP))
+	http.HandleFunc("/share/raw", limit(handlers.ShareRawHandler, ratelimit.AuthFailuresPerIP))
+	http.HandleFunc("/webhooks", limit(auth.Required(handlers.WebhooksHandler)))
+	http.HandleFunc("/webhooks/test", limit(auth.Required(handlers.WebhookTestHandler)))
+	http.HandleFunc("/webhooks/deliveries", limit(auth.Required(handlers.WebhookDeliveriesHandler)))
+	http.HandleFunc("/webhooks/redeliver", limit(auth.Required(handlers.WebhookRedeliverHandler)))
+	http.HandleFunc("/admin/webhooks", limit(auth.RequireAdmin(handlers.AdminWebhooksHandler)))
+	http.HandleFunc("/admin/webhooks/test", limit(auth.RequireAdmin(handlers.AdminWebhookTestHandler)))
+	http.HandleFunc("/admin/webhooks/deliveries", limit(auth.RequireAdmin(handlers.AdminWebhookDeliveriesHandler)))
+	http.HandleFunc("/admin/webhooks/redeliver", limit(auth.RequireAdmin(handlers.AdminWebhookRedeliverHandler)))
+	http.HandleFunc("/admin/users", limit(auth.RequirePermission(auth.PermUsersRead, handlers.AdminUsersHandler)))
+	http.HandleFunc("/admin/users/devices", limit(auth.RequirePermission(auth.PermUsersRead, func(w http.ResponseWriter, r *http.Request) {
+		handlers.AdminUserDevicesHandler(server, w, r)
+	})))
+	http.HandleFunc("/admin/users/disconnect", limit(auth.RequirePermission(auth.PermUsersManage, handlers.AdminDisconnectHandler)))
+	http.HandleFunc("/admin/users/lock", limit(auth.RequirePermission(auth.PermUsersManage, handlers.AdminLockHandler)))
+	http.HandleFunc("/admin/users/2fa/reset", limit(auth.RequirePermission(auth.PermUsersManage, handlers.AdminTwoFactorResetHandler)))
+	http.HandleFunc("/admin/audit", limit(auth.RequirePermission(auth.PermUsersRead, handlers.AdminAuditHandler)))
+	http.HandleFunc("/admin/audit/export", limit(auth.RequirePermission(auth.PermUsersRead, handlers.AdminAuditExportHandler)))
+	http.HandleFunc("/admin/audit/verify", limit(auth.RequirePermission(auth.PermUsersRead, handlers.AdminAuditVerifyHandler)))
+	http.HandleFunc("/admin/orgs", limit(auth.RequireAdmin(handlers.AdminOrgsHandler)))
+	http.HandleFunc("/pair/start", limit(auth.Required(handlers.PairStartHandler)))
+	http.HandleFunc("/pair/qr", limit(handlers.PairQRHandler))
+	http.HandleFunc("/pair/redeem", limit(handlers.PairRedeemHandler))
+	http.HandleFunc("/pair/confirm", limit(auth.Required(handlers.PairConfirmHandler)))
+	http.HandleFunc("/pair/status", limit(handlers.PairStatusHandler))
+	http.HandleFunc("/pair-client", limit(handlers.PairClientPage))
+	http.HandleFunc("/ws", limit(func(w http.ResponseWriter, r *http.Request) {
 		ws.ServeWS(server, w, r)
-	})
+	}, ratelimit.AuthFailuresPerIP))
+	http.HandleFunc("/sse", limit(func(w http.ResponseWriter, r *http.Request) {
+		ws.ServeSSE(server, w, r)
+	}, ratelimit.AuthFailuresPerIP))
+	http.HandleFunc("/poll", limit(func(w http.ResponseWriter, r *http.Request) {
+		ws.ServePoll(server, w, r)
+	}, ratelimit.AuthFailuresPerIP))
 	fmt.Println("Server running on http://localhost:8080")
 	http.ListenAndServe(":8080", nil)
 }
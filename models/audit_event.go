@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEvent records a security-relevant action on an account. ActorID is
+// who acted, such as the user or an admin, and UserID the account acted on;
+// either may be nil. Events form a hash chain in Seq order: Hash covers the
+// event and PrevHash, so editing or deleting an event breaks the chain.
+type AuditEvent struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Seq       int64      `gorm:"uniqueIndex"`
+	ActorID   *uuid.UUID `gorm:"type:uuid;index"`
+	UserID    *uuid.UUID `gorm:"type:uuid;index"`
+	Action    string     `gorm:"index"`
+	IP        string
+	UserAgent string
+	Details   string // JSON object
+	PrevHash  string
+	Hash      string
+	CreatedAt time.Time `gorm:"index"`
+}
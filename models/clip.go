@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clip is one entry of a user's clipboard history, or of a space's when
+// SpaceID is set; UserID is then the member who sent it. Clips with SenderID
+// set were sent by another user and sit in UserID's inbox. Small payloads are
+// kept in Content; larger ones live in the blob store under BlobKey.
+// Encrypted clips hold ciphertext the server cannot read. Pinned clips are
+// never purged by retention.
+type Clip struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	UserID      uuid.UUID  `gorm:"type:uuid;index:idx_clip_user_created"`
+	SpaceID     *uuid.UUID `gorm:"type:uuid;index:idx_clip_space_created"` // set for clips shared in a space
+	SenderID    *uuid.UUID `gorm:"type:uuid"`                              // set for clips received from another user
+	DeviceID    string     // device (or integration) the clip came from
+	ContentType string
+	Encrypted   bool
+	Size        int
+	Content     []byte
+	BlobKey     string
+	Pinned      bool      `gorm:"default:false"`
+	Favorite    bool      `gorm:"default:false"`
+	CreatedAt   time.Time `gorm:"index:idx_clip_user_created;index:idx_clip_space_created"`
+}
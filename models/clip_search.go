@@ -0,0 +1,12 @@
+package models
+
+import "github.com/google/uuid"
+
+// ClipSearch holds the full-text search vector of a text clip in its owner's
+// history. It is kept apart from Clip so history queries don't load it, and
+// is deleted with its clip.
+type ClipSearch struct {
+	ClipID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Clip   Clip      `gorm:"constraint:OnDelete:CASCADE"`
+	Vector string    `gorm:"type:tsvector;index:idx_clip_search_vector,type:gin"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "github.com/google/uuid"
+
+// ClipTag is a user-defined tag on a clip in its owner's history or inbox.
+// Tags are stored lowercase and are deleted with their clip.
+type ClipTag struct {
+	ClipID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Tag    string    `gorm:"primaryKey;index:idx_clip_tag_user_tag,priority:2"`
+	UserID uuid.UUID `gorm:"type:uuid;index:idx_clip_tag_user_tag,priority:1"`
+	Clip   Clip      `gorm:"constraint:OnDelete:CASCADE"`
+}
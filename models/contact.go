@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Contact links two users who may send each other clips. UserID asked
+// ContactID, and the entry stays pending until ContactID accepts. Blocked
+// entries are always stored with the user who blocked as ContactID.
+type Contact struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_contact_pair"`
+	ContactID uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_contact_pair;index"`
+	Status    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
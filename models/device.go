@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Device struct {
+	ID         uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID     uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_user_device"`
+	DeviceID   string    `gorm:"uniqueIndex:idx_user_device"`
+	Name       string
+	PairedFrom string // device_id of the device that approved the pairing
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Identity links a user to an account at an external OpenID Connect
+// provider, identified by the provider's issuer and subject.
+type Identity struct {
+	ID          uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID      uuid.UUID `gorm:"type:uuid;index"`
+	Issuer      string    `gorm:"uniqueIndex:idx_issuer_subject"`
+	Subject     string    `gorm:"uniqueIndex:idx_issuer_subject"`
+	Email       string
+	LastLoginAt *time.Time
+	CreatedAt   time.Time
+}
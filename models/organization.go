@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Organization groups users, such as a department, under shared policies.
+// Members can only share clips with members of the same organization. Users
+// whose verified email is at Domain join automatically.
+type Organization struct {
+	ID                  uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Name                string
+	Domain              string `gorm:"index"` // empty = no auto-join
+	MaxClipSize         int    // bytes; 0 = the server limit
+	RetentionDays       int    // 0 = keep history forever
+	KeepLastClips       int    // 0 = no limit
+	RelayOnly           bool   // members' clips are never stored in history
+	RequireEncryption   bool   // members must end-to-end encrypt clips
+	AllowedContentTypes string // space-separated, e.g. "text/* image/png"; empty = all
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+// OrgInvitation invites an email address to join an organization with a
+// role. It is accepted by the user who has verified that address.
+type OrgInvitation struct {
+	ID         uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	OrgID      uuid.UUID `gorm:"type:uuid;index"`
+	Email      string    `gorm:"index"`
+	Role       string
+	InvitedBy  uuid.UUID `gorm:"type:uuid"`
+	ExpiresAt  time.Time
+	AcceptedAt *time.Time
+	CreatedAt  time.Time
+}
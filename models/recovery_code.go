@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RecoveryCode is a single-use backup code for two-factor authentication.
+// Only a keyed hash of the user id and code is stored.
+type RecoveryCode struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_user_recovery_code"`
+	CodeHash  string    `gorm:"uniqueIndex:idx_user_recovery_code"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareLink is a public URL for one of a user's clips. Only a keyed hash of
+// the token is stored; Prefix is kept so users can tell links apart.
+type ShareLink struct {
+	ID           uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID       uuid.UUID `gorm:"type:uuid;index"`
+	ClipID       uuid.UUID `gorm:"type:uuid;index"`
+	Prefix       string
+	TokenHash    string `gorm:"uniqueIndex"`
+	PasswordHash string // bcrypt; empty when the link has no password
+	ExpiresAt    time.Time
+	MaxViews     int // 0 = unlimited
+	Views        int
+	LastViewedAt *time.Time
+	RevokedAt    *time.Time
+	CreatedAt    time.Time
+}
+
+// ShareLinkAccess records one attempt to open a share link and its outcome.
+type ShareLinkAccess struct {
+	ID          uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	ShareLinkID uuid.UUID `gorm:"type:uuid;index"`
+	Outcome     string
+	IP          string
+	UserAgent   string
+	CreatedAt   time.Time
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Space is a clipboard shared by its members.
+type Space struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Name      string
+	CreatedBy uuid.UUID  `gorm:"type:uuid"`
+	OrgID     *uuid.UUID `gorm:"type:uuid;index"` // the creator's organization; members must share it
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// SpaceMember gives a user a role in a space: owner, editor or viewer.
+type SpaceMember struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	SpaceID   uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_space_member"`
+	UserID    uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_space_member;index"`
+	Role      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
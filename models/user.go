@@ -9,9 +9,24 @@ import (
 type User struct {
 	ID                uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
 	Name              string
-	Email             string `gorm:"uniqueIndex"`
+	Email             string `gorm:"uniqueIndex:idx_users_email_lower,expression:LOWER(email)"` // stored lowercased
+	EmailVerified     bool   `gorm:"default:false"`
+	EmailVerifiedAt   *time.Time
+	PendingEmail      string // new address awaiting verification
 	PasswordHash      string
 	EncryptionEnabled bool `gorm:"default:true"`
+	TOTPSecret        string
+	TOTPEnabled       bool       `gorm:"default:false"`
+	SessionVersion    int        `gorm:"default:0"`       // bumped to revoke all issued tokens
+	DeleteAfter       *time.Time `gorm:"index"`           // set when deletion is requested; purged after this time
+	OrgID             *uuid.UUID `gorm:"type:uuid;index"` // nil for users outside any organization
+	OrgRole           string     // "admin" or "member" when OrgID is set
+	Role              string     // staff role for the admin API, e.g. "admin" or "support"; empty for regular users
+	LockedAt          *time.Time // set while an admin has locked the account
+	LockReason        string
+	RetentionDays     int  // 0 = keep history forever
+	KeepLastClips     int  // 0 = no limit
+	RelayOnly         bool `gorm:"default:false"` // never store clips in history
 	CreatedAt         time.Time
 	UpdatedAt         time.Time
 }
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebAuthnCredential is a passkey registered by a user. Credential holds the
+// JSON-encoded webauthn.Credential (public key, flags, sign counter).
+type WebAuthnCredential struct {
+	ID           uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID       uuid.UUID `gorm:"type:uuid;index"`
+	CredentialID []byte    `gorm:"uniqueIndex"`
+	Name         string
+	Credential   []byte
+	LastUsedAt   *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
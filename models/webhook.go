@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook is a URL that receives signed JSON events. UserID is nil for
+// global webhooks, registered by admins, which receive every user's events.
+type Webhook struct {
+	ID          uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID      *uuid.UUID `gorm:"type:uuid;index"`
+	URL         string
+	Description string
+	Secret      string // HMAC signing key; kept in plain text because signing needs it
+	Events      string // space-separated event types or "prefix.*" patterns; empty for all
+	CreatedAt   time.Time
+}
+
+// WebhookDelivery is one event queued for one webhook, and the log of
+// attempts to deliver it.
+type WebhookDelivery struct {
+	ID             uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	WebhookID      uuid.UUID `gorm:"type:uuid;index:idx_delivery_webhook_created"`
+	EventID        uuid.UUID `gorm:"type:uuid"`
+	Event          string
+	Payload        []byte
+	Status         string     `gorm:"index:idx_delivery_due"` // pending, retrying, succeeded or dead
+	NextAttemptAt  *time.Time `gorm:"index:idx_delivery_due"`
+	Attempts       int
+	ResponseStatus int // of the last attempt; 0 if no response was received
+	LastError      string
+	LastAttemptAt  *time.Time
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time `gorm:"index:idx_delivery_webhook_created"`
+	UpdatedAt      time.Time
+}
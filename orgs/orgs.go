@@ -0,0 +1,147 @@
+// Package orgs implements organizations: groups of users under shared
+// policies for clip size, content types, encryption and retention. Clips are
+// never routed or stored across organization boundaries; users outside any
+// organization only share with each other.
+package orgs
+
+import (
+	"errors"
+	"strings"
+
+	"clipsync.com/m/clips"
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+var (
+	ErrContentTypeNotAllowed = errors.New("your organization does not allow this type of clip")
+	ErrEncryptionRequired    = errors.New("your organization requires end-to-end encrypted clips")
+)
+
+// ValidRole reports whether role is one of the member roles.
+func ValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleMember
+}
+
+// IsAdmin reports whether user administers their organization.
+func IsAdmin(user *models.User) bool {
+	return user.OrgID != nil && user.OrgRole == RoleAdmin
+}
+
+// Same reports whether two users are in the same organization, or both in
+// none, and so may share clips.
+func Same(a, b *models.User) bool {
+	return SameID(a.OrgID, b.OrgID)
+}
+
+// SameID is Same for organization ids, which are nil outside any
+// organization.
+func SameID(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// ForUser returns the organization of the user with the given id, or nil if
+// they are not in one.
+func ForUser(userID string) (*models.Organization, error) {
+	var org models.Organization
+	err := db.DB.Joins("JOIN users ON users.org_id = organizations.id").
+		Where("users.id = ?", userID).First(&org).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// PublicEmailDomain reports whether domain is a public email provider that
+// can't be an organization's auto-join domain.
+func PublicEmailDomain(domain string) bool {
+	for _, d := range config.PublicEmailDomains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForEmail returns the organization that auto-joins users with email's
+// domain, or nil if there is none.
+func ForEmail(email string) (*models.Organization, error) {
+	_, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+	if !ok || domain == "" || PublicEmailDomain(domain) {
+		return nil, nil
+	}
+	var org models.Organization
+	err := db.DB.Where("domain = ?", domain).First(&org).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// ValidContentTypes reports whether every pattern is a media type or a
+// "type/*" wildcard.
+func ValidContentTypes(patterns []string) bool {
+	for _, p := range patterns {
+		major, minor, ok := strings.Cut(p, "/")
+		if !ok || major == "" || minor == "" || strings.ContainsAny(p, " ;") {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsContentType reports whether org's policy allows clips of
+// contentType. Parameters such as charset are ignored.
+func AllowsContentType(org *models.Organization, contentType string) bool {
+	patterns := strings.Fields(org.AllowedContentTypes)
+	if len(patterns) == 0 {
+		return true
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if prefix, ok := strings.CutSuffix(p, "*"); ok && strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+		if p == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckClip returns nil if a member of org may send a clip with these
+// properties. org may be nil for users outside any organization.
+func CheckClip(org *models.Organization, contentType string, encrypted bool, size int) error {
+	if org == nil {
+		return nil
+	}
+	if org.MaxClipSize > 0 && size > org.MaxClipSize {
+		return clips.ErrTooLarge
+	}
+	if org.RequireEncryption && !encrypted {
+		return ErrEncryptionRequired
+	}
+	if !AllowsContentType(org, contentType) {
+		return ErrContentTypeNotAllowed
+	}
+	return nil
+}
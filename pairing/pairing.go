@@ -0,0 +1,208 @@
+// Package pairing implements the short-lived tokens used to pair a new device
+// with an account by scanning a QR code shown on an already signed-in device.
+//
+// A pairing moves through these states, all stored in Redis under
+// pair_token:<token> and expiring after TokenTTL:
+//
+//	pending  -> created by the existing device
+//	claimed  -> the new device scanned the code and is waiting
+//	approved -> the existing device confirmed (POST /pair/confirm)
+//	rejected -> the existing device declined
+package pairing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"clipsync.com/m/db"
+	"github.com/go-redis/redis/v8"
+)
+
+const TokenTTL = 2 * time.Minute
+
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusClaimed  Status = "claimed"
+	StatusApproved Status = "approved"
+	StatusRejected Status = "rejected"
+)
+
+var (
+	ErrNotFound     = errors.New("pairing token not found or expired")
+	ErrInvalidState = errors.New("pairing token is not in a valid state for this action")
+	ErrForbidden    = errors.New("pairing token belongs to another device")
+)
+
+type Request struct {
+	UserID        string `json:"user_id"`
+	FromDevice    string `json:"from_device"`
+	NewDeviceID   string `json:"new_device_id,omitempty"`
+	NewDeviceName string `json:"new_device_name,omitempty"`
+	Status        Status `json:"status"`
+}
+
+func redisKey(token string) string {
+	return fmt.Sprintf("pair_token:%s", token)
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Start creates a pending pairing token on behalf of an authenticated device.
+func Start(ctx context.Context, userID, fromDevice string) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(Request{
+		UserID:     userID,
+		FromDevice: fromDevice,
+		Status:     StatusPending,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := db.RedisClient.Set(ctx, redisKey(token), data, TokenTTL).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Get returns the current state of a pairing token.
+func Get(ctx context.Context, token string) (*Request, error) {
+	data, err := db.RedisClient.Get(ctx, redisKey(token)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// update applies fn to the stored request atomically, keeping the existing TTL.
+func update(ctx context.Context, token string, fn func(*Request) error) (*Request, error) {
+	key := redisKey(token)
+	var result *Request
+
+	err := db.RedisClient.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var req Request
+		if err := json.Unmarshal(data, &req); err != nil {
+			return err
+		}
+		if err := fn(&req); err != nil {
+			return err
+		}
+
+		updated, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, updated, redis.KeepTTL)
+			return nil
+		})
+		result = &req
+		return err
+	}, key)
+
+	return result, err
+}
+
+// Claim records the new device that scanned the code. A token can only be
+// claimed once.
+func Claim(ctx context.Context, token, deviceID, deviceName string) (*Request, error) {
+	return update(ctx, token, func(req *Request) error {
+		if req.Status != StatusPending {
+			return ErrInvalidState
+		}
+		req.NewDeviceID = deviceID
+		req.NewDeviceName = deviceName
+		req.Status = StatusClaimed
+		return nil
+	})
+}
+
+// Confirm approves or rejects a claimed token. Only the device that started
+// the pairing may confirm it.
+func Confirm(ctx context.Context, token, userID, deviceID string, approve bool) (*Request, error) {
+	return update(ctx, token, func(req *Request) error {
+		if req.UserID != userID || req.FromDevice != deviceID {
+			return ErrForbidden
+		}
+		if req.Status != StatusClaimed {
+			return ErrInvalidState
+		}
+		if approve {
+			req.Status = StatusApproved
+		} else {
+			req.Status = StatusRejected
+		}
+		return nil
+	})
+}
+
+// Finish consumes an approved or rejected token for the device that claimed
+// it, so credentials are handed out at most once.
+func Finish(ctx context.Context, token, deviceID string) (*Request, error) {
+	key := redisKey(token)
+	var result *Request
+
+	err := db.RedisClient.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var req Request
+		if err := json.Unmarshal(data, &req); err != nil {
+			return err
+		}
+		if req.NewDeviceID != deviceID {
+			return ErrForbidden
+		}
+		if req.Status != StatusApproved && req.Status != StatusRejected {
+			result = &req
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			return nil
+		})
+		result = &req
+		return err
+	}, key)
+
+	return result, err
+}
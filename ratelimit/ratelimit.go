@@ -0,0 +1,333 @@
+// Package ratelimit provides sliding-window rate limiting as HTTP middleware.
+//
+// Each Rule counts requests (or only failed requests) per key, such as the
+// client IP, the account being accessed, or a single global key. Past
+// DelayAfter events responses are slowed down progressively; past Limit the
+// request is rejected with 429 and a Retry-After header, and the key can be
+// locked out for a while.
+package ratelimit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/utils"
+)
+
+const maxPeekBody = 64 * 1024
+
+// KeyFunc extracts the key a rule counts by. An empty key skips the rule.
+type KeyFunc func(r *http.Request) string
+
+type Rule struct {
+	Name string
+	Key  KeyFunc
+	// Limit is the most events allowed in Window. Zero never rejects, so the
+	// rule only delays.
+	Limit  int
+	Window time.Duration
+
+	// FailuresOnly counts only responses with a 401, 403 or 429 status, so
+	// legitimate users aren't limited by their own successful requests.
+	FailuresOnly bool
+
+	// DelayAfter starts delaying responses once this many events are in the
+	// window, doubling from BaseDelay up to MaxDelay. Zero disables delays.
+	DelayAfter int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+
+	// Lockout blocks the key for this long once Limit is exceeded.
+	Lockout time.Duration
+}
+
+var store Store
+
+// Init selects the backing store from config.
+func Init() {
+	if config.RateLimitBackend == "memory" || db.RedisClient == nil {
+		store = NewMemoryStore()
+		return
+	}
+	store = NewRedisStore(db.RedisClient)
+}
+
+// ByIP keys a rule by client IP.
+func ByIP(r *http.Request) string {
+	return utils.ClientIP(r)
+}
+
+// Global keys every request to the same bucket.
+func Global(r *http.Request) string {
+	return "all"
+}
+
+// ByAccount keys a rule by the account a request targets: the user in the
+// bearer token or the API key if there is one, otherwise the "email" field
+// of a JSON body.
+func ByAccount(r *http.Request) string {
+	if token, err := utils.BearerToken(r); err == nil {
+		if strings.HasPrefix(token, auth.APIKeyPrefix) {
+			return "key:" + utils.HashSecret(token)[:16]
+		}
+		if userID, err := utils.ValidateJWT(token); err == nil {
+			return "user:" + userID
+		}
+	}
+
+	if r.Body == nil || r.Method != http.MethodPost {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPeekBody))
+	if err != nil {
+		return ""
+	}
+	// Put the body back for the handler.
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+
+	var fields struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(body, &fields) != nil {
+		return ""
+	}
+	return EmailKey(fields.Email)
+}
+
+// ByIPAndAccount keys a rule by client IP and ByAccount together, so a
+// lockout only affects that client's attempts on that account.
+func ByIPAndAccount(r *http.Request) string {
+	return IPAccountKey(ByIP(r), ByAccount(r))
+}
+
+// IPAccountKey is the ByIPAndAccount key for an IP and a ByAccount key.
+func IPAccountKey(ip, account string) string {
+	if ip == "" || account == "" {
+		return ""
+	}
+	return ip + "|" + account
+}
+
+// EmailKey is the ByAccount key for a request that names an account by email.
+func EmailKey(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return ""
+	}
+	return "email:" + email
+}
+
+// statusRecorder captures the status code written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack lets WebSocket upgrades pass through the recorder.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	return h.Hijack()
+}
+
+// Flush lets streaming responses (Server-Sent Events) pass through the recorder.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func isFailure(status int) bool {
+	return status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusTooManyRequests
+}
+
+func writeLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	secs := int(retryAfter.Seconds())
+	if retryAfter%time.Second != 0 || secs == 0 {
+		secs++
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+}
+
+func progressiveDelay(rule Rule, count int64) time.Duration {
+	if rule.DelayAfter <= 0 || count < int64(rule.DelayAfter) {
+		return 0
+	}
+	shift := count - int64(rule.DelayAfter)
+	if shift > 20 {
+		shift = 20
+	}
+	delay := rule.BaseDelay << shift
+	if rule.MaxDelay > 0 && delay > rule.MaxDelay {
+		delay = rule.MaxDelay
+	}
+	return delay
+}
+
+type boundRule struct {
+	Rule
+	key string
+}
+
+// check applies one rule to key, which is already prefixed with the rule
+// name. It returns how long to delay the request, or a non-zero retryAfter
+// if the request must be rejected.
+func check(ctx context.Context, rule Rule, key string, now time.Time) (delay, retryAfter time.Duration) {
+	if left, err := store.Locked(ctx, key); err == nil && left > 0 {
+		return 0, left
+	}
+
+	var win Window
+	var err error
+	if rule.FailuresOnly {
+		win, err = store.Count(ctx, key, rule.Window, now)
+	} else {
+		win, err = store.Add(ctx, key, rule.Window, now)
+	}
+	if err != nil {
+		log.Println("Rate limit check failed:", err)
+		return 0, 0
+	}
+
+	// FailuresOnly windows hold past failures; this request would be the next.
+	over := win.Count > int64(rule.Limit)
+	if rule.FailuresOnly {
+		over = win.Count >= int64(rule.Limit)
+	}
+	if rule.Limit > 0 && over {
+		retryAfter := win.ResetIn
+		if rule.Lockout > 0 {
+			store.Lock(ctx, key, rule.Lockout)
+			retryAfter = rule.Lockout
+		}
+		return 0, retryAfter
+	}
+
+	return progressiveDelay(rule, win.Count), 0
+}
+
+// checkAll applies every bound rule, stopping at the first that rejects.
+func checkAll(ctx context.Context, bound []boundRule) (delay, retryAfter time.Duration) {
+	now := time.Now()
+	for _, b := range bound {
+		d, retry := check(ctx, b.Rule, b.key, now)
+		if retry > 0 {
+			return 0, retry
+		}
+		if d > delay {
+			delay = d
+		}
+	}
+	return delay, 0
+}
+
+func recordFailure(ctx context.Context, bound []boundRule) {
+	for _, b := range bound {
+		if b.FailuresOnly {
+			store.Add(ctx, b.key, b.Window, time.Now())
+		}
+	}
+}
+
+// Attempt applies rules to a call that is not an HTTP request, such as a gRPC
+// call, sharing counters and lockouts with the HTTP routes. keys[i] is the
+// key for rules[i], as its KeyFunc would return it; empty keys skip the rule.
+// Attempt sleeps through any progressive delay and returns a non-zero
+// retryAfter if the call must be rejected. Otherwise the caller reports the
+// outcome with done, so FailuresOnly rules can count it.
+func Attempt(rules []Rule, keys []string) (done func(failed bool), retryAfter time.Duration) {
+	done = func(bool) {}
+	if store == nil {
+		return done, 0
+	}
+
+	ctx := context.Background()
+	var bound []boundRule
+	for i, rule := range rules {
+		if keys[i] == "" {
+			continue
+		}
+		bound = append(bound, boundRule{rule, fmt.Sprintf("%s:%s", rule.Name, keys[i])})
+	}
+
+	delay, retryAfter := checkAll(ctx, bound)
+	if retryAfter > 0 {
+		return done, retryAfter
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return func(failed bool) {
+		if failed {
+			recordFailure(ctx, bound)
+		}
+	}, 0
+}
+
+// Limit wraps next with the given rules. Requests are rejected by the first
+// rule that is locked or over its limit.
+func Limit(next http.HandlerFunc, rules ...Rule) http.HandlerFunc {
+	countFailures := false
+	for _, rule := range rules {
+		countFailures = countFailures || rule.FailuresOnly
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			next(w, r)
+			return
+		}
+
+		ctx := context.Background()
+		var bound []boundRule
+		for _, rule := range rules {
+			key := rule.Key(r)
+			if key == "" {
+				continue
+			}
+			bound = append(bound, boundRule{rule, fmt.Sprintf("%s:%s", rule.Name, key)})
+		}
+
+		delay, retryAfter := checkAll(ctx, bound)
+		if retryAfter > 0 {
+			writeLimited(w, retryAfter)
+			return
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+
+		if !countFailures {
+			next(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		if isFailure(rec.status) {
+			recordFailure(ctx, bound)
+		}
+	}
+}
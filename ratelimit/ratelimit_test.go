@@ -0,0 +1,266 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"clipsync.com/m/db"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// useTestRedis points db.RedisClient at an in-process Redis and selects the
+// Redis store for the test.
+func useTestRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	prevClient, prevStore := db.RedisClient, store
+	db.RedisClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	Init()
+	t.Cleanup(func() {
+		db.RedisClient.Close()
+		db.RedisClient, store = prevClient, prevStore
+	})
+	return mr
+}
+
+// stores returns both store implementations, so each test covers both.
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return map[string]Store{"redis": NewRedisStore(client), "memory": NewMemoryStore()}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	const window = time.Minute
+
+	// Events at 0s, 20s and 40s, then checks as the window slides past them.
+	steps := []struct {
+		at      time.Duration
+		add     bool
+		count   int64
+		resetIn time.Duration
+	}{
+		{0, true, 1, window},
+		{20 * time.Second, true, 2, 40 * time.Second},
+		{40 * time.Second, true, 3, 20 * time.Second},
+		{59 * time.Second, false, 3, time.Second},
+		{60 * time.Second, false, 2, 20 * time.Second}, // the first event left
+		{90 * time.Second, false, 1, 10 * time.Second},
+		{100 * time.Second, false, 0, 0},
+		{100 * time.Second, true, 1, window},
+	}
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, step := range steps {
+				now := t0.Add(step.at)
+				var w Window
+				var err error
+				if step.add {
+					w, err = s.Add(ctx, "k", window, now)
+				} else {
+					w, err = s.Count(ctx, "k", window, now)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if w.Count != step.count || w.ResetIn != step.resetIn {
+					t.Errorf("at %v: count %d, reset in %v; want %d, %v", step.at, w.Count, w.ResetIn, step.count, step.resetIn)
+				}
+			}
+		})
+	}
+}
+
+func TestLockReportsTimeLeft(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if left, _ := s.Locked(ctx, "k"); left != 0 {
+				t.Fatalf("unlocked key reports %v", left)
+			}
+			s.Lock(ctx, "k", 50*time.Millisecond)
+			if left, _ := s.Locked(ctx, "k"); left <= 0 || left > 50*time.Millisecond {
+				t.Errorf("locked key reports %v", left)
+			}
+		})
+	}
+}
+
+func TestProgressiveDelay(t *testing.T) {
+	rule := Rule{DelayAfter: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 8 * time.Second}
+	tests := []struct {
+		count int64
+		delay time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, 500 * time.Millisecond},
+		{4, time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{100, 8 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := progressiveDelay(rule, tt.count); got != tt.delay {
+			t.Errorf("progressiveDelay(%d) = %v, want %v", tt.count, got, tt.delay)
+		}
+	}
+	if got := progressiveDelay(Rule{}, 100); got != 0 {
+		t.Errorf("rule without delays: %v", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		rule Rule
+		// events recorded before the checks, as Limit or recordFailure would
+		events int
+		// whether the next check is rejected, and for how long
+		retryAfter time.Duration
+	}{
+		{"requests under the limit", Rule{Limit: 3, Window: time.Minute}, 2, 0},
+		{"requests over the limit", Rule{Limit: 3, Window: time.Minute}, 3, time.Minute},
+		{"failures under the limit", Rule{Limit: 3, Window: time.Minute, FailuresOnly: true}, 2, 0},
+		{"failures at the limit", Rule{Limit: 3, Window: time.Minute, FailuresOnly: true}, 3, time.Minute},
+		{"lockout", Rule{Limit: 3, Window: time.Minute, FailuresOnly: true, Lockout: 15 * time.Minute}, 3, 15 * time.Minute},
+		{"no limit", Rule{Window: time.Minute, FailuresOnly: true, Lockout: 15 * time.Minute}, 50, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestRedis(t)
+			ctx := context.Background()
+			for i := 0; i < tt.events; i++ {
+				store.Add(ctx, "k", tt.rule.Window, t0)
+			}
+			_, retryAfter := check(ctx, tt.rule, "k", t0)
+			if retryAfter != tt.retryAfter {
+				t.Errorf("retryAfter %v, want %v", retryAfter, tt.retryAfter)
+			}
+		})
+	}
+}
+
+func TestLockoutOutlastsWindow(t *testing.T) {
+	mr := useTestRedis(t)
+	ctx := context.Background()
+	rule := Rule{Limit: 2, Window: time.Minute, FailuresOnly: true, Lockout: 15 * time.Minute}
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	store.Add(ctx, "k", rule.Window, t0)
+	store.Add(ctx, "k", rule.Window, t0)
+	if _, retry := check(ctx, rule, "k", t0); retry != rule.Lockout {
+		t.Fatalf("at the limit: retryAfter %v", retry)
+	}
+
+	// The failures have left the window, but the lockout holds.
+	mr.FastForward(10 * time.Minute)
+	if _, retry := check(ctx, rule, "k", t0.Add(10*time.Minute)); retry <= 0 || retry > 5*time.Minute {
+		t.Errorf("during the lockout: retryAfter %v", retry)
+	}
+
+	mr.FastForward(5 * time.Minute)
+	if _, retry := check(ctx, rule, "k", t0.Add(15*time.Minute)); retry != 0 {
+		t.Errorf("after the lockout: retryAfter %v", retry)
+	}
+}
+
+// statusHandler answers every request with status.
+func statusHandler(status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}
+}
+
+func serve(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(body))
+	req.RemoteAddr = "203.0.113.7:1234"
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestLimitCountsOnlyFailures(t *testing.T) {
+	useTestRedis(t)
+	rule := Rule{Name: "test", Key: ByIP, Limit: 3, Window: time.Minute, FailuresOnly: true, Lockout: time.Minute}
+
+	ok := Limit(statusHandler(http.StatusOK), rule)
+	for i := 0; i < 10; i++ {
+		if rec := serve(ok, ""); rec.Code != http.StatusOK {
+			t.Fatalf("successful request %d: %d", i+1, rec.Code)
+		}
+	}
+
+	failing := Limit(statusHandler(http.StatusUnauthorized), rule)
+	for i := 0; i < 3; i++ {
+		if rec := serve(failing, ""); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: %d", i+1, rec.Code)
+		}
+	}
+	rec := serve(ok, "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("after 3 failures: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestAccountFailuresNeverLockOut(t *testing.T) {
+	useTestRedis(t)
+	ctx := context.Background()
+	now := time.Now()
+	key := "auth_fail_account:" + EmailKey("Ann@Example.com")
+
+	// Many failures from many IPs only slow the account down.
+	for i := 0; i < 50; i++ {
+		store.Add(ctx, key, AuthFailuresPerAccount.Window, now)
+	}
+	delay, retry := check(ctx, AuthFailuresPerAccount, key, now)
+	if retry != 0 || delay != AuthFailuresPerAccount.MaxDelay {
+		t.Errorf("delay %v, retryAfter %v", delay, retry)
+	}
+
+	// One client failing against it is locked out, on that account only.
+	failing := Limit(statusHandler(http.StatusUnauthorized), AuthFailuresPerIPAccount)
+	for i := 0; i < AuthFailuresPerIPAccount.Limit; i++ {
+		serve(failing, `{"email":"ann@example.com"}`)
+	}
+	if rec := serve(failing, `{"email":"ann@example.com"}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("same IP and account: %d", rec.Code)
+	}
+	if rec := serve(failing, `{"email":"bob@example.com"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("same IP, other account: %d", rec.Code)
+	}
+}
+
+func TestRedisFallback(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+	s := NewRedisStore(client)
+	ctx := context.Background()
+	now := time.Now()
+
+	mr.Close() // Redis goes down
+	for i := int64(1); i <= 3; i++ {
+		w, err := s.Add(ctx, "k", time.Minute, now)
+		if err != nil || w.Count != i {
+			t.Fatalf("Add %d without Redis: %+v, %v", i, w, err)
+		}
+	}
+	if w, _ := s.Count(ctx, "k", time.Minute, now); w.Count != 3 {
+		t.Errorf("Count without Redis: %d", w.Count)
+	}
+	s.Lock(ctx, "k", time.Minute)
+	if left, err := s.Locked(ctx, "k"); err != nil || left <= 0 {
+		t.Errorf("Locked without Redis: %v, %v", left, err)
+	}
+}
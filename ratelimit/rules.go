@@ -0,0 +1,50 @@
+package ratelimit
+
+import "time"
+
+// Rules shared by the routes in main.go.
+var (
+	// PerIP caps the overall request rate of a single client.
+	PerIP = Rule{Name: "ip", Key: ByIP, Limit: 300, Window: time.Minute}
+
+	// PerServer caps the total request rate across all clients.
+	PerServer = Rule{Name: "global", Key: Global, Limit: 20000, Window: time.Minute}
+
+	// AuthFailuresPerIP slows down and then locks out a client that keeps
+	// failing to authenticate, whichever accounts it tries.
+	AuthFailuresPerIP = Rule{
+		Name:         "auth_fail_ip",
+		Key:          ByIP,
+		Limit:        30,
+		Window:       15 * time.Minute,
+		FailuresOnly: true,
+		DelayAfter:   5,
+		BaseDelay:    250 * time.Millisecond,
+		MaxDelay:     5 * time.Second,
+		Lockout:      15 * time.Minute,
+	}
+
+	// AuthFailuresPerAccount slows down distributed guessing against a single
+	// account, whichever IPs the attempts come from. It never rejects: a hard
+	// limit keyed only by the account would let anyone lock its owner out.
+	AuthFailuresPerAccount = Rule{
+		Name:         "auth_fail_account",
+		Key:          ByAccount,
+		Window:       15 * time.Minute,
+		FailuresOnly: true,
+		DelayAfter:   3,
+		BaseDelay:    500 * time.Millisecond,
+		MaxDelay:     8 * time.Second,
+	}
+
+	// AuthFailuresPerIPAccount locks out a client that keeps failing to sign
+	// in to one account, without affecting that account from other IPs.
+	AuthFailuresPerIPAccount = Rule{
+		Name:         "auth_fail_ip_account",
+		Key:          ByIPAndAccount,
+		Limit:        10,
+		Window:       15 * time.Minute,
+		FailuresOnly: true,
+		Lockout:      15 * time.Minute,
+	}
+)
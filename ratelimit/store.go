@@ -0,0 +1,209 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Window describes the state of a sliding window after a Count or Add.
+type Window struct {
+	Count   int64         // events inside the window
+	ResetIn time.Duration // until the oldest event leaves the window
+}
+
+// Store keeps sliding-window event logs and temporary lockouts.
+type Store interface {
+	// Count returns the window for key without recording an event.
+	Count(ctx context.Context, key string, window time.Duration, now time.Time) (Window, error)
+	// Add records an event for key and returns the updated window.
+	Add(ctx context.Context, key string, window time.Duration, now time.Time) (Window, error)
+	// Lock blocks key for d.
+	Lock(ctx context.Context, key string, d time.Duration) error
+	// Locked returns how much longer key is locked, or 0.
+	Locked(ctx context.Context, key string) (time.Duration, error)
+}
+
+// RedisStore shares limits across server instances using one sorted set per
+// key (scored by event time). If Redis fails it falls back to an in-memory
+// store so a Redis outage degrades to per-node limits rather than none.
+type RedisStore struct {
+	client   *redis.Client
+	fallback *MemoryStore
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client, fallback: NewMemoryStore()}
+}
+
+func windowKey(key string) string {
+	return fmt.Sprintf("ratelimit:%s", key)
+}
+
+func lockKey(key string) string {
+	return fmt.Sprintf("ratelimit_lock:%s", key)
+}
+
+func (s *RedisStore) window(ctx context.Context, key string, window time.Duration, now time.Time, add bool) (Window, error) {
+	rk := windowKey(key)
+	cutoff := now.Add(-window).UnixNano()
+
+	pipe := s.client.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, rk, "-inf", strconv.FormatInt(cutoff, 10))
+	if add {
+		score := float64(now.UnixNano())
+		// The member must be unique even for events in the same nanosecond.
+		member := fmt.Sprintf("%d-%d", now.UnixNano(), rand.Int63())
+		pipe.ZAdd(ctx, rk, &redis.Z{Score: score, Member: member})
+		pipe.PExpire(ctx, rk, window)
+	}
+	count := pipe.ZCard(ctx, rk)
+	oldest := pipe.ZRangeWithScores(ctx, rk, 0, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Window{}, err
+	}
+
+	w := Window{Count: count.Val()}
+	if zs := oldest.Val(); len(zs) > 0 {
+		w.ResetIn = time.Duration(int64(zs[0].Score)+window.Nanoseconds()-now.UnixNano()) * time.Nanosecond
+	}
+	return w, nil
+}
+
+func (s *RedisStore) Count(ctx context.Context, key string, window time.Duration, now time.Time) (Window, error) {
+	w, err := s.window(ctx, key, window, now, false)
+	if err != nil {
+		log.Println("Rate limit store unavailable, using in-memory fallback:", err)
+		return s.fallback.Count(ctx, key, window, now)
+	}
+	return w, nil
+}
+
+func (s *RedisStore) Add(ctx context.Context, key string, window time.Duration, now time.Time) (Window, error) {
+	w, err := s.window(ctx, key, window, now, true)
+	if err != nil {
+		log.Println("Rate limit store unavailable, using in-memory fallback:", err)
+		return s.fallback.Add(ctx, key, window, now)
+	}
+	return w, nil
+}
+
+func (s *RedisStore) Lock(ctx context.Context, key string, d time.Duration) error {
+	if err := s.client.Set(ctx, lockKey(key), 1, d).Err(); err != nil {
+		log.Println("Rate limit store unavailable, using in-memory fallback:", err)
+		return s.fallback.Lock(ctx, key, d)
+	}
+	return nil
+}
+
+func (s *RedisStore) Locked(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, lockKey(key)).Result()
+	if err != nil {
+		log.Println("Rate limit store unavailable, using in-memory fallback:", err)
+		return s.fallback.Locked(ctx, key)
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// MemoryStore keeps limits in process memory, for single-node deployments.
+type MemoryStore struct {
+	mu     sync.Mutex
+	events map[string][]time.Time
+	locks  map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		events: make(map[string][]time.Time),
+		locks:  make(map[string]time.Time),
+	}
+	go s.janitor()
+	return s
+}
+
+// janitor drops keys whose events and locks have all expired.
+func (s *MemoryStore) janitor() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.mu.Lock()
+		for key, until := range s.locks {
+			if now.After(until) {
+				delete(s.locks, key)
+			}
+		}
+		for key, events := range s.events {
+			// Events only record their own time, so keep anything under a day;
+			// windows longer than that are not used.
+			if len(events) == 0 || now.Sub(events[len(events)-1]) > 24*time.Hour {
+				delete(s.events, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *MemoryStore) prune(key string, window time.Duration, now time.Time) []time.Time {
+	events := s.events[key]
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(events) && !events[i].After(cutoff) {
+		i++
+	}
+	events = events[i:]
+	s.events[key] = events
+	return events
+}
+
+func (s *MemoryStore) result(events []time.Time, window time.Duration, now time.Time) Window {
+	w := Window{Count: int64(len(events))}
+	if len(events) > 0 {
+		w.ResetIn = events[0].Add(window).Sub(now)
+	}
+	return w
+}
+
+func (s *MemoryStore) Count(ctx context.Context, key string, window time.Duration, now time.Time) (Window, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.result(s.prune(key, window, now), window, now), nil
+}
+
+func (s *MemoryStore) Add(ctx context.Context, key string, window time.Duration, now time.Time) (Window, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := append(s.prune(key, window, now), now)
+	s.events[key] = events
+	return s.result(events, window, now), nil
+}
+
+func (s *MemoryStore) Lock(ctx context.Context, key string, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locks[key] = time.Now().Add(d)
+	return nil
+}
+
+func (s *MemoryStore) Locked(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.locks[key]
+	if !ok {
+		return 0, nil
+	}
+	if left := time.Until(until); left > 0 {
+		return left, nil
+	}
+	delete(s.locks, key)
+	return 0, nil
+}
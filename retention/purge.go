@@ -0,0 +1,135 @@
+package retention
+
+import (
+	"log"
+	"time"
+
+	"clipsync.com/m/clips"
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Start runs the purge worker, which deletes clips past their user's
+// retention policy every config.RetentionInterval.
+func Start() {
+	go func() {
+		ticker := time.NewTicker(config.RetentionInterval)
+		defer ticker.Stop()
+		for {
+			purgeExpired()
+			<-ticker.C
+		}
+	}()
+}
+
+// limitedUser is a user with a retention limit, and their organization's.
+type limitedUser struct {
+	ID               uuid.UUID
+	RetentionDays    int
+	KeepLastClips    int
+	OrgRetentionDays int
+	OrgKeepLastClips int
+}
+
+func (u *limitedUser) policy() Policy {
+	return For(
+		&models.User{RetentionDays: u.RetentionDays, KeepLastClips: u.KeepLastClips},
+		&models.Organization{RetentionDays: u.OrgRetentionDays, KeepLastClips: u.OrgKeepLastClips},
+	)
+}
+
+// purgeExpired walks the users with a retention limit, a page at a time,
+// and purges their expired clips.
+func purgeExpired() {
+	var lastID uuid.UUID
+	for {
+		var page []limitedUser
+		err := db.DB.Table("users").
+			Select("users.id, users.retention_days, users.keep_last_clips, "+
+				"COALESCE(organizations.retention_days, 0) AS org_retention_days, "+
+				"COALESCE(organizations.keep_last_clips, 0) AS org_keep_last_clips").
+			Joins("LEFT JOIN organizations ON organizations.id = users.org_id").
+			Where("users.retention_days > 0 OR users.keep_last_clips > 0 OR organizations.retention_days > 0 OR organizations.keep_last_clips > 0").
+			Where("users.id > ?", lastID).
+			Order("users.id").Limit(config.RetentionBatchSize).
+			Scan(&page).Error
+		if err != nil {
+			log.Printf("Failed to load users for retention: %v", err)
+			return
+		}
+		for i := range page {
+			purgeUser(page[i].ID, page[i].policy())
+			lastID = page[i].ID
+		}
+		if len(page) < config.RetentionBatchSize {
+			return
+		}
+	}
+}
+
+// purgeUser deletes the user's clips older than p.Days, including their
+// inbox and what they sent to spaces, and their own history beyond the
+// newest p.KeepLast. Pinned clips are kept and don't count toward
+// p.KeepLast.
+func purgeUser(userID uuid.UUID, p Policy) {
+	if p.Days > 0 {
+		cutoff := time.Now().AddDate(0, 0, -p.Days)
+		n, err := deleteBatches(func() *gorm.DB {
+			return db.DB.Where("user_id = ? AND created_at < ? AND NOT pinned", userID, cutoff)
+		})
+		if err != nil {
+			log.Printf("Failed to purge expired clips of user %s: %v", userID, err)
+		}
+		if n > 0 {
+			log.Printf("Purged %d clips older than %d days from user %s", n, p.Days, userID)
+		}
+	}
+	if p.KeepLast > 0 {
+		n, err := deleteBatches(func() *gorm.DB {
+			return db.DB.Where("user_id = ? AND space_id IS NULL AND sender_id IS NULL AND NOT pinned", userID).
+				Order("created_at DESC").Offset(p.KeepLast)
+		})
+		if err != nil {
+			log.Printf("Failed to purge old clips of user %s: %v", userID, err)
+		}
+		if n > 0 {
+			log.Printf("Purged %d clips beyond the last %d from user %s", n, p.KeepLast, userID)
+		}
+	}
+}
+
+// deleteBatches deletes the clips selected by scope, and their blobs, in
+// batches of config.RetentionBatchSize. Each batch is its own short
+// statement, so only the rows being deleted are locked; scope is called
+// again for every batch. It returns how many clips it deleted.
+func deleteBatches(scope func() *gorm.DB) (int, error) {
+	total := 0
+	for {
+		var batch []models.Clip
+		err := scope().Select("id", "user_id", "blob_key").
+			Limit(config.RetentionBatchSize).Find(&batch).Error
+		if err != nil || len(batch) == 0 {
+			return total, err
+		}
+		ids := make([]uuid.UUID, 0, len(batch))
+		for _, c := range batch {
+			ids = append(ids, c.ID)
+		}
+		if err := db.DB.Where("id IN ?", ids).Delete(&models.Clip{}).Error; err != nil {
+			return total, err
+		}
+		for i := range batch {
+			if err := clips.DeleteBlob(&batch[i]); err != nil {
+				log.Printf("Failed to delete blob of clip %s: %v", batch[i].ID, err)
+			}
+		}
+		total += len(batch)
+		if len(batch) < config.RetentionBatchSize {
+			return total, nil
+		}
+		time.Sleep(config.RetentionBatchPause)
+	}
+}
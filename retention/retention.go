@@ -0,0 +1,48 @@
+// Package retention decides how long clips are kept in history and purges
+// the ones past their time. Users and organizations each set a policy; a
+// member gets the stricter of their own and their organization's.
+package retention
+
+import (
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+)
+
+// Policy limits a user's history. Zero values mean no limit.
+type Policy struct {
+	Days      int  `json:"retention_days"`  // clips older than this are deleted
+	KeepLast  int  `json:"keep_last_clips"` // only the newest clips of the user's own history are kept
+	RelayOnly bool `json:"relay_only"`      // clips are relayed to devices but not stored
+}
+
+// For returns the policy that applies to user. org is the user's
+// organization, or nil if they are not in one.
+func For(user *models.User, org *models.Organization) Policy {
+	p := Policy{Days: user.RetentionDays, KeepLast: user.KeepLastClips, RelayOnly: user.RelayOnly}
+	if org != nil {
+		p.Days = stricter(p.Days, org.RetentionDays)
+		p.KeepLast = stricter(p.KeepLast, org.KeepLastClips)
+		p.RelayOnly = p.RelayOnly || org.RelayOnly
+	}
+	return p
+}
+
+// Load returns the policy of the user with the given id. org is their
+// organization, as returned by orgs.ForUser.
+func Load(userID string, org *models.Organization) (Policy, error) {
+	var user models.User
+	err := db.DB.Select("id", "retention_days", "keep_last_clips", "relay_only").
+		Where("id = ?", userID).First(&user).Error
+	if err != nil {
+		return Policy{}, err
+	}
+	return For(&user, org), nil
+}
+
+// stricter returns the smaller of two limits where 0 means none.
+func stricter(a, b int) int {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
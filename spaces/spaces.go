@@ -0,0 +1,133 @@
+// Package spaces implements shared clipboards. A space has members with a
+// role each: owners manage the space and its members, editors send and
+// receive clips, viewers only receive them.
+package spaces
+
+import (
+	"errors"
+
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var (
+	ErrNotMember = errors.New("not a member of this space")
+	ErrReadOnly  = errors.New("viewers cannot send clips to this space")
+)
+
+// ValidRole reports whether role is one of the member roles.
+func ValidRole(role string) bool {
+	return role == RoleOwner || role == RoleEditor || role == RoleViewer
+}
+
+// CanSend reports whether members with role may send clips.
+func CanSend(role string) bool {
+	return role == RoleOwner || role == RoleEditor
+}
+
+// CanManage reports whether members with role may rename the space, delete
+// it and manage its members.
+func CanManage(role string) bool {
+	return role == RoleOwner
+}
+
+// Membership returns the user's membership of the space, or ErrNotMember.
+func Membership(spaceID, userID string) (*models.SpaceMember, error) {
+	if _, err := uuid.Parse(spaceID); err != nil {
+		return nil, ErrNotMember
+	}
+	var member models.SpaceMember
+	err := db.DB.Where("space_id = ? AND user_id = ?", spaceID, userID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// CheckSend returns nil if the user may send clips to the space right now.
+// It is called for every clip, so role changes apply immediately.
+func CheckSend(spaceID, userID string) error {
+	member, err := Membership(spaceID, userID)
+	if err != nil {
+		return err
+	}
+	if !CanSend(member.Role) {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// Delete removes a space with its members and clips inside tx. It returns the
+// deleted clips that had blobs, which the caller removes with
+// clips.DeleteBlob once tx has committed.
+func Delete(tx *gorm.DB, spaceID uuid.UUID) ([]models.Clip, error) {
+	var blobClips []models.Clip
+	if err := tx.Select("id", "user_id", "blob_key").Where("space_id = ? AND blob_key <> ''", spaceID).Find(&blobClips).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("space_id = ?", spaceID).Delete(&models.Clip{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("space_id = ?", spaceID).Delete(&models.SpaceMember{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("id = ?", spaceID).Delete(&models.Space{}).Error; err != nil {
+		return nil, err
+	}
+	return blobClips, nil
+}
+
+// LeaveAll removes the user from every space inside tx, as when the account
+// is deleted. A space left without an owner passes to its longest-standing
+// member; a space left without members is deleted. It returns the deleted
+// spaces and their blob clips, as Delete does.
+func LeaveAll(tx *gorm.DB, userID uuid.UUID) ([]uuid.UUID, []models.Clip, error) {
+	var memberships []models.SpaceMember
+	if err := tx.Where("user_id = ?", userID).Find(&memberships).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var deleted []uuid.UUID
+	var blobClips []models.Clip
+	for _, m := range memberships {
+		if err := tx.Delete(&m).Error; err != nil {
+			return nil, nil, err
+		}
+
+		var others []models.SpaceMember
+		if err := tx.Where("space_id = ?", m.SpaceID).Order("created_at").Find(&others).Error; err != nil {
+			return nil, nil, err
+		}
+		if len(others) == 0 {
+			blobs, err := Delete(tx, m.SpaceID)
+			if err != nil {
+				return nil, nil, err
+			}
+			deleted = append(deleted, m.SpaceID)
+			blobClips = append(blobClips, blobs...)
+			continue
+		}
+
+		hasOwner := false
+		for _, o := range others {
+			hasOwner = hasOwner || o.Role == RoleOwner
+		}
+		if !hasOwner {
+			if err := tx.Model(&others[0]).Update("role", RoleOwner).Error; err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return deleted, blobClips, nil
+}
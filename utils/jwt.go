@@ -1,7 +1,12 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -10,10 +15,23 @@ import (
 
 var jwtKey = []byte("e785a48cd35c35c2fe3fdecfb1a9bd599d5de60261144a26e4837cd3a887c81f") // 🔐 Replace with env var in production
 
-func GenerateJWT(userID uuid.UUID, email string) (string, error) {
+// SessionClaims are the claims carried by a session JWT.
+type SessionClaims struct {
+	UserID         string
+	Email          string
+	SessionVersion int
+	// Scopes is nil for full-access session tokens.
+	Scopes []string
+}
+
+// GenerateJWT issues a 24h session token. sessionVersion must match the
+// user's current models.User.SessionVersion; bumping that value revokes every
+// token issued before.
+func GenerateJWT(userID uuid.UUID, email string, sessionVersion int) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id": userID.String(),
 		"email":   email,
+		"sv":      sessionVersion,
 		"exp":     time.Now().Add(24 * time.Hour).Unix(),
 	}
 
@@ -21,7 +39,33 @@ func GenerateJWT(userID uuid.UUID, email string) (string, error) {
 	return token.SignedString(jwtKey)
 }
 
+// GenerateScopedJWT issues a token limited to scopes, for example a
+// send-only token for a CI job. It is revoked together with the user's
+// sessions.
+func GenerateScopedJWT(userID uuid.UUID, email string, sessionVersion int, scopes []string, ttl time.Duration) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": userID.String(),
+		"email":   email,
+		"sv":      sessionVersion,
+		"scope":   strings.Join(scopes, " "),
+		"exp":     time.Now().Add(ttl).Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtKey)
+}
+
 func ValidateJWT(tokenStr string) (string, error) {
+	claims, err := ParseSessionJWT(tokenStr)
+	if err != nil {
+		return "", err
+	}
+	return claims.UserID, nil
+}
+
+// ParseSessionJWT validates a session token and returns its claims. It does
+// not check the session version against the database; see auth.UserFromToken.
+func ParseSessionJWT(tokenStr string) (*SessionClaims, error) {
 	claims := jwt.MapClaims{}
 
 	_, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
@@ -29,21 +73,103 @@ func ValidateJWT(tokenStr string) (string, error) {
 	})
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Check expiration
 	if exp, ok := claims["exp"].(float64); ok {
 		if int64(exp) < time.Now().Unix() {
-			return "", errors.New("token expired")
+			return nil, errors.New("token expired")
 		}
 	}
 
+	// Single-purpose tokens (e.g. email verification links) are not sessions
+	if _, ok := claims["purpose"]; ok {
+		return nil, errors.New("token cannot be used for authentication")
+	}
+
 	// Extract user_id
 	userID, ok := claims["user_id"].(string)
 	if !ok || userID == "" {
-		return "", errors.New("user_id missing in token")
+		return nil, errors.New("user_id missing in token")
 	}
 
-	return userID, nil
+	email, _ := claims["email"].(string)
+	sv, _ := claims["sv"].(float64)
+
+	var scopes []string
+	if scope, ok := claims["scope"].(string); ok {
+		scopes = strings.Fields(scope)
+		if scopes == nil {
+			scopes = []string{}
+		}
+	}
+
+	return &SessionClaims{
+		UserID:         userID,
+		Email:          email,
+		SessionVersion: int(sv),
+		Scopes:         scopes,
+	}, nil
+}
+
+// GeneratePurposeToken signs a short-lived token that is only valid for one
+// purpose, such as an email verification link. It is bound to email so it
+// stops working if the address changes.
+func GeneratePurposeToken(userID uuid.UUID, email, purpose string, ttl time.Duration) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": userID.String(),
+		"email":   email,
+		"purpose": purpose,
+		"exp":     time.Now().Add(ttl).Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtKey)
+}
+
+// ValidatePurposeToken checks a token made by GeneratePurposeToken and returns
+// the user_id and email it was issued for.
+func ValidatePurposeToken(tokenStr, purpose string) (string, string, error) {
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	}, jwt.WithExpirationRequired())
+	if err != nil {
+		return "", "", err
+	}
+
+	if p, _ := claims["purpose"].(string); p != purpose {
+		return "", "", errors.New("token has the wrong purpose")
+	}
+
+	userID, _ := claims["user_id"].(string)
+	email, _ := claims["email"].(string)
+	if userID == "" || email == "" {
+		return "", "", errors.New("token is missing user_id or email")
+	}
+
+	return userID, email, nil
+}
+
+// BearerToken extracts the token from an "Authorization: Bearer <token>" header.
+func BearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", errors.New("missing bearer token")
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	if token == "" {
+		return "", errors.New("missing bearer token")
+	}
+	return token, nil
+}
+
+// HashSecret returns a keyed SHA-256 hash of a short secret such as a reset
+// code, so values stored in Redis can't be brute-forced without the server key.
+func HashSecret(value string) string {
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
 }
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"clipsync.com/m/config"
+)
+
+// ClientIP returns the caller's IP address. With config.TrustedProxies set,
+// it is read from X-Forwarded-For, config.TrustedProxies entries from the
+// right: each proxy appends the address it got the request from, so entries
+// further left were sent by the client and can be forged.
+func ClientIP(r *http.Request) string {
+	if config.TrustedProxies > 0 {
+		if ip := forwardedFor(r.Header.Values("X-Forwarded-For"), config.TrustedProxies); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedFor returns the address hops entries from the right of the
+// X-Forwarded-For headers, or the leftmost one if there are fewer. It
+// returns "" if that entry is not an IP address.
+func forwardedFor(headers []string, hops int) string {
+	var entries []string
+	for _, h := range headers {
+		for _, e := range strings.Split(h, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				entries = append(entries, e)
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+	ip := entries[max(len(entries)-hops, 0)]
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	return ip
+}
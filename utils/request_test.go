@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"clipsync.com/m/config"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func(n int) { config.TrustedProxies = n }(config.TrustedProxies)
+
+	tests := []struct {
+		name    string
+		proxies int
+		xff     []string
+		want    string
+	}{
+		{"no proxy ignores header", 0, []string{"1.2.3.4"}, "10.0.0.1"},
+		{"one proxy takes rightmost", 1, []string{"6.6.6.6, 1.2.3.4"}, "1.2.3.4"},
+		{"two proxies skip one", 2, []string{"6.6.6.6, 1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{"repeated headers", 2, []string{"6.6.6.6, 1.2.3.4", "10.0.0.2"}, "1.2.3.4"},
+		{"fewer entries than proxies", 3, []string{"1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{"invalid entry", 1, []string{"1.2.3.4, not-an-ip"}, "10.0.0.1"},
+		{"missing header", 1, nil, "10.0.0.1"},
+		{"ipv6", 1, []string{"2001:db8::1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.TrustedProxies = tt.proxies
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "10.0.0.1:4321"
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
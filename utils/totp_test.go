@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 6238 SHA-1 test key "12345678901234567890", base32
+// encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 appendix B vectors, truncated to the 6 digits apps use.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		got, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode(T=%d): %v", v.unix, err)
+		}
+		if got != v.code {
+			t.Errorf("TOTPCode(T=%d) = %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestTOTPCodeSecretFormat(t *testing.T) {
+	step := TOTPStep(time.Unix(59, 0))
+	for _, secret := range []string{"gezdgnbvgy3tqojqgezdgnbvgy3tqojq", rfcSecret + "===="} {
+		if got, err := TOTPCode(secret, step); err != nil || got != "287082" {
+			t.Errorf("TOTPCode(%q) = %s, %v", secret, got, err)
+		}
+	}
+	if _, err := TOTPCode("not base32!", step); err == nil {
+		t.Error("TOTPCode accepted an invalid secret")
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	// 1111111111 is step 37037037, 1 second into it.
+	now := time.Unix(1111111111, 0)
+	step := TOTPStep(now)
+
+	for _, offset := range []int64{-1, 0, 1} {
+		code, _ := TOTPCode(rfcSecret, step+offset)
+		got, ok := ValidateTOTP(rfcSecret, code, now)
+		if !ok || got != step+offset {
+			t.Errorf("code of step %+d: ValidateTOTP = %d, %v; want %d, true", offset, got, ok, step+offset)
+		}
+	}
+	for _, offset := range []int64{-2, 2} {
+		code, _ := TOTPCode(rfcSecret, step+offset)
+		if _, ok := ValidateTOTP(rfcSecret, code, now); ok {
+			t.Errorf("code of step %+d was accepted", offset)
+		}
+	}
+
+	for _, code := range []string{"", "05047", "0504711", "abcdef", "050472"} {
+		if _, ok := ValidateTOTP(rfcSecret, code, now); ok {
+			t.Errorf("ValidateTOTP(%q) accepted", code)
+		}
+	}
+	if got, ok := ValidateTOTP(rfcSecret, " 050 471 ", now); !ok || got != step {
+		t.Errorf("ValidateTOTP with spaces = %d, %v", got, ok)
+	}
+}
+
+// Replays are rejected by remembering the step a code matched, so a code
+// must map to the same step for as long as it is accepted.
+func TestValidateTOTPStepIsStableForReplay(t *testing.T) {
+	issued := time.Unix(1111111080, 0) // start of step 37037036
+	code, _ := TOTPCode(rfcSecret, TOTPStep(issued))
+
+	first, ok := ValidateTOTP(rfcSecret, code, issued)
+	if !ok {
+		t.Fatal("fresh code rejected")
+	}
+	for _, later := range []time.Duration{time.Second, 29 * time.Second, 30 * time.Second, 59 * time.Second} {
+		step, ok := ValidateTOTP(rfcSecret, code, issued.Add(later))
+		if !ok || step != first {
+			t.Errorf("%v later: ValidateTOTP = %d, %v; want %d, true", later, step, ok, first)
+		}
+	}
+	if _, ok := ValidateTOTP(rfcSecret, code, issued.Add(60*time.Second)); ok {
+		t.Error("code accepted two steps after it was issued")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	u, err := url.Parse(TOTPProvisioningURI(rfcSecret, "ann+2fa@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/ClipSync:ann+2fa@example.com" {
+		t.Errorf("URI = %s", u)
+	}
+	if q.Get("secret") != rfcSecret || q.Get("issuer") != "ClipSync" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("query = %v", q)
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	a, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateTOTPSecret()
+	if len(a) != 32 || a == b {
+		t.Errorf("secrets %q and %q", a, b)
+	}
+	if _, err := TOTPCode(a, 1); err != nil {
+		t.Errorf("generated secret is not usable: %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"clipsync.com/m/config"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// sweepInterval is how often due retries, and deliveries that did not fit
+	// in the in-memory queue, are picked up from the database.
+	sweepInterval  = 15 * time.Second
+	sweepBatchSize = 100
+	// maxResponseBody is how much of a receiver's response is read.
+	maxResponseBody = 64 * 1024
+)
+
+var errPrivateAddress = errors.New("webhook URL resolves to a private address")
+
+var deliveries chan uuid.UUID
+
+var (
+	// userClient sends user webhooks; unless allowed in config it refuses
+	// to connect to loopback, private and link-local addresses.
+	userClient = newClient(false)
+	// trustedClient sends global webhooks, which admins may point at
+	// internal services.
+	trustedClient = newClient(true)
+)
+
+func newClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: config.WebhookTimeout}
+	if !allowPrivate {
+		// Checked on the resolved address, so DNS tricks can't get around it.
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			if config.WebhookAllowPrivateNetworks {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   config.WebhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		// A redirect is a failed delivery rather than a request to another URL.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Start launches the delivery workers and the retry sweeper.
+func Start() {
+	deliveries = make(chan uuid.UUID, 1024)
+	for i := 0; i < config.WebhookWorkers; i++ {
+		go work()
+	}
+	go func() {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+		lastCleanup := time.Time{}
+		for {
+			sweep()
+			if time.Since(lastCleanup) > time.Hour {
+				cleanup()
+				lastCleanup = time.Now()
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// enqueue hands a delivery to the workers without blocking. If the queue is
+// full the sweeper picks it up from the database instead.
+func enqueue(id uuid.UUID) {
+	if deliveries == nil {
+		return
+	}
+	select {
+	case deliveries <- id:
+	default:
+	}
+}
+
+func work() {
+	for id := range deliveries {
+		attempt(id)
+	}
+}
+
+// sweep queues deliveries that are due. Every instance sweeps; attempt's
+// claim makes sure each delivery is sent by only one of them.
+func sweep() {
+	var ids []uuid.UUID
+	err := db.DB.Model(&models.WebhookDelivery{}).
+		Where("status IN ? AND next_attempt_at <= ?", []string{StatusPending, StatusRetrying}, time.Now()).
+		Order("next_attempt_at").Limit(sweepBatchSize).Pluck("id", &ids).Error
+	if err != nil {
+		log.Println("Failed to load due webhook deliveries:", err)
+		return
+	}
+	for _, id := range ids {
+		enqueue(id)
+	}
+}
+
+// cleanup removes finished deliveries older than the retention period.
+func cleanup() {
+	cutoff := time.Now().Add(-config.WebhookDeliveryRetention)
+	err := db.DB.Where("status IN ? AND created_at < ?", []string{StatusSucceeded, StatusDead}, cutoff).
+		Delete(&models.WebhookDelivery{}).Error
+	if err != nil {
+		log.Println("Failed to clean up webhook deliveries:", err)
+	}
+}
+
+// attempt claims a due delivery and makes one attempt to send it. The claim
+// pushes next_attempt_at past the request timeout, so other workers and
+// instances skip it; if this one dies mid-request it is retried afterwards.
+func attempt(id uuid.UUID) {
+	now := time.Now()
+	claim := db.DB.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status IN ? AND next_attempt_at <= ?", id, []string{StatusPending, StatusRetrying}, now).
+		Update("next_attempt_at", now.Add(2*config.WebhookTimeout))
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return
+	}
+
+	var delivery models.WebhookDelivery
+	if err := db.DB.Where("id = ?", id).First(&delivery).Error; err != nil {
+		return
+	}
+	var hook models.Webhook
+	if err := db.DB.Where("id = ?", delivery.WebhookID).First(&hook).Error; err != nil {
+		// The webhook was deleted along with its deliveries.
+		return
+	}
+
+	status, err := send(&hook, &delivery)
+	record(&delivery, now, status, err)
+	if delivery.Status == StatusDead {
+		log.Printf("Webhook delivery %s to %s failed %d times, moving to dead letters: %v", delivery.ID, hook.URL, delivery.Attempts, err)
+	}
+	if err := db.DB.Save(&delivery).Error; err != nil {
+		log.Printf("Failed to record webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// record updates delivery with the outcome of an attempt made at now: it
+// succeeded, is retried after config.WebhookRetryBaseDelay doubled for each
+// earlier failure, or is dead after config.WebhookMaxAttempts.
+func record(delivery *models.WebhookDelivery, now time.Time, status int, err error) {
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	delivery.LastAttemptAt = &now
+	switch {
+	case err == nil:
+		delivery.Status = StatusSucceeded
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+	case delivery.Attempts >= config.WebhookMaxAttempts:
+		delivery.Status = StatusDead
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = nil
+	default:
+		next := now.Add(config.WebhookRetryBaseDelay << (delivery.Attempts - 1))
+		delivery.Status = StatusRetrying
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = &next
+	}
+}
+
+// send POSTs the delivery's payload to the webhook and returns the response
+// status. Any status other than 2xx is an error.
+func send(hook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.WebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ClipSync-Webhooks/1.0")
+	req.Header.Set("X-ClipSync-Event", delivery.Event)
+	req.Header.Set("X-ClipSync-Delivery", delivery.ID.String())
+	req.Header.Set("X-ClipSync-Signature", Sign(hook.Secret, time.Now(), delivery.Payload))
+
+	client := userClient
+	if hook.UserID == nil {
+		client = trustedClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,263 @@
+// Package webhooks delivers account events to user and admin webhooks as
+// signed JSON POSTs. Emit stores one delivery per subscribed webhook in
+// Postgres and hands it to background workers, which retry failures with
+// exponential backoff and finally move them to the dead-letter list.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"github.com/google/uuid"
+)
+
+// Event types. Security events are the ones users also get an email alert
+// for; subscribe to all of them with "security.*".
+const (
+	EventPing               = "ping"
+	EventClipCreated        = "clip.created"
+	EventClipReceived       = "clip.received"
+	EventDeviceConnected    = "device.connected"
+	EventDeviceDisconnected = "device.disconnected"
+
+	EventPasswordChanged          = "security.password_changed"
+	EventPasswordReset            = "security.password_reset"
+	EventEmailChangeRequested     = "security.email_change_requested"
+	EventEmailChanged             = "security.email_changed"
+	EventTwoFactorEnabled         = "security.two_factor_enabled"
+	EventTwoFactorDisabled        = "security.two_factor_disabled"
+	EventPasskeyAdded             = "security.passkey_added"
+	EventIdentityLinked           = "security.identity_linked"
+	EventAPIKeyCreated            = "security.api_key_created"
+	EventAccountDeletionScheduled = "security.account_deletion_scheduled"
+	EventAccountDeletionCancelled = "security.account_deletion_cancelled"
+	EventAccountDeleted           = "security.account_deleted"
+	EventAccountLocked            = "security.account_locked"
+	EventAccountUnlocked          = "security.account_unlocked"
+)
+
+// Events lists every event type a webhook can subscribe to.
+var Events = []string{
+	EventClipCreated, EventClipReceived, EventDeviceConnected, EventDeviceDisconnected,
+	EventPasswordChanged, EventPasswordReset, EventEmailChangeRequested, EventEmailChanged,
+	EventTwoFactorEnabled, EventTwoFactorDisabled, EventPasskeyAdded, EventIdentityLinked,
+	EventAPIKeyCreated, EventAccountDeletionScheduled, EventAccountDeletionCancelled, EventAccountDeleted,
+	EventAccountLocked, EventAccountUnlocked,
+}
+
+// Delivery statuses. Dead deliveries form the dead-letter list.
+const (
+	StatusPending   = "pending"
+	StatusRetrying  = "retrying"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+// Envelope is the JSON body POSTed to webhooks.
+type Envelope struct {
+	ID        uuid.UUID   `json:"id"`
+	Type      string      `json:"type"`
+	UserID    string      `json:"user_id,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// ValidEvent reports whether pattern is an event type or a "prefix.*"
+// pattern matching at least one.
+func ValidEvent(pattern string) bool {
+	for _, event := range Events {
+		if matches(pattern, event) {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(pattern, event string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(event, prefix)
+	}
+	return pattern == event
+}
+
+// Subscribed reports whether hook receives event. Pings always go through.
+func Subscribed(hook *models.Webhook, event string) bool {
+	patterns := strings.Fields(hook.Events)
+	if len(patterns) == 0 || event == EventPing {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matches(pattern, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateSecret returns a new random signing secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Sign returns the X-ClipSync-Signature header value for body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">". Receivers should
+// recompute it and reject old timestamps to prevent replays.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := fmt.Sprint(t.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+var (
+	ErrBadSignature   = errors.New("webhook signature does not match")
+	ErrStaleSignature = errors.New("webhook signature timestamp is too old")
+)
+
+// Verify checks an X-ClipSync-Signature header against body, rejecting
+// timestamps more than tolerance away from now. Receivers written in Go can
+// use it directly.
+func Verify(secret, header string, body []byte, tolerance time.Duration) error {
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return ErrBadSignature
+	}
+	t := time.Unix(sec, 0)
+	if d := time.Since(t); d > tolerance || d < -tolerance {
+		return ErrStaleSignature
+	}
+
+	_, expected, _ := strings.Cut(Sign(secret, t, body), ",v1=")
+	for _, sig := range sigs {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
+
+// Emit sends event to the user's webhooks and the global ones subscribed to
+// it. It returns at once; lookups and delivery happen in the background.
+// userID is empty for events not about a single user.
+func Emit(userID, event string, data interface{}) {
+	go func() {
+		if err := emit(userID, event, data); err != nil {
+			log.Printf("Failed to queue %s webhooks for user %s: %v", event, userID, err)
+		}
+	}()
+}
+
+func emit(userID, event string, data interface{}) error {
+	var hooks []models.Webhook
+	query := db.DB.Where("user_id IS NULL")
+	if userID != "" {
+		query = db.DB.Where("user_id = ? OR user_id IS NULL", userID)
+	}
+	if err := query.Find(&hooks).Error; err != nil {
+		return err
+	}
+
+	var subscribed []models.Webhook
+	for _, hook := range hooks {
+		if Subscribed(&hook, event) {
+			subscribed = append(subscribed, hook)
+		}
+	}
+	if len(subscribed) == 0 {
+		return nil
+	}
+
+	envelope := Envelope{
+		ID:        uuid.New(),
+		Type:      event,
+		UserID:    userID,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	for i := range subscribed {
+		if _, err := queue(&subscribed[i], envelope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping queues a ping event for hook alone, so users can test a receiver.
+func Ping(hook *models.Webhook) (*models.WebhookDelivery, error) {
+	envelope := Envelope{
+		ID:        uuid.New(),
+		Type:      EventPing,
+		CreatedAt: time.Now().UTC(),
+		Data:      map[string]string{"webhook_id": hook.ID.String()},
+	}
+	if hook.UserID != nil {
+		envelope.UserID = hook.UserID.String()
+	}
+	return queue(hook, envelope)
+}
+
+func queue(hook *models.Webhook, envelope Envelope) (*models.WebhookDelivery, error) {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	delivery := models.WebhookDelivery{
+		WebhookID:     hook.ID,
+		EventID:       envelope.ID,
+		Event:         envelope.Type,
+		Payload:       payload,
+		Status:        StatusPending,
+		NextAttemptAt: &now,
+	}
+	if err := db.DB.Create(&delivery).Error; err != nil {
+		return nil, err
+	}
+	enqueue(delivery.ID)
+	return &delivery, nil
+}
+
+// Redeliver requeues a delivery, typically one from the dead-letter list,
+// with a fresh set of attempts.
+func Redeliver(delivery *models.WebhookDelivery) error {
+	now := time.Now()
+	err := db.DB.Model(delivery).Updates(map[string]interface{}{
+		"status":          StatusPending,
+		"attempts":        0,
+		"next_attempt_at": now,
+	}).Error
+	if err != nil {
+		return err
+	}
+	enqueue(delivery.ID)
+	return nil
+}
@@ -0,0 +1,171 @@
+package webhooks
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"clipsync.com/m/config"
+	"clipsync.com/m/models"
+	"github.com/google/uuid"
+)
+
+const testSecret = "whsec_test"
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"type":"ping"}`)
+	header := Sign(testSecret, time.Now(), body)
+
+	if err := Verify(testSecret, header, body, time.Minute); err != nil {
+		t.Fatalf("Verify(valid) = %v", err)
+	}
+	if err := Verify("whsec_other", header, body, time.Minute); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Verify(wrong secret) = %v, want ErrBadSignature", err)
+	}
+	if err := Verify(testSecret, header, []byte(`{"type":"pong"}`), time.Minute); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Verify(tampered body) = %v, want ErrBadSignature", err)
+	}
+	ts, _, _ := strings.Cut(header, ",")
+	for _, bad := range []string{"", "v1=abc", "t=abc,v1=abc", ts} {
+		if err := Verify(testSecret, bad, body, time.Minute); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("Verify(%q) = %v, want ErrBadSignature", bad, err)
+		}
+	}
+
+	// Receivers accept any of several v1 signatures, as during a secret
+	// rotation.
+	other := Sign("whsec_old", time.Now(), body)
+	_, oldSig, _ := strings.Cut(other, ",v1=")
+	if err := Verify(testSecret, header+",v1="+oldSig, body, time.Minute); err != nil {
+		t.Errorf("Verify(extra signature) = %v", err)
+	}
+}
+
+func TestVerifyReplayTolerance(t *testing.T) {
+	body := []byte(`{}`)
+	tests := []struct {
+		name string
+		age  time.Duration
+		want error
+	}{
+		{"fresh", 0, nil},
+		{"within tolerance", 4 * time.Minute, nil},
+		{"replayed", 6 * time.Minute, ErrStaleSignature},
+		{"from the future", -6 * time.Minute, ErrStaleSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := Sign(testSecret, time.Now().Add(-tt.age), body)
+			if err := Verify(testSecret, header, body, 5*time.Minute); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordBackoffAndDeadLetter(t *testing.T) {
+	defer func(n int, d time.Duration) {
+		config.WebhookMaxAttempts, config.WebhookRetryBaseDelay = n, d
+	}(config.WebhookMaxAttempts, config.WebhookRetryBaseDelay)
+	config.WebhookMaxAttempts = 4
+	config.WebhookRetryBaseDelay = 30 * time.Second
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	delivery := &models.WebhookDelivery{Status: StatusPending}
+	failure := errors.New("receiver answered 500")
+	for i, want := range []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute} {
+		record(delivery, now, http.StatusInternalServerError, failure)
+		if delivery.Status != StatusRetrying || delivery.Attempts != i+1 {
+			t.Fatalf("attempt %d: status %q, attempts %d", i+1, delivery.Status, delivery.Attempts)
+		}
+		if delivery.NextAttemptAt == nil || delivery.NextAttemptAt.Sub(now) != want {
+			t.Fatalf("attempt %d: next attempt at %v, want %v after", i+1, delivery.NextAttemptAt, want)
+		}
+		if delivery.LastError != failure.Error() || delivery.ResponseStatus != http.StatusInternalServerError {
+			t.Fatalf("attempt %d: last error %q, status %d", i+1, delivery.LastError, delivery.ResponseStatus)
+		}
+	}
+
+	record(delivery, now, 0, failure)
+	if delivery.Status != StatusDead || delivery.NextAttemptAt != nil {
+		t.Fatalf("after max attempts: status %q, next attempt %v", delivery.Status, delivery.NextAttemptAt)
+	}
+
+	// A redelivered dead letter that succeeds is cleared.
+	delivery.Attempts = 0
+	record(delivery, now, http.StatusOK, nil)
+	if delivery.Status != StatusSucceeded || delivery.LastError != "" || delivery.DeliveredAt == nil || delivery.NextAttemptAt != nil {
+		t.Fatalf("after success: %+v", delivery)
+	}
+}
+
+func TestSend(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		if status == http.StatusFound {
+			http.Redirect(w, r, "/elsewhere", status)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	hook := &models.Webhook{ID: uuid.New(), URL: srv.URL, Secret: testSecret} // global, may reach loopback
+	delivery := &models.WebhookDelivery{ID: uuid.New(), Event: EventPing, Payload: []byte(`{"type":"ping"}`)}
+
+	code, err := send(hook, delivery)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("send() = %d, %v", code, err)
+	}
+	if got.Header.Get("X-ClipSync-Event") != EventPing || got.Header.Get("X-ClipSync-Delivery") != delivery.ID.String() {
+		t.Errorf("headers = %v", got.Header)
+	}
+	if err := Verify(testSecret, got.Header.Get("X-ClipSync-Signature"), gotBody, time.Minute); err != nil {
+		t.Errorf("receiver could not verify the signature: %v", err)
+	}
+
+	status = http.StatusInternalServerError
+	if code, err := send(hook, delivery); err == nil || code != http.StatusInternalServerError {
+		t.Errorf("send() to failing receiver = %d, %v", code, err)
+	}
+
+	status = http.StatusFound
+	if code, err := send(hook, delivery); err == nil || code != http.StatusFound {
+		t.Errorf("send() to redirecting receiver = %d, %v; redirects must not be followed", code, err)
+	}
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	defer func(allow bool) { config.WebhookAllowPrivateNetworks = allow }(config.WebhookAllowPrivateNetworks)
+
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	userID := uuid.New()
+	hook := &models.Webhook{ID: uuid.New(), UserID: &userID, URL: srv.URL, Secret: testSecret}
+	delivery := &models.WebhookDelivery{ID: uuid.New(), Event: EventPing, Payload: []byte(`{}`)}
+
+	config.WebhookAllowPrivateNetworks = false
+	if _, err := send(hook, delivery); !errors.Is(err, errPrivateAddress) {
+		t.Fatalf("send() of user webhook to loopback = %v, want errPrivateAddress", err)
+	}
+	if hits != 0 {
+		t.Fatalf("receiver got %d requests", hits)
+	}
+
+	config.WebhookAllowPrivateNetworks = true
+	if _, err := send(hook, delivery); err != nil || hits != 1 {
+		t.Fatalf("send() with private networks allowed = %v, %d hits", err, hits)
+	}
+}
@@ -4,31 +4,123 @@ import (
 	"log"
 	"time"
 
+	"clipsync.com/m/auth"
+	"clipsync.com/m/config"
 	"github.com/gorilla/websocket"
 )
 
 const (
-	writeWait      = 10 * time.Second
-	pongWait       = 60 * time.Second
-	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 2 * 1024 * 1024 // 2 MB
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = (pongWait * 9) / 10
+
+	// HeartbeatInterval is how often transports without their own ping loop
+	// must call Client.Heartbeat to stay marked online.
+	HeartbeatInterval = pingPeriod
 )
 
+// Client is one connection of a user's device, over any transport. Only
+// WebSocket clients have a Conn; other transports drain SendChan themselves.
 type Client struct {
-	UserID   string
-	DeviceID string
-	Conn     *websocket.Conn
-	SendChan chan []byte
-	Server   *Server
+	UserID         string
+	DeviceID       string
+	SpaceID        string // set for connections opened on a shared space
+	Transport      string
+	SessionVersion int
+	APIKeyID       string // set when the connection authenticated with an API key
+	CanSend        bool
+	CanReceive     bool
+	CanPair        bool // may approve new devices
+	Encrypted      bool // the user's clients end-to-end encrypt clips
+	ConnectedAt    time.Time
+	Conn           *websocket.Conn
+	SendChan       chan Message
+	Server         *Server
+}
+
+// NewClient returns an unregistered client for a device authenticated with
+// grant. With a spaceID the connection sends and receives the space's clips
+// instead of the user's own; callers check membership first.
+func NewClient(server *Server, grant *auth.Grant, deviceID, spaceID, transport string) *Client {
+	client := &Client{
+		UserID:         grant.User.ID.String(),
+		DeviceID:       deviceID,
+		SpaceID:        spaceID,
+		Transport:      transport,
+		SessionVersion: grant.User.SessionVersion,
+		CanSend:        grant.Allows(auth.ScopeClipsWrite),
+		CanReceive:     grant.Allows(auth.ScopeClipsRead),
+		CanPair:        grant.Allows(auth.ScopeAccount),
+		Encrypted:      grant.User.EncryptionEnabled,
+		ConnectedAt:    time.Now(),
+		SendChan:       make(chan Message, 256),
+		Server:         server,
+	}
+	if grant.APIKey != nil {
+		client.APIKeyID = grant.APIKey.ID.String()
+	}
+	return client
+}
+
+// Receive publishes a message sent by the device as a clip. Every WebSocket
+// message is clipboard content, whatever it contains; devices answer pairing
+// requests with POST /pair/confirm.
+func (c *Client) Receive(message []byte) {
+	c.SendClip("", message)
+}
+
+// SendClip publishes a clip from the device to the user's other devices, or
+// to the space's members. An empty contentType is sniffed from the payload.
+func (c *Client) SendClip(contentType string, payload []byte) {
+	if !c.CanSend {
+		log.Printf("Dropping clip from receive-only connection: user %s (%s)", c.UserID, c.DeviceID)
+		return
+	}
+	_, err := PublishClip(c.UserID, c.SpaceID, c.DeviceID, contentType, c.Encrypted, payload)
+	if Rejected(err) {
+		log.Printf("Dropping clip from user %s (%s): %v", c.UserID, c.DeviceID, err)
+		return
+	}
+	if err != nil {
+		// Keep syncing even if history can't be written.
+		log.Printf("Failed to store clip for user %s, relaying only: %v", c.UserID, err)
+		PublishToRedis(Message{
+			UserID:      c.UserID,
+			SpaceID:     c.SpaceID,
+			FromDevice:  c.DeviceID,
+			ContentType: contentType,
+			Encrypted:   c.Encrypted,
+			Payload:     payload,
+		})
+	}
+}
+
+func (c *Client) webhookData() map[string]interface{} {
+	data := map[string]interface{}{
+		"device_id": c.DeviceID,
+		"transport": c.Transport,
+	}
+	if c.SpaceID != "" {
+		data["space_id"] = c.SpaceID
+	}
+	return data
+}
+
+// Heartbeat refreshes the device's presence. Transports call it every
+// pingPeriod while the connection is healthy.
+func (c *Client) Heartbeat() {
+	if c.CanReceive {
+		markOnline(c.UserID, c.DeviceID)
+	}
 }
 
 func (c *Client) ReadPump() {
 	defer func() {
-		c.Server.unregister <- c
+		c.Server.Unregister(c)
 		c.Conn.Close()
 	}()
 
-	c.Conn.SetReadLimit(maxMessageSize)
+	c.Conn.SetReadLimit(int64(config.MaxClipSize))
 	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
@@ -41,14 +133,7 @@ func (c *Client) ReadPump() {
 			log.Println("read error:", err)
 			break
 		}
-		// Create the message object
-		msg := Message{
-			UserID:     c.UserID,
-			FromDevice: c.DeviceID,
-			Payload:    message,
-		}
-
-		PublishToRedis(msg)
+		c.Receive(message)
 	}
 }
 
@@ -68,7 +153,7 @@ func (c *Client) WritePump() {
 				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message.Payload); err != nil {
 				log.Println("write error:", err)
 				return
 			}
@@ -78,6 +163,7 @@ func (c *Client) WritePump() {
 				log.Println("ping failed:", err)
 				return
 			}
+			c.Heartbeat()
 		}
 	}
 }
@@ -0,0 +1,114 @@
+package ws
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"clipsync.com/m/clips"
+	"clipsync.com/m/config"
+	"clipsync.com/m/contacts"
+	"clipsync.com/m/models"
+	"clipsync.com/m/orgs"
+	"clipsync.com/m/retention"
+	"clipsync.com/m/spaces"
+	"clipsync.com/m/webhooks"
+	"github.com/google/uuid"
+)
+
+// PublishClip stores a clip in the user's history, publishes it to the
+// user's devices other than fromDevice and notifies webhooks. Every transport
+// sends clips through here. With a spaceID the clip goes to the space's
+// history and members instead, if the user may send to it. The clip must
+// satisfy the user's organization policy. An empty contentType is sniffed
+// from the payload. Users whose retention policy is relay-only get their
+// clips, including those sent to spaces, published but not stored; the
+// returned clip then has no id.
+func PublishClip(userID, spaceID, fromDevice, contentType string, encrypted bool, payload []byte) (*models.Clip, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	var sid *uuid.UUID
+	if spaceID != "" {
+		// Checked on every send, so removed members and viewers can't post.
+		if err := spaces.CheckSend(spaceID, userID); err != nil {
+			return nil, err
+		}
+		parsed, err := uuid.Parse(spaceID)
+		if err != nil {
+			return nil, err
+		}
+		sid = &parsed
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(payload)
+	}
+	org, err := orgs.ForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := orgs.CheckClip(org, contentType, encrypted, len(payload)); err != nil {
+		return nil, err
+	}
+
+	policy, err := retention.Load(userID, org)
+	if err != nil {
+		return nil, err
+	}
+
+	clip := &models.Clip{
+		UserID:      uid,
+		SpaceID:     sid,
+		DeviceID:    fromDevice,
+		ContentType: contentType,
+		Encrypted:   encrypted,
+	}
+	var id string
+	if policy.RelayOnly {
+		if len(payload) > config.MaxClipSize {
+			return nil, clips.ErrTooLarge
+		}
+		clip.Size = len(payload)
+		clip.CreatedAt = time.Now()
+	} else {
+		if err := clips.Save(clip, payload); err != nil {
+			return nil, err
+		}
+		id = clip.ID.String()
+	}
+
+	PublishToRedis(Message{
+		ID:          id,
+		UserID:      userID,
+		SpaceID:     spaceID,
+		FromDevice:  fromDevice,
+		ContentType: clip.ContentType,
+		Encrypted:   encrypted,
+		CreatedAt:   clip.CreatedAt,
+		Payload:     payload,
+	})
+	data := map[string]interface{}{
+		"device_id":    clip.DeviceID,
+		"content_type": clip.ContentType,
+		"encrypted":    clip.Encrypted,
+		"size":         clip.Size,
+		"created_at":   clip.CreatedAt,
+	}
+	if id != "" {
+		data["id"] = id
+	}
+	if spaceID != "" {
+		data["space_id"] = spaceID
+	}
+	webhooks.Emit(userID, webhooks.EventClipCreated, data)
+	return clip, nil
+}
+
+// Rejected reports whether err from PublishClip or SendToUser means the clip
+// may not be sent at all, as opposed to a failure to store it.
+func Rejected(err error) bool {
+	return errors.Is(err, spaces.ErrNotMember) || errors.Is(err, spaces.ErrReadOnly) ||
+		errors.Is(err, contacts.ErrNotContact) || errors.Is(err, clips.ErrTooLarge) ||
+		errors.Is(err, orgs.ErrContentTypeNotAllowed) || errors.Is(err, orgs.ErrEncryptionRequired)
+}
@@ -0,0 +1,113 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"clipsync.com/m/clips"
+	"clipsync.com/m/pairing"
+)
+
+// ControlMessage is a JSON message exchanged between the server and a device
+// that is handled by the server instead of being relayed as clipboard content.
+type ControlMessage struct {
+	Type       string `json:"type"`
+	Token      string `json:"token,omitempty"`
+	Approve    bool   `json:"approve,omitempty"`
+	DeviceID   string `json:"device_id,omitempty"`
+	DeviceName string `json:"device_name,omitempty"`
+	Error      string `json:"error,omitempty"`
+
+	// Set on inbox_clip. DeviceID is then the sender's device.
+	ClipID      string `json:"clip_id,omitempty"`
+	FromUserID  string `json:"from_user_id,omitempty"`
+	FromEmail   string `json:"from_email,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Encrypted   bool   `json:"encrypted,omitempty"`
+	Size        int    `json:"size,omitempty"`
+	Content     []byte `json:"content,omitempty"` // omitted for clips stored as blobs
+
+	// Set on clip_updated.
+	Clip *clips.Metadata `json:"clip,omitempty"`
+}
+
+const (
+	// Server -> existing device: a new device scanned the pairing code.
+	ControlPairRequest = "pair_request"
+	// Existing gRPC device -> server: approve or reject the pending pairing.
+	// Other devices use POST /pair/confirm, since WebSocket messages are
+	// always clips.
+	ControlPairConfirm = "pair_confirm"
+	// Server -> existing device: result of a pair_confirm.
+	ControlPairResult = "pair_result"
+	// Server -> every device: another user sent a clip to the inbox.
+	ControlInboxClip = "inbox_clip"
+	// Server -> every device: a clip was pinned, favorited or tagged.
+	ControlClipUpdated = "clip_updated"
+)
+
+// SendToDevice delivers a control message to a single device of a user, or
+// to all of them when deviceID is empty, on whichever server instance they
+// are connected to.
+func SendToDevice(userID, deviceID string, ctrl ControlMessage) {
+	payload, err := json.Marshal(ctrl)
+	if err != nil {
+		log.Println("Failed to marshal control message:", err)
+		return
+	}
+	PublishToRedis(Message{
+		Type:     MessageControl,
+		UserID:   userID,
+		ToDevice: deviceID,
+		Payload:  payload,
+	})
+}
+
+// ClipUpdated tells every device of the user, on whichever server instance
+// they are connected to, that a clip's metadata changed, so their history
+// views stay in sync.
+func ClipUpdated(userID string, meta *clips.Metadata) {
+	SendToDevice(userID, "", ControlMessage{Type: ControlClipUpdated, ClipID: meta.ID.String(), Clip: meta})
+}
+
+// HandleControl processes message if it is a control message addressed to the
+// server and reports whether it did so.
+func (c *Client) HandleControl(message []byte) bool {
+	var ctrl ControlMessage
+	if err := json.Unmarshal(message, &ctrl); err != nil {
+		return false
+	}
+
+	switch ctrl.Type {
+	case ControlPairConfirm:
+		// Only signed-in devices may approve new ones, not scoped tokens or API keys.
+		if !c.CanPair {
+			SendToDevice(c.UserID, c.DeviceID, ControlMessage{
+				Type:  ControlPairResult,
+				Token: ctrl.Token,
+				Error: "this connection cannot approve pairings",
+			})
+			return true
+		}
+		c.confirmPairing(ctrl)
+		return true
+	}
+	return false
+}
+
+func (c *Client) confirmPairing(ctrl ControlMessage) {
+	result := ControlMessage{Type: ControlPairResult, Token: ctrl.Token}
+
+	req, err := pairing.Confirm(context.Background(), ctrl.Token, c.UserID, c.DeviceID, ctrl.Approve)
+	if err != nil {
+		log.Printf("Pairing confirmation failed for user %s (%s): %v", c.UserID, c.DeviceID, err)
+		result.Error = err.Error()
+	} else {
+		result.Approve = req.Status == pairing.StatusApproved
+		result.DeviceID = req.NewDeviceID
+		result.DeviceName = req.NewDeviceName
+	}
+
+	SendToDevice(c.UserID, c.DeviceID, result)
+}
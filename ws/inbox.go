@@ -0,0 +1,115 @@
+package ws
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"clipsync.com/m/clips"
+	"clipsync.com/m/config"
+	"clipsync.com/m/contacts"
+	"clipsync.com/m/db"
+	"clipsync.com/m/models"
+	"clipsync.com/m/orgs"
+	"clipsync.com/m/retention"
+	"clipsync.com/m/webhooks"
+	"github.com/google/uuid"
+)
+
+// SendToUser stores a clip from sender in recipient's inbox and notifies the
+// recipient's devices with an inbox_clip control message. The sender must be
+// an accepted contact in the same organization, and the clip must satisfy
+// its policy. An empty contentType is sniffed from the payload. If the
+// recipient's retention policy is relay-only the clip is only delivered to
+// their connected devices, with its content, and the returned clip has no id.
+func SendToUser(sender, recipient *models.User, fromDevice, contentType string, encrypted bool, payload []byte) (*models.Clip, error) {
+	if !orgs.Same(sender, recipient) {
+		return nil, contacts.ErrNotContact
+	}
+	if err := contacts.CheckSend(sender.ID, recipient.ID); err != nil {
+		return nil, err
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(payload)
+	}
+	org, err := orgs.ForUser(sender.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := orgs.CheckClip(org, contentType, encrypted, len(payload)); err != nil {
+		return nil, err
+	}
+
+	clip := &models.Clip{
+		UserID:      recipient.ID,
+		SenderID:    &sender.ID,
+		DeviceID:    fromDevice,
+		ContentType: contentType,
+		Encrypted:   encrypted,
+	}
+	// Both users are in org, so its policy is the recipient's too.
+	var clipID string
+	if retention.For(recipient, org).RelayOnly {
+		if len(payload) > config.MaxClipSize {
+			return nil, clips.ErrTooLarge
+		}
+		clip.Size = len(payload)
+		clip.Content = payload
+		clip.CreatedAt = time.Now()
+	} else {
+		if err := clips.Save(clip, payload); err != nil {
+			return nil, err
+		}
+		pruneInbox(recipient)
+		clipID = clip.ID.String()
+	}
+
+	SendToDevice(recipient.ID.String(), "", ControlMessage{
+		Type:        ControlInboxClip,
+		ClipID:      clipID,
+		FromUserID:  sender.ID.String(),
+		FromEmail:   sender.Email,
+		DeviceID:    fromDevice,
+		ContentType: clip.ContentType,
+		Encrypted:   encrypted,
+		Size:        clip.Size,
+		Content:     clip.Content,
+	})
+	data := map[string]interface{}{
+		"sender_id":    sender.ID,
+		"content_type": clip.ContentType,
+		"encrypted":    clip.Encrypted,
+		"size":         clip.Size,
+		"created_at":   clip.CreatedAt,
+	}
+	if clipID != "" {
+		data["id"] = clipID
+	}
+	webhooks.Emit(recipient.ID.String(), webhooks.EventClipReceived, data)
+	return clip, nil
+}
+
+// pruneInbox deletes the user's oldest received clips beyond
+// config.InboxMaxClips. Pinned clips are kept and not counted.
+func pruneInbox(user *models.User) {
+	var old []models.Clip
+	err := db.DB.Select("id", "user_id", "blob_key").
+		Where("user_id = ? AND sender_id IS NOT NULL AND NOT pinned", user.ID).
+		Order("created_at DESC").Offset(config.InboxMaxClips).Find(&old).Error
+	if err != nil || len(old) == 0 {
+		return
+	}
+	ids := make([]uuid.UUID, 0, len(old))
+	for _, c := range old {
+		ids = append(ids, c.ID)
+	}
+	if err := db.DB.Where("id IN ?", ids).Delete(&models.Clip{}).Error; err != nil {
+		log.Printf("Failed to prune inbox of user %s: %v", user.ID, err)
+		return
+	}
+	for i := range old {
+		if err := clips.DeleteBlob(&old[i]); err != nil {
+			log.Printf("Failed to delete blob of clip %s: %v", old[i].ID, err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// pollWait is how long a poll request waits for a clip before returning
+	// empty, kept below common proxy timeouts.
+	pollWait = 25 * time.Second
+	// pollIdleTimeout disconnects a long-poll client that stops polling.
+	pollIdleTimeout = 60 * time.Second
+	pollMaxBatch    = 100
+)
+
+// pollSession keeps a long-poll client registered between requests, so clips
+// sent while no request is open are buffered in its SendChan.
+type pollSession struct {
+	client  *Client
+	polling bool
+	idle    *time.Timer
+}
+
+var (
+	pollMu       sync.Mutex
+	pollSessions = make(map[string]*pollSession)
+)
+
+func pollKey(c *Client) string {
+	return c.UserID + "/" + c.DeviceID + "/" + c.SpaceID
+}
+
+// sameCredentials reports whether a poll request authenticated the same way
+// as the client already registered for its device.
+func sameCredentials(a, b *Client) bool {
+	return a.SessionVersion == b.SessionVersion && a.APIKeyID == b.APIKeyID &&
+		a.CanSend == b.CanSend && a.CanReceive == b.CanReceive
+}
+
+// acquirePollSession returns the device's session, registering a new client
+// if there is none, and marks it as polling. It returns nil if another poll
+// for the device is already open.
+func acquirePollSession(server *Server, fresh *Client) *pollSession {
+	pollMu.Lock()
+	defer pollMu.Unlock()
+
+	key := pollKey(fresh)
+	session, ok := pollSessions[key]
+	if ok && session.polling {
+		return nil
+	}
+	if ok && !sameCredentials(session.client, fresh) {
+		session.idle.Stop()
+		delete(pollSessions, key)
+		server.unregister <- session.client
+		ok = false
+	}
+	if !ok {
+		session = &pollSession{client: fresh}
+		session.idle = time.AfterFunc(pollIdleTimeout, func() { expirePollSession(server, key, session) })
+		pollSessions[key] = session
+		server.register <- fresh
+	}
+
+	session.polling = true
+	session.idle.Stop()
+	return session
+}
+
+func releasePollSession(session *pollSession) {
+	pollMu.Lock()
+	defer pollMu.Unlock()
+	session.polling = false
+	session.idle.Reset(pollIdleTimeout)
+}
+
+// dropPollSession forgets a session whose client the server already closed.
+func dropPollSession(session *pollSession) {
+	pollMu.Lock()
+	defer pollMu.Unlock()
+	key := pollKey(session.client)
+	if pollSessions[key] == session {
+		delete(pollSessions, key)
+	}
+	session.idle.Stop()
+}
+
+func expirePollSession(server *Server, key string, session *pollSession) {
+	pollMu.Lock()
+	if session.polling || pollSessions[key] != session {
+		pollMu.Unlock()
+		return
+	}
+	delete(pollSessions, key)
+	pollMu.Unlock()
+
+	server.Unregister(session.client)
+}
+
+// ServePoll is the last-resort transport: it waits up to pollWait for clips
+// and returns them as {"messages": [...]}, each the payload a WebSocket
+// client would receive. The device stays registered between polls for
+// pollIdleTimeout, so nothing sent in between is missed.
+func ServePoll(server *Server, w http.ResponseWriter, r *http.Request) {
+	fresh, ok := authenticateClient(server, w, r, TransportLongPoll)
+	if !ok {
+		return
+	}
+	if !fresh.CanReceive {
+		http.Error(w, "Token is missing the clips:read scope", http.StatusForbidden)
+		return
+	}
+
+	session := acquirePollSession(server, fresh)
+	if session == nil {
+		http.Error(w, "Another poll is already open for this device", http.StatusConflict)
+		return
+	}
+	client := session.client
+	client.Heartbeat()
+
+	messages := []string{}
+	closed := false
+	timeout := time.NewTimer(pollWait)
+	defer timeout.Stop()
+
+	select {
+	case message, ok := <-client.SendChan:
+		if !ok {
+			closed = true
+			break
+		}
+		messages = append(messages, string(message.Payload))
+		// Return everything else already buffered with it.
+	drain:
+		for len(messages) < pollMaxBatch {
+			select {
+			case message, ok := <-client.SendChan:
+				if !ok {
+					closed = true
+					break drain
+				}
+				messages = append(messages, string(message.Payload))
+			default:
+				break drain
+			}
+		}
+	case <-timeout.C:
+	case <-r.Context().Done():
+	}
+
+	if closed {
+		dropPollSession(session)
+	} else {
+		releasePollSession(session)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"messages": messages})
+}
@@ -0,0 +1,50 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"clipsync.com/m/db"
+	"github.com/go-redis/redis/v8"
+)
+
+// presenceTTL is how long a device counts as online after its last
+// heartbeat. Clients refresh it on every ping.
+const presenceTTL = 2 * pongWait
+
+// Presence is shared across server instances as one sorted set per user,
+// holding the ids of devices that can receive clips scored by last heartbeat.
+func presenceKey(userID string) string {
+	return fmt.Sprintf("presence:%s", userID)
+}
+
+func markOnline(userID, deviceID string) {
+	ctx := context.Background()
+	key := presenceKey(userID)
+	pipe := db.RedisClient.TxPipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(time.Now().Unix()), Member: deviceID})
+	pipe.Expire(ctx, key, presenceTTL)
+	pipe.Exec(ctx)
+}
+
+func markOffline(userID, deviceID string) {
+	db.RedisClient.ZRem(context.Background(), presenceKey(userID), deviceID)
+}
+
+// OnlineDevices returns the ids of the user's devices that are connected to
+// any server instance and able to receive clips.
+func OnlineDevices(userID string) ([]string, error) {
+	cutoff := time.Now().Add(-presenceTTL).Unix()
+	return db.RedisClient.ZRangeByScore(context.Background(), presenceKey(userID), &redis.ZRangeBy{
+		Min: strconv.FormatInt(cutoff, 10),
+		Max: "+inf",
+	}).Result()
+}
+
+// ClearPresence forgets every device of the user, e.g. when the account is
+// deleted.
+func ClearPresence(userID string) {
+	db.RedisClient.Del(context.Background(), presenceKey(userID))
+}
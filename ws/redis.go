@@ -4,15 +4,32 @@ package ws
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 
 	"clipsync.com/m/db"
+	"github.com/go-redis/redis/v8"
 )
 
+// Redis channels. Every message for a user (their own clips, control and
+// revocation messages) goes to the user's channel; clips shared in a space
+// and space membership changes go to the space's channel.
+func userChannel(userID string) string {
+	return "clipboard_sync:user:" + userID
+}
+
+func spaceChannel(spaceID string) string {
+	return "clipboard_sync:space:" + spaceID
+}
+
+func channelFor(msg Message) string {
+	if msg.SpaceID != "" {
+		return spaceChannel(msg.SpaceID)
+	}
+	return userChannel(msg.UserID)
+}
+
 func PublishToRedis(msg Message) {
 	ctx := context.Background()
-	channel := fmt.Sprintf("clipboard_sync:user:%s", msg.UserID)
 
 	jsonData, err := json.Marshal(msg)
 	if err != nil {
@@ -20,39 +37,30 @@ func PublishToRedis(msg Message) {
 		return
 	}
 
-	err = db.RedisClient.Publish(ctx, channel, jsonData).Err()
+	err = db.RedisClient.Publish(ctx, channelFor(msg), jsonData).Err()
 	if err != nil {
 		log.Println("Failed to publish to Redis:", err)
 	}
 }
 
-func SubscribeToUserChannel(userID string, server *Server) {
+// SubscribeToChannel forwards messages published on channel to the server
+// until the returned subscription is closed.
+func SubscribeToChannel(channel string, server *Server) *redis.PubSub {
 	ctx := context.Background()
-	channel := fmt.Sprintf("clipboard_sync:user:%s", userID)
 	sub := db.RedisClient.Subscribe(ctx, channel)
 	ch := sub.Channel()
 
 	go func() {
-		for {
-			select {
-			case msg, ok := <-ch:
-				if !ok {
-					log.Printf("Redis channel closed for user %s", userID)
-					return
-				}
-
-				var incoming Message
-				if err := json.Unmarshal([]byte(msg.Payload), &incoming); err != nil {
-					log.Println("Failed to unmarshal Redis message:", err)
-					continue
-				}
-
-				server.broadcast <- incoming
-
-			case <-ctx.Done():
-				log.Printf("Redis subscription context cancelled for user %s", userID)
-				return
+		for msg := range ch {
+			var incoming Message
+			if err := json.Unmarshal([]byte(msg.Payload), &incoming); err != nil {
+				log.Println("Failed to unmarshal Redis message:", err)
+				continue
 			}
+
+			server.broadcast <- incoming
 		}
+		log.Printf("Redis subscription closed for %s", channel)
 	}()
+	return sub
 }
@@ -2,27 +2,136 @@ package ws
 
 import (
 	"log"
+	"time"
+
+	"clipsync.com/m/webhooks"
+	"github.com/go-redis/redis/v8"
+)
+
+// Message types other than clipboard content.
+const (
+	// MessageRevokeSessions closes connections authenticated with a session
+	// version older than Message.SessionVersion.
+	MessageRevokeSessions = "revoke_sessions"
+	// MessageRevokeAPIKey closes connections authenticated with the API key
+	// Message.APIKeyID.
+	MessageRevokeAPIKey = "revoke_api_key"
+	// MessageControl carries a JSON ControlMessage for a device rather than
+	// clipboard content.
+	MessageControl = "control"
+	// MessageSpaceMemberRemoved closes the connections Message.UserID opened
+	// on space Message.SpaceID.
+	MessageSpaceMemberRemoved = "space_member_removed"
+	// MessageSpaceDeleted closes every connection opened on Message.SpaceID.
+	MessageSpaceDeleted = "space_deleted"
+	// MessageDisconnect closes Message.UserID's connections, or only those of
+	// device Message.ToDevice when it is set.
+	MessageDisconnect = "disconnect"
 )
 
 type Message struct {
-	UserID     string
-	FromDevice string
-	Payload    []byte
+	ID             string `json:",omitempty"` // clip id, for stored clips
+	Type           string `json:",omitempty"` // empty for clipboard content
+	UserID         string // for space messages, the sending member
+	SpaceID        string `json:",omitempty"` // set for clips shared in a space
+	FromDevice     string
+	ToDevice       string    // optional: deliver only to this device
+	SessionVersion int       `json:",omitempty"`
+	APIKeyID       string    `json:",omitempty"`
+	ContentType    string    `json:",omitempty"`
+	Encrypted      bool      `json:",omitempty"`
+	CreatedAt      time.Time `json:",omitzero"`
+	Payload        []byte
+}
+
+// ConnectionInfo describes a live connection, as listed by
+// Server.Connections.
+type ConnectionInfo struct {
+	DeviceID    string    `json:"device_id"`
+	SpaceID     string    `json:"space_id,omitempty"`
+	Transport   string    `json:"transport"`
+	APIKeyID    string    `json:"api_key_id,omitempty"`
+	CanSend     bool      `json:"can_send"`
+	CanReceive  bool      `json:"can_receive"`
+	ConnectedAt time.Time `json:"connected_at"`
 }
 
+type connectionsQuery struct {
+	userID string
+	reply  chan []ConnectionInfo
+}
+
+// Server routes messages to the connections on this instance. Clients are
+// indexed by user, and those opened on a space also by space; each index
+// entry holds a Redis subscription to the matching channel while it has
+// clients.
 type Server struct {
-	clients    map[string]map[*Client]bool
+	clients    map[string]map[*Client]bool // by user ID
+	spaces     map[string]map[*Client]bool // by space ID
+	subs       map[string]*redis.PubSub    // by Redis channel
 	register   chan *Client
 	unregister chan *Client
 	broadcast  chan Message
+	queries    chan connectionsQuery
 }
 
 func NewServer() *Server {
 	return &Server{
 		clients:    make(map[string]map[*Client]bool),
+		spaces:     make(map[string]map[*Client]bool),
+		subs:       make(map[string]*redis.PubSub),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		broadcast:  make(chan Message),
+		queries:    make(chan connectionsQuery),
+	}
+}
+
+// Register adds a client to the hub. Transports other than WebSocket, such as
+// the gRPC Sync stream, use it to plug into the server.
+func (s *Server) Register(c *Client) {
+	s.register <- c
+}
+
+// Unregister removes a client from the hub and closes its SendChan.
+func (s *Server) Unregister(c *Client) {
+	if c.CanReceive {
+		go markOffline(c.UserID, c.DeviceID)
+	}
+	s.unregister <- c
+}
+
+// Connections lists the user's connections to this server instance. Other
+// instances are not asked; OnlineDevices covers the whole cluster.
+func (s *Server) Connections(userID string) []ConnectionInfo {
+	q := connectionsQuery{userID: userID, reply: make(chan []ConnectionInfo, 1)}
+	s.queries <- q
+	return <-q.reply
+}
+
+// join adds c to index[key], subscribing to channel if it is the first.
+func (s *Server) join(index map[string]map[*Client]bool, key, channel string, c *Client) {
+	if index[key] == nil {
+		index[key] = make(map[*Client]bool)
+		s.subs[channel] = SubscribeToChannel(channel, s)
+	}
+	index[key][c] = true
+}
+
+// leave removes c from index[key], unsubscribing from channel if it was the
+// last.
+func (s *Server) leave(index map[string]map[*Client]bool, key, channel string, c *Client) {
+	clients, ok := index[key]
+	if !ok {
+		return
+	}
+	delete(clients, c)
+	if len(clients) == 0 {
+		delete(index, key)
+		if sub := s.subs[channel]; sub != nil {
+			sub.Close()
+			delete(s.subs, channel)
+		}
 	}
 }
 
@@ -31,43 +140,189 @@ func (s *Server) Run() {
 		select {
 
 		case client := <-s.register:
-			// First client for this user? Initialize map and subscribe to Redis
-			if s.clients[client.UserID] == nil {
-				s.clients[client.UserID] = make(map[*Client]bool)
-				go SubscribeToUserChannel(client.UserID, s)
+			s.join(s.clients, client.UserID, userChannel(client.UserID), client)
+			if client.SpaceID != "" {
+				s.join(s.spaces, client.SpaceID, spaceChannel(client.SpaceID), client)
 			}
-
-			s.clients[client.UserID][client] = true
+			if client.CanReceive {
+				go markOnline(client.UserID, client.DeviceID)
+			}
+			webhooks.Emit(client.UserID, webhooks.EventDeviceConnected, client.webhookData())
 			log.Printf("Client registered: user %s (%s)", client.UserID, client.DeviceID)
 
 		case client := <-s.unregister:
-			if clients, ok := s.clients[client.UserID]; ok {
-				if _, exists := clients[client]; exists {
-					delete(clients, client)
-					close(client.SendChan)
-					log.Printf("Client disconnected: user %s (%s)", client.UserID, client.DeviceID)
-
-					if len(clients) == 0 {
-						delete(s.clients, client.UserID)
-						log.Printf("No more clients for user %s", client.UserID)
-					}
-				}
+			if s.clients[client.UserID][client] {
+				s.drop(client)
+				log.Printf("Client disconnected: user %s (%s)", client.UserID, client.DeviceID)
 			}
 
 		case msg := <-s.broadcast:
-			if clients, ok := s.clients[msg.UserID]; ok {
-				for c := range clients {
-					if c.DeviceID != msg.FromDevice {
-						select {
-						case c.SendChan <- msg.Payload:
-						default:
-							log.Println("Send buffer full, closing connection")
-							close(c.SendChan)
-							delete(clients, c)
-						}
-					}
-				}
+			switch msg.Type {
+			case MessageRevokeSessions:
+				s.revokeSessions(msg)
+			case MessageRevokeAPIKey:
+				s.revokeAPIKey(msg)
+			case MessageSpaceMemberRemoved, MessageSpaceDeleted:
+				s.closeSpace(msg)
+			case MessageDisconnect:
+				s.disconnect(msg)
+			default:
+				s.deliver(msg)
+			}
+
+		case q := <-s.queries:
+			list := make([]ConnectionInfo, 0, len(s.clients[q.userID]))
+			for c := range s.clients[q.userID] {
+				list = append(list, ConnectionInfo{
+					DeviceID:    c.DeviceID,
+					SpaceID:     c.SpaceID,
+					Transport:   c.Transport,
+					APIKeyID:    c.APIKeyID,
+					CanSend:     c.CanSend,
+					CanReceive:  c.CanReceive,
+					ConnectedAt: c.ConnectedAt,
+				})
 			}
+			q.reply <- list
 		}
 	}
 }
+
+// deliver sends a clip or control message to the connections it is for:
+// the space's for space clips, otherwise the user's own (not space)
+// connections. The sending device never gets its own clip back.
+func (s *Server) deliver(msg Message) {
+	clients := s.clients[msg.UserID]
+	if msg.SpaceID != "" {
+		clients = s.spaces[msg.SpaceID]
+	}
+	for c := range clients {
+		if c.UserID == msg.UserID && c.DeviceID == msg.FromDevice {
+			continue
+		}
+		if c.SpaceID != msg.SpaceID {
+			continue
+		}
+		if msg.ToDevice != "" && c.DeviceID != msg.ToDevice {
+			continue
+		}
+		if !c.CanReceive {
+			continue
+		}
+		select {
+		case c.SendChan <- msg:
+		default:
+			log.Println("Send buffer full, closing connection")
+			s.drop(c)
+		}
+	}
+}
+
+// drop removes a registered client from the hub and closes its SendChan,
+// which ends the connection.
+func (s *Server) drop(c *Client) {
+	s.leave(s.clients, c.UserID, userChannel(c.UserID), c)
+	if c.SpaceID != "" {
+		s.leave(s.spaces, c.SpaceID, spaceChannel(c.SpaceID), c)
+	}
+	close(c.SendChan)
+	webhooks.Emit(c.UserID, webhooks.EventDeviceDisconnected, c.webhookData())
+}
+
+// closeSpace drops the space connections of a removed member, or all of them
+// when the space was deleted.
+func (s *Server) closeSpace(msg Message) {
+	for c := range s.spaces[msg.SpaceID] {
+		if msg.Type == MessageSpaceMemberRemoved && c.UserID != msg.UserID {
+			continue
+		}
+		log.Printf("Space access ended, closing connection: user %s (%s) in space %s", c.UserID, c.DeviceID, c.SpaceID)
+		s.drop(c)
+	}
+}
+
+func (s *Server) revokeSessions(msg Message) {
+	clients, ok := s.clients[msg.UserID]
+	if !ok {
+		return
+	}
+	for c := range clients {
+		if c.SessionVersion < msg.SessionVersion {
+			log.Printf("Session revoked, closing connection: user %s (%s)", c.UserID, c.DeviceID)
+			s.drop(c)
+		}
+	}
+}
+
+func (s *Server) revokeAPIKey(msg Message) {
+	clients, ok := s.clients[msg.UserID]
+	if !ok {
+		return
+	}
+	for c := range clients {
+		if c.APIKeyID == msg.APIKeyID {
+			log.Printf("API key revoked, closing connection: user %s (%s)", c.UserID, c.DeviceID)
+			s.drop(c)
+		}
+	}
+}
+
+func (s *Server) disconnect(msg Message) {
+	for c := range s.clients[msg.UserID] {
+		if msg.ToDevice != "" && c.DeviceID != msg.ToDevice {
+			continue
+		}
+		log.Printf("Disconnected by an admin: user %s (%s)", c.UserID, c.DeviceID)
+		s.drop(c)
+	}
+}
+
+// RevokeAPIKey disconnects, on every server instance, the connections that
+// were opened with the given API key.
+func RevokeAPIKey(userID, keyID string) {
+	PublishToRedis(Message{
+		Type:     MessageRevokeAPIKey,
+		UserID:   userID,
+		APIKeyID: keyID,
+	})
+}
+
+// RevokeSessions disconnects, on every server instance, the user's
+// connections that were opened with a session version older than version.
+func RevokeSessions(userID string, version int) {
+	PublishToRedis(Message{
+		Type:           MessageRevokeSessions,
+		UserID:         userID,
+		SessionVersion: version,
+	})
+}
+
+// RemoveSpaceMember disconnects, on every server instance, the connections
+// the user opened on the space.
+func RemoveSpaceMember(spaceID, userID string) {
+	PublishToRedis(Message{
+		Type:    MessageSpaceMemberRemoved,
+		SpaceID: spaceID,
+		UserID:  userID,
+	})
+}
+
+// DeleteSpace disconnects, on every server instance, every connection opened
+// on the space.
+func DeleteSpace(spaceID string) {
+	PublishToRedis(Message{
+		Type:    MessageSpaceDeleted,
+		SpaceID: spaceID,
+	})
+}
+
+// Disconnect closes, on every server instance, the user's connections, or
+// only those of deviceID when it is not empty. The devices can reconnect
+// unless their credentials are revoked as well.
+func Disconnect(userID, deviceID string) {
+	PublishToRedis(Message{
+		Type:     MessageDisconnect,
+		UserID:   userID,
+		ToDevice: deviceID,
+	})
+}
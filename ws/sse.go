@@ -0,0 +1,78 @@
+package ws
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ServeSSE streams clips to a device as Server-Sent Events, for networks that
+// block WebSocket upgrades. Each event's data is the same payload a WebSocket
+// client would receive. Clips are sent with POST /clips.
+func ServeSSE(server *Server, w http.ResponseWriter, r *http.Request) {
+	client, ok := authenticateClient(server, w, r, TransportSSE)
+	if !ok {
+		return
+	}
+	if !client.CanReceive {
+		http.Error(w, "Token is missing the clips:read scope", http.StatusForbidden)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering (nginx)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", (5 * time.Second).Milliseconds())
+	flusher.Flush()
+
+	server.Register(client)
+	defer server.Unregister(client)
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message, ok := <-client.SendChan:
+			if !ok {
+				// Closed by the server, e.g. the session was revoked.
+				return
+			}
+			if err := writeSSEEvent(w, message.Payload); err != nil {
+				log.Println("SSE write error:", err)
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			client.Heartbeat()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSEEvent writes message as one event, splitting it into data lines.
+func writeSSEEvent(w http.ResponseWriter, message []byte) error {
+	var buf bytes.Buffer
+	for _, line := range bytes.Split(message, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(bytes.TrimSuffix(line, []byte("\r")))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -4,7 +4,9 @@ import (
 	"log"
 	"net/http"
 
-	"clipsync.com/m/utils" // Assuming your GenerateJWT is here
+	"clipsync.com/m/auth"
+	"clipsync.com/m/spaces"
+	"clipsync.com/m/utils"
 	"github.com/gorilla/websocket"
 )
 
@@ -16,20 +18,54 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-func ServeWS(server *Server, w http.ResponseWriter, r *http.Request) {
+// Transports a Client can be connected over.
+const (
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+	TransportLongPoll  = "longpoll"
+)
+
+// authenticateClient checks the token and device_id every transport is opened
+// with, and the membership of the optional space_id, and returns an
+// unregistered Client for them. The token comes from the query string, or
+// from a Bearer header for transports that can set one.
+func authenticateClient(server *Server, w http.ResponseWriter, r *http.Request, transport string) (*Client, bool) {
 	// 🔐 Step 1: Get token and device_id from query params
 	tokenStr := r.URL.Query().Get("token")
+	if tokenStr == "" {
+		tokenStr, _ = utils.BearerToken(r)
+	}
 	deviceID := r.URL.Query().Get("device_id")
 
 	if tokenStr == "" || deviceID == "" {
 		http.Error(w, "Missing token or device_id", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
-	// 🔐 Step 2: Validate JWT and extract user_id
-	userID, err := utils.ValidateJWT(tokenStr)
+	// 🔐 Step 2: Validate JWT or API key and extract the user
+	grant, err := auth.Authenticate(tokenStr)
 	if err != nil {
 		http.Error(w, "Invalid or expired token: "+err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	spaceID := r.URL.Query().Get("space_id")
+	if spaceID != "" {
+		if _, err := spaces.Membership(spaceID, grant.User.ID.String()); err != nil {
+			http.Error(w, "Not a member of this space", http.StatusForbidden)
+			return nil, false
+		}
+	}
+	client := NewClient(server, grant, deviceID, spaceID, transport)
+	if !client.CanSend && !client.CanReceive {
+		http.Error(w, "Token has neither the clips:read nor the clips:write scope", http.StatusForbidden)
+		return nil, false
+	}
+	return client, true
+}
+
+func ServeWS(server *Server, w http.ResponseWriter, r *http.Request) {
+	client, ok := authenticateClient(server, w, r, TransportWebSocket)
+	if !ok {
 		return
 	}
 
@@ -40,16 +76,9 @@ func ServeWS(server *Server, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// ✅ Step 4: Create and register client
-	client := &Client{
-		UserID:   userID,
-		DeviceID: deviceID,
-		Conn:     conn,
-		SendChan: make(chan []byte, 256),
-		Server:   server,
-	}
-
-	client.Server.register <- client
+	// ✅ Step 4: Register client
+	client.Conn = conn
+	client.Server.Register(client)
 
 	go client.WritePump()
 	go client.ReadPump()